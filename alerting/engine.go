@@ -0,0 +1,346 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package alerting
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/dbprovider"
+	"github.com/skyrings/skyring-common/event"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultEvaluationInterval = time.Minute
+	ALERT_CONTEXT             = "alerting"
+
+	SERIES_NAME = "SeriesName"
+	RULE_ID     = "RuleId"
+	RULE_NAME   = "RuleName"
+)
+
+// Engine evaluates the enabled alert rules periodically against the
+// monitoring manager and raises and clears the corresponding app events.
+type Engine struct {
+	manager    monitoring.MonitoringManagerInterface
+	dbProvider dbprovider.DbInterface
+	interval   time.Duration
+
+	mutex   sync.Mutex
+	running bool
+	stop    chan struct{}
+}
+
+func NewEngine(manager monitoring.MonitoringManagerInterface, dbProvider dbprovider.DbInterface, interval time.Duration) *Engine {
+	if interval <= 0 {
+		interval = DefaultEvaluationInterval
+	}
+	return &Engine{manager: manager, dbProvider: dbProvider, interval: interval}
+}
+
+// Start evaluates the rules every interval until Stop is called.
+func (e *Engine) Start() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.running {
+		return
+	}
+	e.running = true
+	e.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				e.Evaluate(now)
+			case <-stop:
+				return
+			}
+		}
+	}(e.stop)
+}
+
+func (e *Engine) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !e.running {
+		return
+	}
+	close(e.stop)
+	e.running = false
+}
+
+// Evaluate evaluates all the enabled rules once.
+func (e *Engine) Evaluate(now time.Time) {
+	rules, err := GetAlertRules()
+	if err != nil {
+		logger.Get().Error("%s - Failed to fetch the alert rules. Error: %v", ALERT_CONTEXT, err)
+		return
+	}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := e.EvaluateRule(rule, now); err != nil {
+			logger.Get().Error("%s - Failed to evaluate the alert rule %s. Error: %v", ALERT_CONTEXT, rule.Name, err)
+		}
+	}
+}
+
+// EvaluateRule moves the alerts of the series selected by the rule as per
+// their latest values. Series without data keep their alerts as they are.
+func (e *Engine) EvaluateRule(rule models.AlertRule, now time.Time) error {
+	if err := ValidateRule(rule); err != nil {
+		return err
+	}
+	series, err := e.manager.QueryDB(rule.Query)
+	if err != nil {
+		return err
+	}
+	states, err := getAlertStates(rule.RuleId)
+	if err != nil {
+		return err
+	}
+	for _, currentSeries := range series {
+		value, ok := seriesValue(rule, currentSeries)
+		if !ok {
+			continue
+		}
+		state, ok := states[currentSeries.Name]
+		if !ok {
+			state = models.AlertState{RuleId: rule.RuleId, SeriesName: currentSeries.Name, State: STATE_INACTIVE, Since: now}
+		}
+		previous := state.State
+		if nextState(rule, &state, value, now) {
+			severity := rule.Severity
+			if state.State == STATE_INACTIVE {
+				severity = models.ALARM_STATUS_CLEARED
+			}
+			eventId, err := e.raise(rule, state, severity, now)
+			if err != nil {
+				logger.Get().Error("%s - Failed to raise the %v alert of rule %s for %s. Error: %v", ALERT_CONTEXT, severity, rule.Name, currentSeries.Name, err)
+			}
+			state.EventId = eventId
+		}
+		if state.State == STATE_INACTIVE && previous == STATE_INACTIVE {
+			continue
+		}
+		if err := saveAlertState(state); err != nil {
+			logger.Get().Error("%s - Failed to save the alert state of rule %s for %s. Error: %v", ALERT_CONTEXT, rule.Name, currentSeries.Name, err)
+		}
+	}
+	return nil
+}
+
+// ClearRuleAlerts clears the firing alerts of the rule, as when the rule is
+// removed or disabled.
+func (e *Engine) ClearRuleAlerts(rule models.AlertRule) error {
+	states, err := getAlertStates(rule.RuleId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, state := range states {
+		if state.State == STATE_FIRING {
+			if _, err := e.raise(rule, state, models.ALARM_STATUS_CLEARED, now); err != nil {
+				logger.Get().Error("%s - Failed to clear the alert of rule %s for %s. Error: %v", ALERT_CONTEXT, rule.Name, state.SeriesName, err)
+			}
+		}
+	}
+	return removeAlertStates(rule.RuleId)
+}
+
+// raise records the app event of the alert.
+func (e *Engine) raise(rule models.AlertRule, state models.AlertState, severity models.AlarmStatus, now time.Time) (uuid.UUID, error) {
+	eventId, err := uuid.New()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	appEvent := models.AppEvent{
+		EventId:            *eventId,
+		ClusterId:          rule.ClusterId,
+		NotificationEntity: rule.NotificationEntity,
+		EntityId:           rule.EntityId,
+		NodeName:           rule.Query.NodeOf(state.SeriesName),
+		Timestamp:          now,
+		Name:               fmt.Sprintf("%s: %s", rule.Name, state.SeriesName),
+		Description:        rule.Description,
+		Severity:           severity,
+		Notify:             rule.Notify,
+		Tags: map[string]string{
+			RULE_ID:                rule.RuleId.String(),
+			RULE_NAME:              rule.Name,
+			SERIES_NAME:            state.SeriesName,
+			models.CURRENT_VALUE:   strconv.FormatFloat(state.Value, 'E', -1, 64),
+			models.THRESHOLD_TYPE:  strings.ToUpper(rule.Severity.String()),
+			models.THRESHOLD_VALUE: strconv.FormatFloat(rule.Threshold, 'E', -1, 64),
+		},
+	}
+	for label, value := range rule.Labels {
+		appEvent.Tags[label] = value
+	}
+	if severity == models.ALARM_STATUS_CLEARED {
+		appEvent.Message = fmt.Sprintf("%s of %s with value %v back to normal", rule.Name, state.SeriesName, state.Value)
+		// The alert fired is recorded already, clear it without waiting
+		return appEvent.EventId, event.RaiseClearingAppEvent(appEvent, state.EventId, e.dbProvider, ALERT_CONTEXT)
+	}
+	appEvent.Message = fmt.Sprintf("%s of %s with value %v is %s the threshold %v", rule.Name, state.SeriesName, state.Value, comparatorText(rule.Comparator), rule.Threshold)
+	return appEvent.EventId, event.RaiseAppEvent(appEvent, e.dbProvider, ALERT_CONTEXT)
+}
+
+func comparatorText(comparator string) string {
+	if comparator == COMPARATOR_BELOW {
+		return "below"
+	}
+	return "above"
+}
+
+// AddAlertRule validates the rule and saves it with a new id.
+func AddAlertRule(rule models.AlertRule) (uuid.UUID, error) {
+	if err := ValidateRule(rule); err != nil {
+		return uuid.UUID{}, err
+	}
+	ruleId, err := uuid.New()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	rule.RuleId = *ruleId
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_RULES)
+	if err := coll.Insert(rule); err != nil {
+		return uuid.UUID{}, fmt.Errorf("Failed to save the alert rule %s. Error: %v", rule.Name, err)
+	}
+	return rule.RuleId, nil
+}
+
+// UpdateAlertRule validates the rule and replaces the saved one. The alerts
+// of the rule are cleared as the rule may no longer select their series.
+func (e *Engine) UpdateAlertRule(rule models.AlertRule) error {
+	if err := ValidateRule(rule); err != nil {
+		return err
+	}
+	previous, err := GetAlertRule(rule.RuleId)
+	if err != nil {
+		return err
+	}
+	if err := e.ClearRuleAlerts(previous); err != nil {
+		return err
+	}
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_RULES)
+	if err := coll.Update(bson.M{"ruleid": rule.RuleId}, rule); err != nil {
+		return fmt.Errorf("Failed to update the alert rule %s. Error: %v", rule.Name, err)
+	}
+	return nil
+}
+
+// RemoveAlertRule clears the alerts of the rule and removes it.
+func (e *Engine) RemoveAlertRule(ruleId uuid.UUID) error {
+	rule, err := GetAlertRule(ruleId)
+	if err != nil {
+		return err
+	}
+	if err := e.ClearRuleAlerts(rule); err != nil {
+		return err
+	}
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_RULES)
+	if err := coll.Remove(bson.M{"ruleid": ruleId}); err != nil {
+		return fmt.Errorf("Failed to remove the alert rule %v. Error: %v", ruleId, err)
+	}
+	return nil
+}
+
+func GetAlertRule(ruleId uuid.UUID) (models.AlertRule, error) {
+	var rule models.AlertRule
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_RULES)
+	if err := coll.Find(bson.M{"ruleid": ruleId}).One(&rule); err != nil {
+		return models.AlertRule{}, fmt.Errorf("Failed to fetch the alert rule %v. Error: %v", ruleId, err)
+	}
+	return rule, nil
+}
+
+func GetAlertRules() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_RULES)
+	if err := coll.Find(nil).All(&rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetAlertStates returns the pending and firing alerts of the rule.
+func GetAlertStates(ruleId uuid.UUID) ([]models.AlertState, error) {
+	states, err := getAlertStates(ruleId)
+	if err != nil {
+		return nil, err
+	}
+	var result []models.AlertState
+	for _, state := range states {
+		if state.State != STATE_INACTIVE {
+			result = append(result, state)
+		}
+	}
+	return result, nil
+}
+
+func getAlertStates(ruleId uuid.UUID) (map[string]models.AlertState, error) {
+	var states []models.AlertState
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_STATES)
+	if err := coll.Find(bson.M{"ruleid": ruleId}).All(&states); err != nil {
+		return nil, fmt.Errorf("Failed to fetch the alert states of rule %v. Error: %v", ruleId, err)
+	}
+	result := make(map[string]models.AlertState)
+	for _, state := range states {
+		result[state.SeriesName] = state
+	}
+	return result, nil
+}
+
+func saveAlertState(state models.AlertState) error {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_STATES)
+	_, err := coll.Upsert(bson.M{"ruleid": state.RuleId, "seriesname": state.SeriesName}, state)
+	return err
+}
+
+func removeAlertStates(ruleId uuid.UUID) error {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ALERT_STATES)
+	if _, err := coll.RemoveAll(bson.M{"ruleid": ruleId}); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package alerting
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"math"
+	"time"
+)
+
+// Comparators of the value of a series with the thresholds of a rule
+const (
+	COMPARATOR_ABOVE = ">"
+	COMPARATOR_BELOW = "<"
+)
+
+// The states of the alert of a series
+const (
+	STATE_INACTIVE = "inactive"
+	STATE_PENDING  = "pending"
+	STATE_FIRING   = "firing"
+)
+
+// The function reducing a series to its latest value
+const FUNCTION_LAST = "last"
+
+// ValidateRule checks the rule can be evaluated.
+func ValidateRule(rule models.AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("Alert rule name not specified")
+	}
+	if err := rule.Query.Valid(); err != nil {
+		return fmt.Errorf("Invalid query of alert rule %s. Error: %v", rule.Name, err)
+	}
+	switch rule.Function {
+	case "", FUNCTION_LAST, monitoring.AGGREGATE_AVG, monitoring.AGGREGATE_MIN, monitoring.AGGREGATE_MAX, monitoring.AGGREGATE_SUM:
+	default:
+		return fmt.Errorf("Unsupported function %s of alert rule %s", rule.Function, rule.Name)
+	}
+	if rule.Comparator != COMPARATOR_ABOVE && rule.Comparator != COMPARATOR_BELOW {
+		return fmt.Errorf("Unsupported comparator %s of alert rule %s", rule.Comparator, rule.Name)
+	}
+	if rule.ClearThreshold != nil {
+		// The clear threshold is on the safe side of the threshold
+		if (rule.Comparator == COMPARATOR_ABOVE && *rule.ClearThreshold > rule.Threshold) ||
+			(rule.Comparator == COMPARATOR_BELOW && *rule.ClearThreshold < rule.Threshold) {
+			return fmt.Errorf("Clear threshold %v of alert rule %s is beyond the threshold %v", *rule.ClearThreshold, rule.Name, rule.Threshold)
+		}
+	}
+	if _, err := forDuration(rule); err != nil {
+		return err
+	}
+	switch rule.Severity {
+	case models.ALARM_STATUS_CRITICAL, models.ALARM_STATUS_MAJOR, models.ALARM_STATUS_MINOR, models.ALARM_STATUS_WARNING:
+	default:
+		return fmt.Errorf("Unsupported severity %v of alert rule %s", rule.Severity, rule.Name)
+	}
+	return nil
+}
+
+// forDuration returns how long the threshold must be breached before the
+// alert fires.
+func forDuration(rule models.AlertRule) (time.Duration, error) {
+	if rule.For == "" {
+		return 0, nil
+	}
+	duration, err := monitoring.ParseDuration(rule.For)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid for duration of alert rule %s. Error: %v", rule.Name, err)
+	}
+	return duration, nil
+}
+
+// seriesValue reduces the data points of the series using the function of
+// the rule. It returns false if the series holds no number.
+func seriesValue(rule models.AlertRule, series monitoring.Series) (float64, bool) {
+	var value float64
+	if rule.Function == "" || rule.Function == FUNCTION_LAST {
+		value = math.NaN()
+		for index := len(series.DataPoints) - 1; index >= 0; index-- {
+			if !math.IsNaN(series.DataPoints[index].Value) {
+				value = series.DataPoints[index].Value
+				break
+			}
+		}
+	} else {
+		values := make([]float64, len(series.DataPoints))
+		for index, point := range series.DataPoints {
+			values[index] = point.Value
+		}
+		value = monitoring.Aggregation{Function: rule.Function}.Reduce(values)
+	}
+	return value, !math.IsNaN(value)
+}
+
+// breached tells if the value is beyond the threshold of the rule.
+func breached(rule models.AlertRule, value float64) bool {
+	if rule.Comparator == COMPARATOR_BELOW {
+		return value < rule.Threshold
+	}
+	return value > rule.Threshold
+}
+
+// cleared tells if the value is back within the clear threshold of the rule,
+// which defaults to the threshold.
+func cleared(rule models.AlertRule, value float64) bool {
+	clearThreshold := rule.Threshold
+	if rule.ClearThreshold != nil {
+		clearThreshold = *rule.ClearThreshold
+	}
+	if rule.Comparator == COMPARATOR_BELOW {
+		return value >= clearThreshold
+	}
+	return value <= clearThreshold
+}
+
+// nextState moves the alert of a series as per its latest value. It returns
+// true if the alert fired or cleared.
+func nextState(rule models.AlertRule, state *models.AlertState, value float64, now time.Time) bool {
+	state.Value = value
+	forDuration, _ := forDuration(rule)
+	switch state.State {
+	case STATE_FIRING:
+		if cleared(rule, value) {
+			state.State = STATE_INACTIVE
+			state.Since = now
+			return true
+		}
+		return false
+	case STATE_PENDING:
+		if !breached(rule, value) {
+			state.State = STATE_INACTIVE
+			state.Since = now
+			return false
+		}
+	default:
+		if !breached(rule, value) {
+			return false
+		}
+		state.State = STATE_PENDING
+		state.Since = now
+	}
+	if now.Sub(state.Since) >= forDuration {
+		state.State = STATE_FIRING
+		state.Since = now
+		return true
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package anomaly
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/dbprovider"
+	"github.com/skyrings/skyring-common/event"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultAnomalyHistory = "-6h"
+	DefaultAnomalyStep    = "5m"
+
+	SERIES_NAME = "SeriesName"
+	BASELINE    = "Baseline"
+	STD_DEV     = "StdDev"
+	Z_SCORE     = "ZScore"
+	DIRECTION   = "Direction"
+)
+
+// The collectd resources checked for anomalies by default
+var DefaultAnomalyResources = []string{
+	monitoring.CPU,
+	monitoring.MEMORY,
+	monitoring.DISK,
+	monitoring.INTERFACE,
+	"ping",
+}
+
+// Detector checks the series of the resources of the active nodes against
+// their own baselines and raises an app event for every series turning
+// anomalous, clearing it once the series is back to normal.
+type Detector struct {
+	manager    monitoring.MonitoringManagerInterface
+	dbProvider dbprovider.DbInterface
+	Config     monitoring.AnomalyConfig
+	Resources  []string
+	History    string
+	Step       string
+}
+
+func NewDetector(manager monitoring.MonitoringManagerInterface, dbProvider dbprovider.DbInterface) *Detector {
+	return &Detector{
+		manager:    manager,
+		dbProvider: dbProvider,
+		Config:     monitoring.DefaultAnomalyConfig,
+		Resources:  DefaultAnomalyResources,
+		History:    DefaultAnomalyHistory,
+		Step:       DefaultAnomalyStep,
+	}
+}
+
+// Run checks all the resources of all the active nodes once.
+func (d *Detector) Run(ctxt string) error {
+	var nodes []models.Node
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_STORAGE_NODES)
+	if err := coll.Find(bson.M{"state": models.NODE_STATE_ACTIVE}).All(&nodes); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return fmt.Errorf("%s - Failed to fetch the nodes. Error: %v", ctxt, err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	// The series carry the host names with the dots replaced
+	nodesByName := make(map[string]models.Node)
+	var hostnames []string
+	for _, node := range nodes {
+		nodesByName[monitoring.EntityName(node.Hostname)] = node
+		hostnames = append(hostnames, node.Hostname)
+	}
+	for _, resource := range d.Resources {
+		query := monitoring.MetricQuery{
+			Resource:    resource,
+			Nodes:       hostnames,
+			Interval:    d.History,
+			Aggregation: &monitoring.Aggregation{Function: monitoring.AGGREGATE_AVG, Step: d.Step},
+		}
+		series, err := d.manager.QueryDB(query)
+		if err != nil {
+			logger.Get().Error("%s - Failed to fetch the %s series of the nodes. Error: %v", ctxt, resource, err)
+			continue
+		}
+		for _, currentSeries := range series {
+			node, ok := nodesByName[query.NodeOf(currentSeries.Name)]
+			if !ok {
+				continue
+			}
+			anomaly, anomalous, err := monitoring.DetectAnomaly(currentSeries, d.Config)
+			if err != nil {
+				// Too few points to tell, as when the node stops
+				// reporting, the state is left as is
+				continue
+			}
+			if err := d.update(ctxt, node, currentSeries.Name, anomaly, anomalous); err != nil {
+				logger.Get().Error("%s - Failed to update the anomaly state of %s. Error: %v", ctxt, currentSeries.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// update raises the event of the series turning anomalous or back to normal
+// and saves its state.
+func (d *Detector) update(ctxt string, node models.Node, seriesName string, anomaly monitoring.Anomaly, anomalous bool) error {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_ANOMALIES)
+
+	var state models.AnomalyState
+	if err := coll.Find(bson.M{"nodeid": node.NodeId, "seriesname": seriesName}).One(&state); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if state.Active == anomalous {
+		return nil
+	}
+	if !anomalous {
+		// Report the clearing against the anomaly raised
+		anomaly = state.Anomaly
+	}
+	eventId, err := d.raise(ctxt, node, seriesName, anomaly, anomalous, state.EventId)
+	if err != nil {
+		logger.Get().Error("%s - Failed to raise the anomaly event of %s. Error: %v", ctxt, seriesName, err)
+	}
+	state = models.AnomalyState{
+		NodeId:     node.NodeId,
+		SeriesName: seriesName,
+		Active:     anomalous,
+		Since:      time.Now(),
+		Anomaly:    anomaly,
+		EventId:    eventId,
+	}
+	_, err = coll.Upsert(bson.M{"nodeid": node.NodeId, "seriesname": seriesName}, state)
+	return err
+}
+
+// raise records the event of the series turning anomalous, or back to
+// normal clearing the event of the anomaly given.
+func (d *Detector) raise(ctxt string, node models.Node, seriesName string, anomaly monitoring.Anomaly, anomalous bool, anomalyEventId uuid.UUID) (uuid.UUID, error) {
+	eventId, err := uuid.New()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	// The series name without the collection and the node
+	resource := seriesName
+	if parts := strings.SplitN(seriesName, ".", 3); len(parts) == 3 {
+		resource = parts[2]
+	}
+	appEvent := models.AppEvent{
+		EventId:            *eventId,
+		ClusterId:          node.ClusterId,
+		NotificationEntity: models.NOTIFICATION_ENTITY_HOST,
+		EntityId:           node.NodeId,
+		NodeId:             node.NodeId,
+		NodeName:           node.Hostname,
+		Timestamp:          time.Now(),
+		Name:               fmt.Sprintf("Anomalous %s on %s", resource, node.Hostname),
+		Severity:           models.ALARM_STATUS_WARNING,
+		Tags: map[string]string{
+			SERIES_NAME:          seriesName,
+			models.CURRENT_VALUE: strconv.FormatFloat(anomaly.Value, 'E', -1, 64),
+			BASELINE:             strconv.FormatFloat(anomaly.Baseline, 'E', -1, 64),
+			STD_DEV:              strconv.FormatFloat(anomaly.StdDev, 'E', -1, 64),
+			Z_SCORE:              strconv.FormatFloat(anomaly.ZScore, 'f', 2, 64),
+			DIRECTION:            anomaly.Direction,
+		},
+	}
+	if !anomalous {
+		appEvent.Severity = models.ALARM_STATUS_CLEARED
+		appEvent.Message = fmt.Sprintf("%s of %s back to its baseline", resource, node.Hostname)
+		// The anomaly is recorded already, clear it without waiting
+		return appEvent.EventId, event.RaiseClearingAppEvent(appEvent, anomalyEventId, d.dbProvider, ctxt)
+	}
+	appEvent.Message = fmt.Sprintf("%s of %s with value %v is unusually %s against its baseline of %v", resource, node.Hostname, anomaly.Value, anomaly.Direction, anomaly.Baseline)
+	return appEvent.EventId, event.RaiseAppEvent(appEvent, d.dbProvider, ctxt)
+}
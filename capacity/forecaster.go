@@ -0,0 +1,280 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package capacity
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/dbprovider"
+	"github.com/skyrings/skyring-common/event"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"github.com/skyrings/skyring-common/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultForecastHistory    = "-30d"
+	DefaultForecastStep       = "1h"
+	DefaultEarlyWarningWindow = 30 * 24 * time.Hour
+	DefaultWarningLevel       = 80
+
+	MODEL           = "Model"
+	TIME_TO_WARNING = "TimeToWarning"
+	TIME_TO_FULL    = "TimeToFull"
+)
+
+// Forecaster forecasts the utilization of the clusters, their storages and
+// storage profiles, and of the system from the history of their series. It
+// stores the forecasts in the summaries and raises an early warning event
+// when an entity is estimated to cross its warning threshold within Window.
+type Forecaster struct {
+	manager    monitoring.MonitoringManagerInterface
+	dbProvider dbprovider.DbInterface
+	History    string
+	Step       string
+	Window     time.Duration
+}
+
+// forecastEntity is the entity a forecast and its events are about.
+type forecastEntity struct {
+	Name               string
+	ClusterId          uuid.UUID
+	NotificationEntity models.NotificationEntity
+	EntityId           uuid.UUID
+}
+
+func NewForecaster(manager monitoring.MonitoringManagerInterface, dbProvider dbprovider.DbInterface) *Forecaster {
+	return &Forecaster{
+		manager:    manager,
+		dbProvider: dbProvider,
+		History:    DefaultForecastHistory,
+		Step:       DefaultForecastStep,
+		Window:     DefaultEarlyWarningWindow,
+	}
+}
+
+// Run forecasts the utilization of all the clusters and of the system.
+func (f *Forecaster) Run(ctxt string) error {
+	now := time.Now()
+	clusters, err := util.GetClusters(nil)
+	if err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("%s - Failed to fetch the clusters. Error: %v", ctxt, err)
+	}
+	for _, cluster := range clusters {
+		if err := f.ForecastCluster(ctxt, cluster, now); err != nil {
+			logger.Get().Error("%s - Failed to forecast the utilization of cluster %v. Error: %v", ctxt, cluster.Name, err)
+		}
+	}
+	return f.ForecastSystem(ctxt, now)
+}
+
+// ForecastCluster forecasts the utilization of the cluster, its storages
+// and storage profiles into the cluster summary. The previous forecasts are
+// kept for the entities lacking enough history.
+func (f *Forecaster) ForecastCluster(ctxt string, cluster models.Cluster, now time.Time) error {
+	var previous models.ClusterSummary
+	if summaries, err := util.GetClusterSummaries(bson.M{"clusterid": cluster.ClusterId}); err == nil && len(summaries) != 0 {
+		previous = summaries[0]
+	}
+
+	usageForecast := previous.UsageForecast
+	entity := forecastEntity{
+		Name:               fmt.Sprintf("cluster %s", cluster.Name),
+		ClusterId:          cluster.ClusterId,
+		NotificationEntity: models.NOTIFICATION_ENTITY_CLUSTER,
+		EntityId:           cluster.ClusterId,
+	}
+	if forecast, ok := f.forecast(ctxt, monitoring.MetricQuery{Resource: monitoring.CLUSTER_UTILIZATION, NodeName: cluster.Name}, warningLevel(monitoring.CLUSTER_UTILIZATION, cluster.Monitoring.Plugins), now); ok {
+		f.notify(ctxt, entity, previous.UsageForecast, forecast)
+		usageForecast = forecast
+	}
+
+	storageForecasts := make(map[string]monitoring.Forecast)
+	var storages models.Storages
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_STORAGE)
+	if err := coll.Find(bson.M{"clusterid": cluster.ClusterId}).All(&storages); err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("%s - Failed to fetch the storages of cluster %v. Error: %v", ctxt, cluster.Name, err)
+	}
+	for _, storage := range storages {
+		if forecast, ok := previous.StorageForecasts[storage.Name]; ok {
+			storageForecasts[storage.Name] = forecast
+		}
+		entity := forecastEntity{
+			Name:               fmt.Sprintf("storage %s of cluster %s", storage.Name, cluster.Name),
+			ClusterId:          cluster.ClusterId,
+			NotificationEntity: models.NOTIFICATION_ENTITY_STORAGE,
+			EntityId:           storage.StorageId,
+		}
+		if forecast, ok := f.forecast(ctxt, monitoring.MetricQuery{Resource: monitoring.STORAGE_UTILIZATION, ParentName: cluster.Name, NodeName: storage.Name}, warningLevel(monitoring.STORAGE_UTILIZATION, cluster.Monitoring.Plugins), now); ok {
+			f.notify(ctxt, entity, previous.StorageForecasts[storage.Name], forecast)
+			storageForecasts[storage.Name] = forecast
+		}
+	}
+
+	storageProfileForecasts := make(map[string]monitoring.Forecast)
+	for profile := range cluster.StorageProfileUsage {
+		if forecast, ok := previous.StorageProfileForecasts[profile]; ok {
+			storageProfileForecasts[profile] = forecast
+		}
+		entity := forecastEntity{
+			Name:               fmt.Sprintf("storage profile %s of cluster %s", profile, cluster.Name),
+			ClusterId:          cluster.ClusterId,
+			NotificationEntity: models.NOTIFICATION_ENTITY_STORAGE_PROFILE,
+			EntityId:           cluster.ClusterId,
+		}
+		if forecast, ok := f.forecast(ctxt, monitoring.MetricQuery{Resource: monitoring.STORAGE_PROFILE_UTILIZATION, ParentName: cluster.Name, NodeName: profile}, warningLevel(monitoring.STORAGE_PROFILE_UTILIZATION, cluster.Monitoring.Plugins), now); ok {
+			f.notify(ctxt, entity, previous.StorageProfileForecasts[profile], forecast)
+			storageProfileForecasts[profile] = forecast
+		}
+	}
+
+	util.UpdateDb(
+		bson.M{"clusterid": cluster.ClusterId},
+		bson.M{"usageforecast": usageForecast, "storageforecasts": storageForecasts, "storageprofileforecasts": storageProfileForecasts},
+		models.COLL_NAME_CLUSTER_SUMMARY,
+		ctxt)
+	return nil
+}
+
+// ForecastSystem forecasts the utilization of the system and of the storage
+// profiles across the clusters into the system summary.
+func (f *Forecaster) ForecastSystem(ctxt string, now time.Time) error {
+	system, err := util.GetSystem()
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil
+		}
+		return fmt.Errorf("%s - Failed to fetch the system summary. Error: %v", ctxt, err)
+	}
+	systemThresholds := monitoring.GetSystemDefaultThresholdValues()
+
+	usageForecast := system.UsageForecast
+	entity := forecastEntity{Name: monitoring.SYSTEM, NotificationEntity: models.NOTIFICATION_ENTITY_CLUSTER}
+	if forecast, ok := f.forecast(ctxt, monitoring.MetricQuery{Resource: monitoring.SYSTEM_UTILIZATION, NodeName: monitoring.SYSTEM}, DefaultWarningLevel, now); ok {
+		f.notify(ctxt, entity, system.UsageForecast, forecast)
+		usageForecast = forecast
+	}
+
+	storageProfileForecasts := make(map[string]monitoring.Forecast)
+	for profile := range system.StorageProfileUsage {
+		if forecast, ok := system.StorageProfileForecasts[profile]; ok {
+			storageProfileForecasts[profile] = forecast
+		}
+		entity := forecastEntity{Name: fmt.Sprintf("storage profile %s", profile), NotificationEntity: models.NOTIFICATION_ENTITY_STORAGE_PROFILE}
+		level := warningLevel(monitoring.STORAGE_PROFILE_UTILIZATION, []monitoring.Plugin{systemThresholds[monitoring.STORAGE_PROFILE_UTILIZATION]})
+		if forecast, ok := f.forecast(ctxt, monitoring.MetricQuery{Resource: monitoring.STORAGE_PROFILE_UTILIZATION, ParentName: monitoring.SYSTEM, NodeName: profile}, level, now); ok {
+			f.notify(ctxt, entity, system.StorageProfileForecasts[profile], forecast)
+			storageProfileForecasts[profile] = forecast
+		}
+	}
+
+	util.UpdateDb(
+		bson.M{"name": monitoring.SYSTEM},
+		bson.M{"usageforecast": usageForecast, "storageprofileforecasts": storageProfileForecasts},
+		models.COLL_NAME_SKYRING_UTILIZATION,
+		ctxt)
+	return nil
+}
+
+// forecast fits the history of the percentage used series selected by the
+// query. It returns false if the series lacks enough history.
+func (f *Forecaster) forecast(ctxt string, query monitoring.MetricQuery, warningLevel float64, now time.Time) (monitoring.Forecast, bool) {
+	query.Interval = f.History
+	query.Aggregation = &monitoring.Aggregation{Function: monitoring.AGGREGATE_AVG, Step: f.Step}
+	series, err := f.manager.QueryDB(query)
+	if err != nil {
+		logger.Get().Error("%s - Failed to fetch the history of %s of %s. Error: %v", ctxt, query.Resource, query.NodeName, err)
+		return monitoring.Forecast{}, false
+	}
+	for _, currentSeries := range series {
+		if strings.HasSuffix(currentSeries.Name, "."+monitoring.USAGE_PERCENTAGE) {
+			return monitoring.ForecastSeries(currentSeries, warningLevel, f.Window, now)
+		}
+	}
+	return monitoring.Forecast{}, false
+}
+
+// warningLevel returns the warning threshold of the plugin, or
+// DefaultWarningLevel if the plugin has none.
+func warningLevel(pluginName string, plugins []monitoring.Plugin) float64 {
+	pluginIndex := monitoring.GetPluginIndex(pluginName, plugins)
+	if pluginIndex == -1 {
+		return DefaultWarningLevel
+	}
+	for _, config := range plugins[pluginIndex].Configs {
+		if config.Category == monitoring.THRESHOLD && config.Type == monitoring.WARNING {
+			if level, err := strconv.ParseFloat(config.Value, 64); err == nil {
+				return level
+			}
+		}
+	}
+	return DefaultWarningLevel
+}
+
+// notify raises the early warning event of the entity when the forecast
+// enters the window, and clears it when the forecast leaves the window.
+func (f *Forecaster) notify(ctxt string, entity forecastEntity, previous monitoring.Forecast, current monitoring.Forecast) {
+	if previous.EarlyWarning == current.EarlyWarning {
+		return
+	}
+	eventId, err := uuid.New()
+	if err != nil {
+		logger.Get().Error("%s - Error creating the event id. Error: %v", ctxt, err)
+		return
+	}
+	appEvent := models.AppEvent{
+		EventId:            *eventId,
+		ClusterId:          entity.ClusterId,
+		NotificationEntity: entity.NotificationEntity,
+		EntityId:           entity.EntityId,
+		Timestamp:          current.UpdatedAt,
+		Name:               fmt.Sprintf("Capacity early warning of %s", entity.Name),
+		Severity:           models.ALARM_STATUS_WARNING,
+		Notify:             true,
+		Tags: map[string]string{
+			models.CURRENT_VALUE:   strconv.FormatFloat(current.Current, 'E', -1, 64),
+			models.THRESHOLD_VALUE: strconv.FormatFloat(current.WarningLevel, 'E', -1, 64),
+			MODEL:                  current.Model,
+			TIME_TO_WARNING:        strconv.FormatInt(current.TimeToWarning, 10),
+			TIME_TO_FULL:           strconv.FormatInt(current.TimeToFull, 10),
+		},
+	}
+	if current.EarlyWarning {
+		appEvent.Message = fmt.Sprintf("The utilization of %s is estimated to cross %v%% on %v", entity.Name, current.WarningLevel, current.WarningAt)
+		if current.TimeToFull >= 0 {
+			appEvent.Description = fmt.Sprintf("At the current growth of %.2f%% per day, %s is estimated to be full on %v", current.GrowthPerDay, entity.Name, current.FullAt)
+		}
+	} else {
+		appEvent.Severity = models.ALARM_STATUS_CLEARED
+		appEvent.Message = fmt.Sprintf("The utilization of %s is no longer estimated to cross %v%% within %v", entity.Name, current.WarningLevel, f.Window)
+	}
+	if current.EarlyWarning {
+		err = event.RaiseAppEvent(appEvent, f.dbProvider, ctxt)
+	} else {
+		// The early warning is recorded already, clear it without waiting
+		err = event.RaiseClearingAppEvent(appEvent, uuid.UUID{}, f.dbProvider, ctxt)
+	}
+	if err != nil {
+		logger.Get().Error("%s - Failed to raise the capacity early warning event of %s. Error: %v", ctxt, entity.Name, err)
+	}
+}
@@ -92,6 +92,7 @@ type MonitoringDBconfig struct {
 	Password       string `json:"password"`
 	ManagerName    string `json:"managername"`
 	ConfigFilePath string `json:"configfilepath"`
+	NamingFilePath string `json:"namingfilepath"`
 }
 
 type NodeManagerConfig struct {
@@ -18,6 +18,7 @@ import (
 	"github.com/skyrings/skyring-common/models"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"gopkg.in/mgo.v2/bson"
+	"time"
 )
 
 var (
@@ -25,7 +26,8 @@ var (
 )
 
 // User returns the Mail notifier.
-func (m MongoDb) MailNotifier(ctxt string) (models.MailNotifier, error) {
+func (m MongoDb) MailNotifier(ctxt string) (mailNotifier models.MailNotifier, e error) {
+	defer observe("MailNotifier", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_MAIL_NOTIFIER)
 	defer m.Close(c)
 	var notifier []models.MailNotifier
@@ -39,7 +41,8 @@ func (m MongoDb) MailNotifier(ctxt string) (models.MailNotifier, error) {
 
 // Save mail notifier adds a new mail notifier, it replaces the existing one if there
 // is already a notifier available.
-func (m MongoDb) SaveMailNotifier(ctxt string, notifier models.MailNotifier) error {
+func (m MongoDb) SaveMailNotifier(ctxt string, notifier models.MailNotifier) (e error) {
+	defer observe("SaveMailNotifier", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_MAIL_NOTIFIER)
 	defer m.Close(c)
 	_, err := c.Upsert(bson.M{}, bson.M{"$set": notifier})
@@ -0,0 +1,32 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package mongodb
+
+import (
+	"github.com/skyrings/skyring-common/tools/metrics"
+	"time"
+)
+
+var (
+	daoDurations = metrics.NewHistogram("skyring_dao_duration_seconds", "Durations of the db operations of the DAOs.", nil, "operation")
+	daoErrors    = metrics.NewCounter("skyring_dao_errors_total", "Failed db operations of the DAOs.", "operation")
+)
+
+// observe records the duration and the failure of the operation started at
+// start, to be deferred with the address of the error returned.
+func observe(operation string, start time.Time, err *error) {
+	daoDurations.With(operation).ObserveSince(start)
+	if *err != nil {
+		daoErrors.With(operation).Inc()
+	}
+}
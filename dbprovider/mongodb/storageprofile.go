@@ -17,10 +17,11 @@ import (
 	"github.com/skyrings/skyring-common/tools/logger"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"time"
 )
 
 func (m MongoDb) StorageProfile(ctxt string, name string) (sProfile models.StorageProfile, e error) {
-
+	defer observe("StorageProfile", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_STORAGE_PROFILE)
 	defer m.Close(c)
 	err := c.Find(bson.M{"name": name}).One(&sProfile)
@@ -32,7 +33,7 @@ func (m MongoDb) StorageProfile(ctxt string, name string) (sProfile models.Stora
 }
 
 func (m MongoDb) StorageProfiles(ctxt string, filter interface{}, ops models.QueryOps) (sProfiles []models.StorageProfile, e error) {
-
+	defer observe("StorageProfiles", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_STORAGE_PROFILE)
 	defer m.Close(c)
 
@@ -45,7 +46,8 @@ func (m MongoDb) StorageProfiles(ctxt string, filter interface{}, ops models.Que
 
 }
 
-func (m MongoDb) SaveStorageProfile(ctxt string, s models.StorageProfile) error {
+func (m MongoDb) SaveStorageProfile(ctxt string, s models.StorageProfile) (e error) {
+	defer observe("SaveStorageProfile", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_STORAGE_PROFILE)
 	defer m.Close(c)
 
@@ -57,7 +59,8 @@ func (m MongoDb) SaveStorageProfile(ctxt string, s models.StorageProfile) error
 	return nil
 
 }
-func (m MongoDb) DeleteStorageProfile(ctxt string, name string) error {
+func (m MongoDb) DeleteStorageProfile(ctxt string, name string) (e error) {
+	defer observe("DeleteStorageProfile", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_STORAGE_PROFILE)
 	defer m.Close(c)
 
@@ -17,6 +17,7 @@ import (
 	"github.com/skyrings/skyring-common/tools/logger"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"time"
 )
 
 var (
@@ -26,6 +27,7 @@ var (
 // User returns the user with the given username. Error is set to
 // ErrMissingUser if user is not found.
 func (m MongoDb) User(username string) (user models.User, e error) {
+	defer observe("User", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_USER)
 	defer m.Close(c)
 	err := c.Find(bson.M{"username": username}).One(&user)
@@ -37,6 +39,7 @@ func (m MongoDb) User(username string) (user models.User, e error) {
 
 // Users returns a slice of all users.
 func (m MongoDb) Users(filter interface{}) (us []models.User, e error) {
+	defer observe("Users", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_USER)
 	defer m.Close(c)
 
@@ -49,7 +52,8 @@ func (m MongoDb) Users(filter interface{}) (us []models.User, e error) {
 }
 
 // SaveUser adds a new user, replacing if the same username is in use.
-func (m MongoDb) SaveUser(user models.User) error {
+func (m MongoDb) SaveUser(user models.User) (e error) {
+	defer observe("SaveUser", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_USER)
 	defer m.Close(c)
 
@@ -62,7 +66,8 @@ func (m MongoDb) SaveUser(user models.User) error {
 }
 
 // DeleteUser removes a user. ErrNotFound is returned if the user isn't found.
-func (m MongoDb) DeleteUser(username string) error {
+func (m MongoDb) DeleteUser(username string) (e error) {
+	defer observe("DeleteUser", time.Now(), &e)
 	c := m.Connect(models.COLL_NAME_USER)
 	defer m.Close(c)
 
@@ -17,9 +17,12 @@ import (
 	"fmt"
 	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/dbprovider"
 	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/summary"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"time"
 )
@@ -96,24 +99,56 @@ func ClearCorrespondingAlert(event models.AppEvent, ctxt string) (models.AlarmSt
 	if len(events) == 0 || events[0].Severity == models.ALARM_STATUS_CLEARED {
 		return models.ALARM_STATUS_INDETERMINATE, errors.New(fmt.Sprintf("Corresponding alert not available for event: %s",
 			event.EventId))
-	} else {
-		events[0].Acked = true
-		events[0].SystemAckedTime = time.Now()
-		events[0].AckedByEvent = event.EventId.String()
-		events[0].SystemAckComment = fmt.Sprintf("This event is dismissed automatically,"+
-			" as we have recieved a corresponding event: %s", event.EventId.String())
-
-		if err := coll.Update(bson.M{"eventid": events[0].EventId},
-			bson.M{"$set": events[0]}); err != nil {
-			logger.Get().Warning(fmt.Sprintf("%s-Error updating record in DB for event:"+
-				" %v. error: %v", ctxt, events[0].EventId.String(), err))
-			return models.ALARM_STATUS_INDETERMINATE, errors.New(fmt.Sprintf("%s-Error updating"+
-				" record in DB for event: %v. error: %v",
-				ctxt, events[0].EventId.String(), err))
-		}
+	}
+	return ackAlert(coll, events[0], event, ctxt)
+}
 
+// ClearAlert dismisses the alert, not yet acked, with the event id given, or
+// the latest alert corresponding to the event if the id is zero, and returns
+// its severity. Unlike ClearCorrespondingAlert it does not wait for the
+// alert to show up, the alerts raised by skyring being recorded before they
+// are cleared.
+func ClearAlert(alertId uuid.UUID, event models.AppEvent, ctxt string) (models.AlarmStatus, error) {
+	var events []models.AppEvent
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_APP_EVENTS)
+	query := bson.M{"eventid": alertId, "acked": false}
+	if alertId.IsZero() {
+		query = bson.M{
+			"name":      event.Name,
+			"entityid":  event.EntityId,
+			"acked":     false,
+			"clusterid": event.ClusterId}
 	}
-	return events[0].Severity, nil
+	if err := coll.Find(query).Sort("-timestamp").All(&events); err != nil {
+		logger.Get().Error("%s-Error getting record from DB: %v", ctxt, err)
+		return models.ALARM_STATUS_INDETERMINATE, err
+	}
+	if len(events) == 0 || events[0].Severity == models.ALARM_STATUS_CLEARED {
+		return models.ALARM_STATUS_INDETERMINATE, errors.New(fmt.Sprintf("Corresponding alert not available for event: %s",
+			event.EventId))
+	}
+	return ackAlert(coll, events[0], event, ctxt)
+}
+
+// ackAlert dismisses the alert as cleared by the event.
+func ackAlert(coll *mgo.Collection, alert models.AppEvent, event models.AppEvent, ctxt string) (models.AlarmStatus, error) {
+	alert.Acked = true
+	alert.SystemAckedTime = time.Now()
+	alert.AckedByEvent = event.EventId.String()
+	alert.SystemAckComment = fmt.Sprintf("This event is dismissed automatically,"+
+		" as we have recieved a corresponding event: %s", event.EventId.String())
+
+	if err := coll.Update(bson.M{"eventid": alert.EventId},
+		bson.M{"$set": alert}); err != nil {
+		logger.Get().Warning(fmt.Sprintf("%s-Error updating record in DB for event:"+
+			" %v. error: %v", ctxt, alert.EventId.String(), err))
+		return models.ALARM_STATUS_INDETERMINATE, errors.New(fmt.Sprintf("%s-Error updating"+
+			" record in DB for event: %v. error: %v",
+			ctxt, alert.EventId.String(), err))
+	}
+	return alert.Severity, nil
 }
 
 func getAlarmCountAndStatus(eventSeverity models.AlarmStatus,
@@ -161,6 +196,7 @@ func UpdateNodeAlarmCount(event models.AppEvent, clearedSeverity models.AlarmSta
 		logger.Get().Error("%s-Error getting record from DB: %v", ctxt, err)
 		return err
 	}
+	before := node
 	node.AlmStatus, node.AlmWarnCount, node.AlmCritCount = getAlarmCountAndStatus(event.Severity,
 		clearedSeverity,
 		node.AlmCritCount,
@@ -171,6 +207,7 @@ func UpdateNodeAlarmCount(event models.AppEvent, clearedSeverity models.AlarmSta
 		logger.Get().Error("%s-Error Updating the Alarm state/count: %v", ctxt, err)
 		return err
 	}
+	summary.Publish(summary.NodeDelta(&before, &node))
 	return nil
 }
 
@@ -185,6 +222,7 @@ func UpdateClusterAlarmCount(event models.AppEvent, clearedSeverity models.Alarm
 		return err
 	}
 
+	before := cluster
 	cluster.AlmStatus, cluster.AlmWarnCount, cluster.AlmCritCount = getAlarmCountAndStatus(event.Severity,
 		clearedSeverity,
 		cluster.AlmCritCount,
@@ -195,6 +233,7 @@ func UpdateClusterAlarmCount(event models.AppEvent, clearedSeverity models.Alarm
 		logger.Get().Error("%s-Error Updating the Alarm state/count: %v", ctxt, err)
 		return err
 	}
+	summary.Publish(summary.ClusterDelta(&before, &cluster))
 	return nil
 }
 
@@ -210,6 +249,7 @@ func UpdateSluAlarmCount(event models.AppEvent, clearedSeverity models.AlarmStat
 		return err
 	}
 
+	before := slu
 	slu.AlmStatus, slu.AlmWarnCount, slu.AlmCritCount = getAlarmCountAndStatus(event.Severity,
 		clearedSeverity,
 		slu.AlmCritCount,
@@ -220,6 +260,7 @@ func UpdateSluAlarmCount(event models.AppEvent, clearedSeverity models.AlarmStat
 		logger.Get().Error("%s-Error Updating the Alarm state/count: %v", ctxt, err)
 		return err
 	}
+	summary.Publish(summary.SluDelta(&before, &slu))
 	return nil
 }
 
@@ -235,6 +276,7 @@ func UpdateStorageAlarmCount(event models.AppEvent, clearedSeverity models.Alarm
 		return err
 	}
 
+	before := storage.AlmCritCount
 	storage.AlmStatus, storage.AlmWarnCount, storage.AlmCritCount = getAlarmCountAndStatus(event.Severity,
 		clearedSeverity,
 		storage.AlmCritCount,
@@ -245,6 +287,7 @@ func UpdateStorageAlarmCount(event models.AppEvent, clearedSeverity models.Alarm
 		logger.Get().Error("%s-Error Updating the Alarm state/count: %v", ctxt, err)
 		return err
 	}
+	summary.Publish(summary.AlarmDelta(summary.STORAGE_COUNT, event.ClusterId, before, storage.AlmCritCount))
 	return nil
 }
 
@@ -339,3 +382,43 @@ func DismissAllEventsForEntity(uuid uuid.UUID, event models.AppEvent, ctxt strin
 	}
 	return nil
 }
+
+// RaiseAppEvent records the app event, clearing the corresponding alert
+// first if the event clears it, and updates the alarm counts of the
+// affected entities of the cluster. A clearing event waits for its alert
+// to show up, see ClearCorrespondingAlert.
+func RaiseAppEvent(event models.AppEvent, dbprovider dbprovider.DbInterface, ctxt string) error {
+	clearedSeverity := models.AlarmStatus(models.ALARM_STATUS_INDETERMINATE)
+	if event.Severity == models.ALARM_STATUS_CLEARED {
+		severity, err := ClearCorrespondingAlert(event, ctxt)
+		if err != nil {
+			logger.Get().Warning("%s-%v", ctxt, err)
+		} else {
+			clearedSeverity = severity
+		}
+	}
+	return recordAppEvent(event, clearedSeverity, dbprovider, ctxt)
+}
+
+// RaiseClearingAppEvent records the app event clearing the alert with the
+// event id given, zero if not known, without waiting for the alert to show
+// up, and updates the alarm counts of the affected entities of the cluster.
+func RaiseClearingAppEvent(event models.AppEvent, alertId uuid.UUID, dbprovider dbprovider.DbInterface, ctxt string) error {
+	clearedSeverity := models.AlarmStatus(models.ALARM_STATUS_INDETERMINATE)
+	severity, err := ClearAlert(alertId, event, ctxt)
+	if err != nil {
+		logger.Get().Warning("%s-%v", ctxt, err)
+	} else {
+		clearedSeverity = severity
+	}
+	return recordAppEvent(event, clearedSeverity, dbprovider, ctxt)
+}
+
+func recordAppEvent(event models.AppEvent, clearedSeverity models.AlarmStatus, dbprovider dbprovider.DbInterface, ctxt string) error {
+	if !event.ClusterId.IsZero() {
+		if err := UpdateAlarmCount(event, clearedSeverity, ctxt); err != nil {
+			return err
+		}
+	}
+	return AuditLog(ctxt, event, dbprovider)
+}
@@ -19,30 +19,31 @@ import (
 )
 
 type Node struct {
-	NodeId            uuid.UUID              `json:"nodeid"`
-	Roles             []string               `json:"roles"`
-	Hostname          string                 `json:"hostname"`
-	Tags              []string               `json:"tags"`
-	ManagementIP4     string                 `json:"management_ip4"`
-	ClusterIP4        string                 `json:"cluster_ip4"`
-	PublicIP4         string                 `json:"public_ip4"`
-	ClusterId         uuid.UUID              `json:"clusterid"`
-	Location          string                 `json:"location"`
-	Status            NodeStatus             `json:"status"`
-	State             NodeState              `json:"state"`
-	AlmStatus         AlarmStatus            `json:"almstatus"`
-	AlmWarnCount      int                    `json:"almwarncount"`
-	AlmCritCount      int                    `json:"almcritcount"`
-	Options           map[string]string      `json:"options"`
-	CPUs              []Cpu                  `json:"cpus"`
-	NetworkInfo       Network                `json:"network_info"`
-	StorageDisks      []Disk                 `json:"storage_disks"`
-	Memory            Memory                 `json:"memory"`
-	OS                OperatingSystem        `json:"os"`
-	Enabled           bool                   `json:"enabled"`
-	Fingerprint       string                 `json:"saltfingerprint"`
-	Utilizations      map[string]Utilization `json:"utilizations"`
-	ServiceStatusList map[string][]string    `json:"servicestatuslist"`
+	NodeId            uuid.UUID                   `json:"nodeid"`
+	Roles             []string                    `json:"roles"`
+	Hostname          string                      `json:"hostname"`
+	Tags              []string                    `json:"tags"`
+	ManagementIP4     string                      `json:"management_ip4"`
+	ClusterIP4        string                      `json:"cluster_ip4"`
+	PublicIP4         string                      `json:"public_ip4"`
+	ClusterId         uuid.UUID                   `json:"clusterid"`
+	Location          string                      `json:"location"`
+	Status            NodeStatus                  `json:"status"`
+	State             NodeState                   `json:"state"`
+	AlmStatus         AlarmStatus                 `json:"almstatus"`
+	AlmWarnCount      int                         `json:"almwarncount"`
+	AlmCritCount      int                         `json:"almcritcount"`
+	Options           map[string]string           `json:"options"`
+	CPUs              []Cpu                       `json:"cpus"`
+	NetworkInfo       Network                     `json:"network_info"`
+	StorageDisks      []Disk                      `json:"storage_disks"`
+	Memory            Memory                      `json:"memory"`
+	OS                OperatingSystem             `json:"os"`
+	Enabled           bool                        `json:"enabled"`
+	Fingerprint       string                      `json:"saltfingerprint"`
+	Utilizations      map[string]Utilization      `json:"utilizations"`
+	ServiceStatusList map[string][]string         `json:"servicestatuslist"`
+	Thresholds        []monitoring.PluginOverride `json:"thresholds"`
 }
 
 type Network struct {
@@ -144,6 +145,8 @@ type System struct {
 	ProviderMonitoringDetails map[string]map[string]interface{} `json:"providermonitoringdetails"`
 	MostUsedStorages          []StorageUsage                    `json:"storageusage"`
 	Utilizations              map[string]interface{}            `json:"utilizations"`
+	UsageForecast             monitoring.Forecast               `json:"usageforecast"`
+	StorageProfileForecasts   map[string]monitoring.Forecast    `json:"storageprofileforecasts"`
 	UpdatedAt                 string                            `json:"updatedat"`
 }
 
@@ -165,45 +168,47 @@ type ClusterNetworks struct {
 }
 
 type StorageLogicalUnit struct {
-	SluId             uuid.UUID              `json:"sluid"`
-	Name              string                 `json:"name"`
-	Type              int                    `json:"type"`
-	ClusterId         uuid.UUID              `json:"clusterid"`
-	NodeId            uuid.UUID              `json:"nodeid"`
-	StorageIds        []uuid.UUID            `json:"storageid"`
-	StorageDeviceId   uuid.UUID              `json:"storagedeviceid"`
-	StorageDeviceSize float64                `json:"storagedevicesize"`
-	Status            SluStatus              `json:"status"`
-	Options           map[string]interface{} `json:"options"`
-	StorageProfile    string                 `json:"storageprofile"`
-	State             string                 `json:"state"`
-	AlmStatus         AlarmStatus            `json:"almstatus"`
-	AlmWarnCount      int                    `json:"almwarncount"`
-	AlmCritCount      int                    `json:"almcritcount"`
-	Usage             Utilization            `json:"usage"`
+	SluId             uuid.UUID                   `json:"sluid"`
+	Name              string                      `json:"name"`
+	Type              int                         `json:"type"`
+	ClusterId         uuid.UUID                   `json:"clusterid"`
+	NodeId            uuid.UUID                   `json:"nodeid"`
+	StorageIds        []uuid.UUID                 `json:"storageid"`
+	StorageDeviceId   uuid.UUID                   `json:"storagedeviceid"`
+	StorageDeviceSize float64                     `json:"storagedevicesize"`
+	Status            SluStatus                   `json:"status"`
+	Options           map[string]interface{}      `json:"options"`
+	StorageProfile    string                      `json:"storageprofile"`
+	State             string                      `json:"state"`
+	AlmStatus         AlarmStatus                 `json:"almstatus"`
+	AlmWarnCount      int                         `json:"almwarncount"`
+	AlmCritCount      int                         `json:"almcritcount"`
+	Usage             Utilization                 `json:"usage"`
+	Thresholds        []monitoring.PluginOverride `json:"thresholds"`
 }
 
 type Storage struct {
-	StorageId           uuid.UUID         `json:"storageid"`
-	Name                string            `json:"name"`
-	Type                string            `json:"type"`
-	Tags                []string          `json:"tags"`
-	ClusterId           uuid.UUID         `json:"clusterid"`
-	Size                string            `json:"size"`
-	Status              StorageStatus     `json:"status"`
-	Replicas            int               `json:"replicas"`
-	Profile             string            `json:"profile"`
-	SnapshotsEnabled    bool              `json:"snapshots_enabled"`
-	SnapshotScheduleIds []uuid.UUID       `json:"snapshot_schedule_ids"`
-	QuotaEnabled        bool              `json:"quota_enabled"`
-	QuotaParams         map[string]string `json:"quota_params"`
-	Options             map[string]string `json:"options"`
-	Usage               Utilization       `json:"usage"`
-	State               string            `json:"state"`
-	AlmStatus           AlarmStatus       `json:"almstatus"`
-	AlmWarnCount        int               `json:"almwarncount"`
-	AlmCritCount        int               `json:"almcritcount"`
-	SluIds              []uuid.UUID       `json:"slus"`
+	StorageId           uuid.UUID                   `json:"storageid"`
+	Name                string                      `json:"name"`
+	Type                string                      `json:"type"`
+	Tags                []string                    `json:"tags"`
+	ClusterId           uuid.UUID                   `json:"clusterid"`
+	Size                string                      `json:"size"`
+	Status              StorageStatus               `json:"status"`
+	Replicas            int                         `json:"replicas"`
+	Profile             string                      `json:"profile"`
+	SnapshotsEnabled    bool                        `json:"snapshots_enabled"`
+	SnapshotScheduleIds []uuid.UUID                 `json:"snapshot_schedule_ids"`
+	QuotaEnabled        bool                        `json:"quota_enabled"`
+	QuotaParams         map[string]string           `json:"quota_params"`
+	Options             map[string]string           `json:"options"`
+	Usage               Utilization                 `json:"usage"`
+	State               string                      `json:"state"`
+	AlmStatus           AlarmStatus                 `json:"almstatus"`
+	AlmWarnCount        int                         `json:"almwarncount"`
+	AlmCritCount        int                         `json:"almcritcount"`
+	SluIds              []uuid.UUID                 `json:"slus"`
+	Thresholds          []monitoring.PluginOverride `json:"thresholds"`
 }
 
 type BlockDevice struct {
@@ -236,6 +241,74 @@ type SnapshotSchedule struct {
 	EndBy         string    `json:"endby"`
 }
 
+type Schedule struct {
+	Id              uuid.UUID              `json:"id"`
+	Owner           string                 `json:"owner"`
+	CallbackName    string                 `json:"callbackname"`
+	Parameters      map[string]interface{} `json:"parameters"`
+	Interval        int                    `json:"interval"`
+	CronExpr        string                 `json:"cronexpr"`
+	Location        string                 `json:"location"`
+	StartFrom       time.Time              `json:"startfrom"`
+	EndBy           time.Time              `json:"endby"`
+	MissedRunPolicy string                 `json:"missedrunpolicy"`
+	OverlapPolicy   string                 `json:"overlappolicy"`
+	Jitter          int                    `json:"jitter"`
+	NextRun         time.Time              `json:"nextrun"`
+	LastRun         time.Time              `json:"lastrun"`
+	LastSuccess     time.Time              `json:"lastsuccess"`
+	LastFailure     time.Time              `json:"lastfailure"`
+	LastError       string                 `json:"lasterror"`
+}
+
+type ScheduleRun struct {
+	ScheduleId  uuid.UUID  `json:"scheduleid"`
+	TaskId      uuid.UUID  `json:"taskid"`
+	Name        string     `json:"name"`
+	StartedAt   time.Time  `json:"startedat"`
+	CompletedAt time.Time  `json:"completedat"`
+	Duration    float64    `json:"duration"`
+	Status      TaskStatus `json:"status"`
+	Error       string     `json:"error"`
+}
+
+type AlertRule struct {
+	RuleId             uuid.UUID              `json:"ruleid"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description"`
+	Query              monitoring.MetricQuery `json:"query"`
+	Function           string                 `json:"function"`
+	Comparator         string                 `json:"comparator"`
+	Threshold          float64                `json:"threshold"`
+	ClearThreshold     *float64               `json:"clearthreshold,omitempty"`
+	For                string                 `json:"for"`
+	Severity           AlarmStatus            `json:"severity"`
+	Labels             map[string]string      `json:"labels"`
+	ClusterId          uuid.UUID              `json:"clusterid"`
+	NotificationEntity NotificationEntity     `json:"notificationentity"`
+	EntityId           uuid.UUID              `json:"entityid"`
+	Notify             bool                   `json:"notify"`
+	Enabled            bool                   `json:"enabled"`
+}
+
+type AlertState struct {
+	RuleId     uuid.UUID `json:"ruleid"`
+	SeriesName string    `json:"seriesname"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Value      float64   `json:"value"`
+	EventId    uuid.UUID `json:"eventid"`
+}
+
+type AnomalyState struct {
+	NodeId     uuid.UUID          `json:"nodeid"`
+	SeriesName string             `json:"seriesname"`
+	Active     bool               `json:"active"`
+	Since      time.Time          `json:"since"`
+	Anomaly    monitoring.Anomaly `json:"anomaly"`
+	EventId    uuid.UUID          `json:"eventid"`
+}
+
 type Status struct {
 	Timestamp time.Time
 	Message   string
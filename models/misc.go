@@ -63,9 +63,43 @@ type ClusterSummary struct {
 	NodesCount                map[string]int                    `json:"nodescount"`
 	ProviderMonitoringDetails map[string]map[string]interface{} `json:"providermonitoringdetails"`
 	Utilizations              map[string]interface{}            `json:"utilizations"`
+	UsageForecast             monitoring.Forecast               `json:"usageforecast"`
+	StorageForecasts          map[string]monitoring.Forecast    `json:"storageforecasts"`
+	StorageProfileForecasts   map[string]monitoring.Forecast    `json:"storageprofileforecasts"`
 	UpdatedAt                 string                            `json:"updatedat"`
 }
 
+// Periods of the utilization roll-ups
+const (
+	ROLLUP_PERIOD_HOURLY = "hourly"
+	ROLLUP_PERIOD_DAILY  = "daily"
+)
+
+// RollupStats summarizes the samples of a value over a roll-up period. The
+// average is Sum over Count.
+type RollupStats struct {
+	Min   float64 `json:"min"`
+	Avg   float64 `json:"avg"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int     `json:"count"`
+}
+
+// UtilizationRollup summarizes the utilization of a cluster, or of the
+// system if ClusterId is zero, over the hour or the day from Start.
+type UtilizationRollup struct {
+	ClusterId   uuid.UUID              `json:"clusterid"`
+	Name        string                 `json:"name"`
+	Period      string                 `json:"period"`
+	Start       time.Time              `json:"start"`
+	Usage       RollupStats            `json:"usage"`
+	Used        RollupStats            `json:"used"`
+	Total       RollupStats            `json:"total"`
+	ObjectCount map[string]RollupStats `json:"objectcount"`
+	SLUCount    map[string]RollupStats `json:"slucount"`
+	UpdatedAt   time.Time              `json:"updatedat"`
+}
+
 type StorageUsage struct {
 	Name  string      `json:"name"`
 	Usage Utilization `json:"usage"`
@@ -261,6 +295,12 @@ const (
 	COLL_NAME_CLUSTER_NOTIFICATION_SUBSCRIPTIONS = "cluster_notification_subscriptions"
 	COLL_NAME_ARCHIVE_TASKS                      = "archive_tasks"
 	COLL_NAME_ARCHIVE_EVENTS                     = "archive_events"
+	COLL_NAME_SCHEDULES                          = "schedules"
+	COLL_NAME_SCHEDULE_RUNS                      = "schedule_runs"
+	COLL_NAME_ALERT_RULES                        = "alert_rules"
+	COLL_NAME_ALERT_STATES                       = "alert_states"
+	COLL_NAME_ANOMALIES                          = "anomalies"
+	COLL_NAME_UTILIZATION_ROLLUPS                = "utilization_rollups"
 
 	TASKS_PER_PAGE      = 100
 	LDAP_USERS_PER_PAGE = 100
@@ -0,0 +1,237 @@
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Aggregation functions
+const (
+	AGGREGATE_AVG        = "avg"
+	AGGREGATE_MIN        = "min"
+	AGGREGATE_MAX        = "max"
+	AGGREGATE_SUM        = "sum"
+	AGGREGATE_PERCENTILE = "percentile"
+)
+
+// Groupings of the series
+const (
+	// A series per node combining the series of the node
+	GROUP_BY_NODE = NODE
+	// A single series combining the series of all the nodes
+	GROUP_BY_CLUSTER = "cluster"
+)
+
+// Aggregation describes how the series selected by a query are reduced.
+//
+// If Step is set, every series is downsampled to buckets of Step using
+// Function. If GroupBy is set, the series are then combined per group using
+// Function, point by point. Percentile, from 0 to 100, is used by the
+// percentile function.
+type Aggregation struct {
+	Function   string  `json:"function"`
+	Percentile float64 `json:"percentile,omitempty"`
+	GroupBy    string  `json:"groupby,omitempty"`
+	Step       string  `json:"step,omitempty"`
+}
+
+// Valid checks the function, the grouping and the step of the aggregation.
+func (a Aggregation) Valid() error {
+	switch a.Function {
+	case AGGREGATE_AVG, AGGREGATE_MIN, AGGREGATE_MAX, AGGREGATE_SUM:
+	case AGGREGATE_PERCENTILE:
+		if a.Percentile < 0 || a.Percentile > 100 {
+			return fmt.Errorf("Percentile %v is not within 0 and 100", a.Percentile)
+		}
+	default:
+		return fmt.Errorf("Unsupported aggregation function %s", a.Function)
+	}
+	switch a.GroupBy {
+	case "", GROUP_BY_NODE, GROUP_BY_CLUSTER:
+	default:
+		return fmt.Errorf("Unsupported grouping %s", a.GroupBy)
+	}
+	if a.Step != "" {
+		if _, err := a.StepDuration(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepDuration returns the bucket size of the downsampling, 0 if the series
+// are not downsampled.
+func (a Aggregation) StepDuration() (time.Duration, error) {
+	if a.Step == "" {
+		return 0, nil
+	}
+	step, err := ParseDuration(a.Step)
+	if err != nil {
+		return 0, err
+	}
+	if step < time.Second {
+		return 0, fmt.Errorf("Step %s is shorter than a second", a.Step)
+	}
+	return step, nil
+}
+
+var durationUnits = map[string]time.Duration{
+	"s":   time.Second,
+	"m":   time.Minute,
+	"min": time.Minute,
+	"h":   time.Hour,
+	"d":   24 * time.Hour,
+	"w":   7 * 24 * time.Hour,
+	"mon": 30 * 24 * time.Hour,
+	"y":   365 * 24 * time.Hour,
+}
+
+var durationRegex = regexp.MustCompile("^-?([0-9]+)(s|m|min|h|d|w|mon|y)$")
+
+// SupportedInputTimeFormats are the formats of the absolute times of the
+// queries.
+var SupportedInputTimeFormats = []string{
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02",
+	"20060102",
+}
+
+// ParseTime parses an absolute time or a time relative to now like -10min.
+func ParseTime(timeString string, now time.Time) (time.Time, error) {
+	for _, currentFormat := range SupportedInputTimeFormats {
+		if parsedTime, err := time.Parse(currentFormat, timeString); err == nil {
+			return parsedTime, nil
+		}
+	}
+	if duration, err := ParseDuration(timeString); err == nil {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("The time %v is of unsupported type", timeString)
+}
+
+// ParseDuration accepts both the graphite style relative times like -10min
+// and durations like 10m.
+func ParseDuration(interval string) (time.Duration, error) {
+	parts := durationRegex.FindStringSubmatch(interval)
+	if parts == nil {
+		return 0, fmt.Errorf("Invalid duration passed: %s", interval)
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration passed: %s", interval)
+	}
+	return time.Duration(count) * durationUnits[parts[2]], nil
+}
+
+// Reduce applies the aggregation function to the values. NaN values are
+// ignored and NaN is returned if no value is left.
+func (a Aggregation) Reduce(values []float64) float64 {
+	var numbers []float64
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			numbers = append(numbers, value)
+		}
+	}
+	if len(numbers) == 0 {
+		return math.NaN()
+	}
+	switch a.Function {
+	case AGGREGATE_MIN, AGGREGATE_MAX, AGGREGATE_PERCENTILE:
+		sort.Float64s(numbers)
+		switch a.Function {
+		case AGGREGATE_MIN:
+			return numbers[0]
+		case AGGREGATE_MAX:
+			return numbers[len(numbers)-1]
+		}
+		// Nearest rank percentile
+		rank := int(math.Ceil(a.Percentile / 100 * float64(len(numbers))))
+		if rank < 1 {
+			rank = 1
+		}
+		return numbers[rank-1]
+	}
+	var sum float64
+	for _, number := range numbers {
+		sum = sum + number
+	}
+	if a.Function == AGGREGATE_AVG {
+		return sum / float64(len(numbers))
+	}
+	return sum
+}
+
+// Downsample reduces the points of every series to a point per bucket of
+// step, timestamped with the start of the bucket.
+func (a Aggregation) Downsample(series []Series, step time.Duration) []Series {
+	stepSeconds := int64(step / time.Second)
+	if stepSeconds <= 0 {
+		return series
+	}
+	result := make([]Series, len(series))
+	for index, currentSeries := range series {
+		buckets := make(map[int64][]float64)
+		var timestamps []int64
+		for _, point := range currentSeries.DataPoints {
+			bucket := point.Timestamp - point.Timestamp%stepSeconds
+			if _, ok := buckets[bucket]; !ok {
+				timestamps = append(timestamps, bucket)
+			}
+			buckets[bucket] = append(buckets[bucket], point.Value)
+		}
+		sort.Sort(int64s(timestamps))
+		result[index] = Series{Name: currentSeries.Name, DataPoints: make([]DataPoint, 0, len(timestamps))}
+		for _, timestamp := range timestamps {
+			result[index].DataPoints = append(result[index].DataPoints, DataPoint{Timestamp: timestamp, Value: a.Reduce(buckets[timestamp])})
+		}
+	}
+	return result
+}
+
+// Group combines the series having the same group, as returned by groupOf,
+// point by point. The combined series are named after their groups.
+func (a Aggregation) Group(series []Series, groupOf func(name string) string) []Series {
+	var groups []string
+	values := make(map[string]map[int64][]float64)
+	for _, currentSeries := range series {
+		group := groupOf(currentSeries.Name)
+		if _, ok := values[group]; !ok {
+			groups = append(groups, group)
+			values[group] = make(map[int64][]float64)
+		}
+		for _, point := range currentSeries.DataPoints {
+			values[group][point.Timestamp] = append(values[group][point.Timestamp], point.Value)
+		}
+	}
+	result := make([]Series, len(groups))
+	for index, group := range groups {
+		timestamps := make([]int64, 0, len(values[group]))
+		for timestamp := range values[group] {
+			timestamps = append(timestamps, timestamp)
+		}
+		sort.Sort(int64s(timestamps))
+		result[index] = Series{Name: group, DataPoints: make([]DataPoint, 0, len(timestamps))}
+		for _, timestamp := range timestamps {
+			result[index].DataPoints = append(result[index].DataPoints, DataPoint{Timestamp: timestamp, Value: a.Reduce(values[group][timestamp])})
+		}
+	}
+	return result
+}
+
+type int64s []int64
+
+func (slice int64s) Len() int {
+	return len(slice)
+}
+
+func (slice int64s) Less(i, j int) bool {
+	return slice[i] < slice[j]
+}
+
+func (slice int64s) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
@@ -0,0 +1,119 @@
+package monitoring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Directions of the deviation of an anomaly from the baseline
+const (
+	ANOMALY_HIGH = "high"
+	ANOMALY_LOW  = "low"
+)
+
+// AnomalyConfig tunes the anomaly detection.
+//
+// The baseline of a series is its exponentially weighted moving average and
+// standard deviation, with Alpha the weight of every new point. The latest
+// Consecutive points are anomalous if they all deviate from the baseline of
+// the points before them by at least Threshold standard deviations in the
+// same direction. The standard deviation is floored at MinStdDev and at
+// MinRelativeStdDev of the baseline, so that the series flat so far do not
+// flag every small change. At least MinPoints points make a baseline.
+type AnomalyConfig struct {
+	Alpha             float64 `json:"alpha"`
+	Threshold         float64 `json:"threshold"`
+	Consecutive       int     `json:"consecutive"`
+	MinPoints         int     `json:"minpoints"`
+	MinStdDev         float64 `json:"minstddev"`
+	MinRelativeStdDev float64 `json:"minrelativestddev"`
+}
+
+var DefaultAnomalyConfig = AnomalyConfig{
+	Alpha:             0.1,
+	Threshold:         4,
+	Consecutive:       3,
+	MinPoints:         20,
+	MinStdDev:         0.01,
+	MinRelativeStdDev: 0.05,
+}
+
+// Anomaly is a deviation of the latest value of a series from its baseline.
+type Anomaly struct {
+	SeriesName string  `json:"seriesname"`
+	Timestamp  int64   `json:"timestamp"`
+	Value      float64 `json:"value"`
+	Baseline   float64 `json:"baseline"`
+	StdDev     float64 `json:"stddev"`
+	ZScore     float64 `json:"zscore"`
+	Direction  string  `json:"direction"`
+}
+
+// Baseline returns the exponentially weighted moving average and standard
+// deviation of the values.
+func (c AnomalyConfig) Baseline(values []float64) (float64, float64) {
+	mean := values[0]
+	var variance float64
+	for _, value := range values[1:] {
+		diff := value - mean
+		increment := c.Alpha * diff
+		mean = mean + increment
+		variance = (1 - c.Alpha) * (variance + diff*increment)
+	}
+	return mean, math.Sqrt(variance)
+}
+
+// DetectAnomaly checks the latest points of the series against the baseline
+// of the points before them. It returns false if the series is not
+// anomalous, and an error if it does not have enough points to tell.
+func DetectAnomaly(series Series, config AnomalyConfig) (Anomaly, bool, error) {
+	var points []DataPoint
+	for _, point := range series.DataPoints {
+		if !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0) {
+			points = append(points, point)
+		}
+	}
+	if config.Consecutive < 1 {
+		config.Consecutive = 1
+	}
+	if len(points) < config.MinPoints+config.Consecutive {
+		return Anomaly{}, false, fmt.Errorf("Only %d of the %d points needed in %s", len(points), config.MinPoints+config.Consecutive, series.Name)
+	}
+	sort.Sort(dataPoints(points))
+
+	history := points[:len(points)-config.Consecutive]
+	values := make([]float64, len(history))
+	for index, point := range history {
+		values[index] = point.Value
+	}
+	mean, stdDev := config.Baseline(values)
+	stdDev = math.Max(stdDev, math.Max(config.MinStdDev, config.MinRelativeStdDev*math.Abs(mean)))
+
+	var direction string
+	var zScore float64
+	for _, point := range points[len(points)-config.Consecutive:] {
+		zScore = (point.Value - mean) / stdDev
+		if math.Abs(zScore) < config.Threshold {
+			return Anomaly{}, false, nil
+		}
+		pointDirection := ANOMALY_HIGH
+		if zScore < 0 {
+			pointDirection = ANOMALY_LOW
+		}
+		if direction != "" && direction != pointDirection {
+			return Anomaly{}, false, nil
+		}
+		direction = pointDirection
+	}
+	latest := points[len(points)-1]
+	return Anomaly{
+		SeriesName: series.Name,
+		Timestamp:  latest.Timestamp,
+		Value:      latest.Value,
+		Baseline:   mean,
+		StdDev:     stdDev,
+		ZScore:     zScore,
+		Direction:  direction,
+	}, true, nil
+}
@@ -0,0 +1,268 @@
+package monitoring
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheConfig bounds the results cached by the CachingManager. The latest
+// values are cached for LatestTTL. The other results are cached for the
+// IntervalRatio part of the time they span, bounded by MinTTL and MaxTTL.
+// The TTLs are in seconds.
+type CacheConfig struct {
+	LatestTTL     int     `json:"latestttl"`
+	MinTTL        int     `json:"minttl"`
+	MaxTTL        int     `json:"maxttl"`
+	IntervalRatio float64 `json:"intervalratio"`
+	MaxEntries    int     `json:"maxentries"`
+}
+
+var DefaultCacheConfig = CacheConfig{
+	LatestTTL:     15,
+	MinTTL:        15,
+	MaxTTL:        600,
+	IntervalRatio: 0.01,
+	MaxEntries:    1000,
+}
+
+// CacheStats counts the lookups of the cache. Shared counts the lookups
+// which waited for the identical query in flight instead of querying.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Shared    uint64 `json:"shared"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// cacheCall is a query in flight, waited for by the identical queries.
+type cacheCall struct {
+	done  sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// CachingManager caches the results of QueryDB, GetInstantValue and
+// GetInstantValuesAggregation of the manager it wraps. The identical
+// queries made while one is in flight wait for its result. The errors are
+// not cached and the other calls go to the manager as they are.
+type CachingManager struct {
+	MonitoringManagerInterface
+	config CacheConfig
+
+	mutex    sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inFlight map[string]*cacheCall
+
+	hits      uint64
+	misses    uint64
+	shared    uint64
+	evictions uint64
+}
+
+func NewCachingManager(manager MonitoringManagerInterface, config CacheConfig) *CachingManager {
+	return &CachingManager{
+		MonitoringManagerInterface: manager,
+		config:                     config,
+		entries:                    make(map[string]*list.Element),
+		lru:                        list.New(),
+		inFlight:                   make(map[string]*cacheCall),
+	}
+}
+
+// ttl returns how long the results of the query are cached.
+func (c *CachingManager) ttl(query MetricQuery) time.Duration {
+	if query.IsLatest() {
+		return time.Duration(c.config.LatestTTL) * time.Second
+	}
+	minTTL := time.Duration(c.config.MinTTL) * time.Second
+	maxTTL := time.Duration(c.config.MaxTTL) * time.Second
+	if query.EndTime != "" {
+		// A window closed in the past does not change, unless its end is
+		// relative to now and moves along
+		if _, err := ParseDuration(query.EndTime); err != nil {
+			now := time.Now()
+			if end, err := ParseTime(query.EndTime, now); err == nil && end.Before(now) {
+				return maxTTL
+			}
+		}
+	}
+	var span time.Duration
+	if interval, err := ParseDuration(query.Interval); err == nil {
+		span = interval
+	}
+	ttl := time.Duration(float64(span) * c.config.IntervalRatio)
+	if query.Aggregation != nil {
+		// No new value is there before the next step
+		if step, err := query.Aggregation.StepDuration(); err == nil && step > ttl {
+			ttl = step
+		}
+	}
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+func (c *CachingManager) lookup(key string) (interface{}, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *CachingManager) store(key string, value interface{}, ttl time.Duration) {
+	if c.config.MaxEntries <= 0 || ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+	for c.lru.Len() > c.config.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions++
+	}
+}
+
+// get returns the cached value of the key, or the value of fetch which is
+// called once for all the identical lookups made while it runs. A panic of
+// fetch fails the lookups.
+func (c *CachingManager) get(key string, ttl time.Duration, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mutex.Lock()
+	if value, ok := c.lookup(key); ok {
+		c.hits++
+		c.mutex.Unlock()
+		return value, nil
+	}
+	if call, ok := c.inFlight[key]; ok {
+		c.shared++
+		c.mutex.Unlock()
+		call.done.Wait()
+		return call.value, call.err
+	}
+	c.misses++
+	call := &cacheCall{}
+	call.done.Add(1)
+	c.inFlight[key] = call
+	c.mutex.Unlock()
+
+	func() {
+		// The lookups waiting are released whatever fetch does
+		defer func() {
+			if r := recover(); r != nil {
+				call.value, call.err = nil, fmt.Errorf("Failed to fetch %q. Error: %v", key, r)
+			}
+			c.mutex.Lock()
+			delete(c.inFlight, key)
+			if call.err == nil {
+				c.store(key, call.value, ttl)
+			}
+			c.mutex.Unlock()
+			call.done.Done()
+		}()
+		call.value, call.err = fetch()
+	}()
+	return call.value, call.err
+}
+
+// Stats returns the counts of the lookups since the manager was created.
+func (c *CachingManager) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Shared:    c.shared,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+	}
+}
+
+// Purge drops all the cached results.
+func (c *CachingManager) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// copySeries copies the series so that the callers do not share the cached
+// data points.
+func copySeries(series []Series) []Series {
+	copied := make([]Series, len(series))
+	for index, current := range series {
+		copied[index] = Series{Name: current.Name, DataPoints: make([]DataPoint, len(current.DataPoints))}
+		copy(copied[index].DataPoints, current.DataPoints)
+	}
+	return copied
+}
+
+func (c *CachingManager) QueryDB(query MetricQuery) ([]Series, error) {
+	key, err := json.Marshal(query)
+	if err != nil {
+		return c.MonitoringManagerInterface.QueryDB(query)
+	}
+	value, err := c.get("query\x00"+string(key), c.ttl(query), func() (interface{}, error) {
+		return c.MonitoringManagerInterface.QueryDB(query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copySeries(value.([]Series)), nil
+}
+
+func (c *CachingManager) GetInstantValue(node string, resource_name string) (float64, error) {
+	value, err := c.get("instant\x00"+node+"\x00"+resource_name, time.Duration(c.config.LatestTTL)*time.Second, func() (interface{}, error) {
+		return c.MonitoringManagerInterface.GetInstantValue(node, resource_name)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(float64), nil
+}
+
+type instantAggregation struct {
+	value             float64
+	err               error
+	isCompleteFailure bool
+}
+
+func (c *CachingManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
+	key := "aggregation\x00" + node + "\x00" + resource_name + "\x00" + strings.Join(exceptionResources, "\x00")
+	value, _ := c.get(key, time.Duration(c.config.LatestTTL)*time.Second, func() (interface{}, error) {
+		var result instantAggregation
+		result.value, result.err, result.isCompleteFailure = c.MonitoringManagerInterface.GetInstantValuesAggregation(node, resource_name, exceptionResources)
+		// The partial failures are not cached
+		return result, result.err
+	})
+	result := value.(instantAggregation)
+	return result.value, result.err, result.isCompleteFailure
+}
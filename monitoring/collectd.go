@@ -0,0 +1,323 @@
+package monitoring
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config categories besides THRESHOLD
+const (
+	INTERVAL      = "interval"
+	MISCELLANEOUS = "miscellaneous"
+)
+
+// Outputs of collectd
+const (
+	COLLECTD_OUTPUT_GRAPHITE = "write_graphite"
+	COLLECTD_OUTPUT_NETWORK  = "network"
+)
+
+// CollectdOutput is where collectd sends the values it reads.
+type CollectdOutput struct {
+	Type     string `json:"type"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Prefix   string `json:"prefix"`
+}
+
+// CollectdConfig holds the settings of the rendered collectd configuration
+// which do not come from the plugins.
+type CollectdConfig struct {
+	Hostname string         `json:"hostname"`
+	Interval int            `json:"interval"`
+	Output   CollectdOutput `json:"output"`
+}
+
+// CollectdPlugin describes how a monitoring plugin maps to collectd: the
+// name of the collectd plugin, the options it is loaded with and the type
+// and type instance of the values its thresholds apply to.
+type CollectdPlugin struct {
+	Name          string            `json:"name"`
+	Options       map[string]string `json:"options"`
+	ThresholdType string            `json:"thresholdtype"`
+	TypeInstance  string            `json:"typeinstance"`
+}
+
+// collectdPlugin returns the collectd mapping of the registered plugin.
+func collectdPlugin(name string) (CollectdPlugin, bool) {
+	descriptor, ok := GetPluginDescriptor(name)
+	if !ok || descriptor.Collectd == nil {
+		return CollectdPlugin{}, false
+	}
+	return *descriptor.Collectd, true
+}
+
+// ValidateCollectdPlugins checks the plugins can be rendered, reporting all
+// the problems found.
+func ValidateCollectdPlugins(plugins []Plugin) error {
+	var errs []string
+	seen := make(map[string]bool)
+	for _, plugin := range plugins {
+		descriptor, ok := GetPluginDescriptor(plugin.Name)
+		if !ok || descriptor.Collectd == nil {
+			errs = append(errs, fmt.Sprintf("Unsupported plugin %s", plugin.Name))
+			continue
+		}
+		if seen[plugin.Name] {
+			errs = append(errs, fmt.Sprintf("Plugin %s configured more than once", plugin.Name))
+		}
+		seen[plugin.Name] = true
+		thresholds := make(map[string]float64)
+		for _, config := range plugin.Configs {
+			if err := descriptor.ValidateConfig(config); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			switch config.Category {
+			case THRESHOLD:
+				value, err := strconv.ParseFloat(config.Value, 64)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s threshold %v of plugin %s is not a number", config.Type, config.Value, plugin.Name))
+					continue
+				}
+				thresholds[config.Type] = value
+			case INTERVAL:
+				if interval, err := strconv.Atoi(config.Value); err != nil || interval <= 0 {
+					errs = append(errs, fmt.Sprintf("Interval %v of plugin %s is not a positive number of seconds", config.Value, plugin.Name))
+				}
+			case MISCELLANEOUS:
+				if !validCollectdOption(config.Type) {
+					errs = append(errs, fmt.Sprintf("Invalid option %s of plugin %s", config.Type, plugin.Name))
+				}
+			}
+		}
+		warning, warningOk := thresholds[WARNING]
+		critical, criticalOk := thresholds[CRITICAL]
+		if warningOk && criticalOk && warning > critical {
+			errs = append(errs, fmt.Sprintf("Warning threshold %v of plugin %s is above the critical threshold %v", warning, plugin.Name, critical))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ". "))
+	}
+	return nil
+}
+
+func validCollectdOption(option string) bool {
+	if option == "" {
+		return false
+	}
+	for _, char := range option {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (o CollectdOutput) Valid() error {
+	if o.Type != COLLECTD_OUTPUT_GRAPHITE && o.Type != COLLECTD_OUTPUT_NETWORK {
+		return fmt.Errorf("Unsupported collectd output %s", o.Type)
+	}
+	if o.Host == "" {
+		return fmt.Errorf("Host of collectd output %s not specified", o.Type)
+	}
+	if o.Port <= 0 || o.Port > 65535 {
+		return fmt.Errorf("Invalid port %d of collectd output %s", o.Port, o.Type)
+	}
+	if o.Protocol != "" && o.Protocol != "tcp" && o.Protocol != "udp" {
+		return fmt.Errorf("Unsupported protocol %s of collectd output %s", o.Protocol, o.Type)
+	}
+	return nil
+}
+
+// quoteCollectdValue quotes the value unless it is a number or a boolean.
+func quoteCollectdValue(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+func sortedKeys(options map[string]string) []string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RenderCollectdConfig renders the complete collectd configuration of the
+// enabled plugins: their LoadPlugin blocks with their intervals, their
+// options, the thresholds of the threshold plugin and the output.
+func RenderCollectdConfig(plugins []Plugin, config CollectdConfig) (string, error) {
+	if err := ValidateCollectdPlugins(plugins); err != nil {
+		return "", err
+	}
+	if err := config.Output.Valid(); err != nil {
+		return "", err
+	}
+	var enabled []Plugin
+	for _, plugin := range plugins {
+		if plugin.Enable {
+			enabled = append(enabled, plugin)
+		}
+	}
+	sort.Sort(pluginsByName(enabled))
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by skyring. Changes done here are overwritten.\n\n")
+	if config.Hostname != "" {
+		fmt.Fprintf(&buf, "Hostname %s\n", strconv.Quote(config.Hostname))
+	}
+	if config.Interval > 0 {
+		fmt.Fprintf(&buf, "Interval %d\n", config.Interval)
+	}
+	buf.WriteString("\n")
+
+	for _, plugin := range enabled {
+		mapping, _ := collectdPlugin(plugin.Name)
+		name := mapping.Name
+		var interval string
+		for _, pluginConfig := range plugin.Configs {
+			if pluginConfig.Category == INTERVAL {
+				interval = pluginConfig.Value
+			}
+		}
+		if interval == "" {
+			fmt.Fprintf(&buf, "LoadPlugin %s\n", name)
+		} else {
+			fmt.Fprintf(&buf, "<LoadPlugin %s>\n  Interval %s\n</LoadPlugin>\n", name, interval)
+		}
+	}
+	buf.WriteString("LoadPlugin threshold\n")
+	fmt.Fprintf(&buf, "LoadPlugin %s\n", config.Output.Type)
+
+	for _, plugin := range enabled {
+		mapping, _ := collectdPlugin(plugin.Name)
+		options := make(map[string]string)
+		for key, value := range mapping.Options {
+			options[key] = value
+		}
+		for _, pluginConfig := range plugin.Configs {
+			if pluginConfig.Category == MISCELLANEOUS {
+				options[pluginConfig.Type] = pluginConfig.Value
+			}
+		}
+		if len(options) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n<Plugin %s>\n", strconv.Quote(mapping.Name))
+		for _, key := range sortedKeys(options) {
+			fmt.Fprintf(&buf, "  %s %s\n", key, quoteCollectdValue(options[key]))
+		}
+		buf.WriteString("</Plugin>\n")
+	}
+
+	buf.WriteString("\n<Plugin \"threshold\">\n")
+	for _, plugin := range enabled {
+		thresholds := make(map[string]string)
+		for _, pluginConfig := range plugin.Configs {
+			if pluginConfig.Category == THRESHOLD {
+				thresholds[pluginConfig.Type] = pluginConfig.Value
+			}
+		}
+		if len(thresholds) == 0 {
+			continue
+		}
+		mapping, _ := collectdPlugin(plugin.Name)
+		fmt.Fprintf(&buf, "  <Plugin %s>\n    <Type %s>\n", strconv.Quote(mapping.Name), strconv.Quote(mapping.ThresholdType))
+		if mapping.TypeInstance != "" {
+			fmt.Fprintf(&buf, "      Instance %s\n", strconv.Quote(mapping.TypeInstance))
+		}
+		if value, ok := thresholds[WARNING]; ok {
+			fmt.Fprintf(&buf, "      WarningMax %s\n", value)
+		}
+		if value, ok := thresholds[CRITICAL]; ok {
+			fmt.Fprintf(&buf, "      FailureMax %s\n", value)
+		}
+		buf.WriteString("    </Type>\n  </Plugin>\n")
+	}
+	buf.WriteString("</Plugin>\n")
+
+	output := config.Output
+	protocol := output.Protocol
+	switch output.Type {
+	case COLLECTD_OUTPUT_GRAPHITE:
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		fmt.Fprintf(&buf, "\n<Plugin \"write_graphite\">\n  <Node \"skyring\">\n")
+		fmt.Fprintf(&buf, "    Host %s\n    Port \"%d\"\n    Protocol %s\n", strconv.Quote(output.Host), output.Port, strconv.Quote(protocol))
+		if output.Prefix != "" {
+			fmt.Fprintf(&buf, "    Prefix %s\n", strconv.Quote(output.Prefix))
+		}
+		buf.WriteString("    StoreRates true\n    AlwaysAppendDS false\n    EscapeCharacter \"_\"\n  </Node>\n</Plugin>\n")
+	case COLLECTD_OUTPUT_NETWORK:
+		fmt.Fprintf(&buf, "\n<Plugin \"network\">\n  Server %s \"%d\"\n</Plugin>\n", strconv.Quote(output.Host), output.Port)
+	}
+	return buf.String(), nil
+}
+
+// DiffCollectdConfig compares the current configuration on a node with the
+// expected one line by line. The lines only in the current configuration
+// are prefixed with "-" and those only in the expected one with "+". It
+// returns nil if the configurations match.
+func DiffCollectdConfig(current string, expected string) []string {
+	currentLines := strings.Split(strings.TrimRight(current, "\n"), "\n")
+	expectedLines := strings.Split(strings.TrimRight(expected, "\n"), "\n")
+	// Longest common subsequence of the lines
+	lcs := make([][]int, len(currentLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(expectedLines)+1)
+	}
+	for i := len(currentLines) - 1; i >= 0; i-- {
+		for j := len(expectedLines) - 1; j >= 0; j-- {
+			if currentLines[i] == expectedLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(currentLines) || j < len(expectedLines) {
+		switch {
+		case i < len(currentLines) && j < len(expectedLines) && currentLines[i] == expectedLines[j]:
+			i++
+			j++
+		case j < len(expectedLines) && (i == len(currentLines) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff = append(diff, "+"+expectedLines[j])
+			j++
+		default:
+			diff = append(diff, "-"+currentLines[i])
+			i++
+		}
+	}
+	return diff
+}
+
+type pluginsByName []Plugin
+
+func (slice pluginsByName) Len() int {
+	return len(slice)
+}
+
+func (slice pluginsByName) Less(i, j int) bool {
+	return slice[i].Name < slice[j].Name
+}
+
+func (slice pluginsByName) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
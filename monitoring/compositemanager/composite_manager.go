@@ -0,0 +1,235 @@
+package compositemanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	TimeSeriesDBManagerName = "CompositeManager"
+)
+
+// BackendConfig names a registered monitoring manager and its config file.
+// The values are pushed to Hostname and Port if set, or else to the host
+// and port PushToDb is called with.
+type BackendConfig struct {
+	Name       string `json:"name"`
+	ConfigFile string `json:"configfile"`
+	Hostname   string `json:"hostname"`
+	Port       int    `json:"port"`
+}
+
+// CompositeConfig is the json config of the manager. The first backend is
+// the primary.
+type CompositeConfig struct {
+	Backends []BackendConfig `json:"backends"`
+}
+
+// Backend is a monitoring manager the composite manager writes to and
+// reads from.
+type Backend struct {
+	Name     string
+	Manager  monitoring.MonitoringManagerInterface
+	Hostname string
+	Port     int
+}
+
+func (b Backend) pushTarget(hostName string, port int) (string, int) {
+	if b.Hostname != "" {
+		hostName = b.Hostname
+	}
+	if b.Port != 0 {
+		port = b.Port
+	}
+	return hostName, port
+}
+
+// CompositeManager writes the values to all its backends and reads them
+// from the primary backend, the first one, falling back to the next ones
+// in order when the primary fails or has no values.
+type CompositeManager struct {
+	backends []Backend
+}
+
+func init() {
+	monitoring.RegisterMonitoringManager(TimeSeriesDBManagerName, func(config io.Reader) (monitoring.MonitoringManagerInterface, error) {
+		return NewCompositeManagerFromConfig(config)
+	})
+}
+
+func NewCompositeManager(backends ...Backend) (*CompositeManager, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("No backend configured")
+	}
+	for _, backend := range backends {
+		if backend.Manager == nil {
+			return nil, fmt.Errorf("Manager of backend %s not specified", backend.Name)
+		}
+	}
+	return &CompositeManager{backends: backends}, nil
+}
+
+// NewCompositeManagerFromConfig initializes the registered managers of the
+// backends of the json config.
+func NewCompositeManagerFromConfig(config io.Reader) (*CompositeManager, error) {
+	if config == nil {
+		return nil, fmt.Errorf("Config of %s not specified", TimeSeriesDBManagerName)
+	}
+	contents, err := ioutil.ReadAll(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the config. Error: %v", err)
+	}
+	var compositeConfig CompositeConfig
+	if err := json.Unmarshal(bytes.TrimSpace(contents), &compositeConfig); err != nil {
+		return nil, fmt.Errorf("Failed to parse the config. Error: %v", err)
+	}
+	var backends []Backend
+	for _, backendConfig := range compositeConfig.Backends {
+		if backendConfig.Name == TimeSeriesDBManagerName {
+			return nil, fmt.Errorf("%s cannot be a backend of itself", TimeSeriesDBManagerName)
+		}
+		manager, err := monitoring.InitMonitoringManager(backendConfig.Name, backendConfig.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to initialize the backend %s. Error: %v", backendConfig.Name, err)
+		}
+		if manager == nil {
+			return nil, fmt.Errorf("Failed to initialize the backend %s", backendConfig.Name)
+		}
+		backends = append(backends, Backend{
+			Name:     backendConfig.Name,
+			Manager:  manager,
+			Hostname: backendConfig.Hostname,
+			Port:     backendConfig.Port,
+		})
+	}
+	return NewCompositeManager(backends...)
+}
+
+func (cm CompositeManager) Backends() []Backend {
+	return cm.backends
+}
+
+// PushToDb pushes the metrics to all the backends, failing if any of them
+// failed.
+func (cm CompositeManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) error {
+	var errs []string
+	for _, backend := range cm.backends {
+		backendHost, backendPort := backend.pushTarget(hostName, port)
+		if err := backend.Manager.PushToDb(metrics, backendHost, backendPort); err != nil {
+			logger.Get().Error("Failed to push the metrics to %s. Error: %v", backend.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", backend.Name, err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Failed to push the metrics to the backends. Error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cm CompositeManager) QueryDB(query monitoring.MetricQuery) ([]monitoring.Series, error) {
+	var err error
+	for index, backend := range cm.backends {
+		var series []monitoring.Series
+		if series, err = backend.Manager.QueryDB(query); err == nil && (len(series) != 0 || index == len(cm.backends)-1) {
+			return series, nil
+		}
+		if err != nil {
+			logger.Get().Warning("Failed to query %s. Error: %v", backend.Name, err)
+		}
+	}
+	return nil, err
+}
+
+func (cm CompositeManager) QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error {
+	var err error
+	// The managers write nothing to w when they fail
+	for _, backend := range cm.backends {
+		if err = backend.Manager.QueryMonitoringDB(urlStr, w, r); err == nil {
+			return nil
+		}
+		logger.Get().Warning("Failed to query %s. Error: %v", backend.Name, err)
+	}
+	return err
+}
+
+func (cm CompositeManager) GetInstantValue(node string, resource_name string) (float64, error) {
+	var err error
+	for _, backend := range cm.backends {
+		var value float64
+		if value, err = backend.Manager.GetInstantValue(node, resource_name); err == nil {
+			return value, nil
+		}
+		logger.Get().Warning("Failed to get the instant %s of %s from %s. Error: %v", resource_name, node, backend.Name, err)
+	}
+	return 0, err
+}
+
+func (cm CompositeManager) GetResourceName(params map[string]interface{}) (string, error) {
+	return cm.backends[0].Manager.GetResourceName(params)
+}
+
+func (cm CompositeManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
+	for _, backend := range cm.backends {
+		if aggregatedValue, err, isCompleteFailure = backend.Manager.GetInstantValuesAggregation(node, resource_name, exceptionResources); !isCompleteFailure {
+			return aggregatedValue, err, false
+		}
+		logger.Get().Warning("Failed to get the instant %s of %s from %s. Error: %v", resource_name, node, backend.Name, err)
+	}
+	return aggregatedValue, err, isCompleteFailure
+}
+
+// fanOutWriter writes the samples with the writers of all the backends. A
+// batch failing on any backend is written again to all of them, which
+// overwrites the same values on the others.
+type fanOutWriter struct {
+	names   []string
+	writers []monitoring.SampleWriter
+}
+
+func (fw fanOutWriter) each(do func(writer monitoring.SampleWriter) error) error {
+	var errs []string
+	for index, writer := range fw.writers {
+		if err := do(writer); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", fw.names[index], err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (fw fanOutWriter) Connect() error {
+	return fw.each(func(writer monitoring.SampleWriter) error { return writer.Connect() })
+}
+
+func (fw fanOutWriter) Write(samples []monitoring.Sample) error {
+	return fw.each(func(writer monitoring.SampleWriter) error { return writer.Write(samples) })
+}
+
+func (fw fanOutWriter) Close() error {
+	return fw.each(func(writer monitoring.SampleWriter) error { return writer.Close() })
+}
+
+// NewSampleWriter returns the writer writing the samples to all the
+// backends, natively where the backend provides a writer.
+func (cm CompositeManager) NewSampleWriter(hostName string, port int) (monitoring.SampleWriter, error) {
+	var writer fanOutWriter
+	for _, backend := range cm.backends {
+		backendHost, backendPort := backend.pushTarget(hostName, port)
+		backendWriter, err := monitoring.NewSampleWriter(backend.Manager, backendHost, backendPort)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get the writer of %s. Error: %v", backend.Name, err)
+		}
+		writer.names = append(writer.names, backend.Name)
+		writer.writers = append(writer.writers, backendWriter)
+	}
+	return writer, nil
+}
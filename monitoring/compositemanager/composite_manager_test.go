@@ -0,0 +1,52 @@
+package compositemanager
+
+import (
+	"github.com/skyrings/skyring-common/monitoring"
+	_ "github.com/skyrings/skyring-common/monitoring/graphitemanager"
+	_ "github.com/skyrings/skyring-common/monitoring/influxdbmanager"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompositeManagerFromConfig(t *testing.T) {
+	type result struct {
+		manager monitoring.MonitoringManagerInterface
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		manager, err := monitoring.GetMonitoringManager(TimeSeriesDBManagerName, strings.NewReader(`{"backends":[{"name":"GraphiteManager"},{"name":"InfluxdbManager","hostname":"influx","port":8086}]}`))
+		results <- result{manager, err}
+	}()
+	var built result
+	select {
+	case built = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Building the composite manager did not complete")
+	}
+	if built.err != nil {
+		t.Fatalf("Failed to build the composite manager. Error: %v", built.err)
+	}
+	composite, ok := built.manager.(*CompositeManager)
+	if !ok {
+		t.Fatalf("Expected a composite manager, got %T", built.manager)
+	}
+	backends := composite.Backends()
+	if len(backends) != 2 || backends[0].Name != "GraphiteManager" || backends[1].Name != "InfluxdbManager" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+	if host, port := backends[1].pushTarget("localhost", 2003); host != "influx" || port != 8086 {
+		t.Errorf("Expected to push to influx:8086, got %s:%d", host, port)
+	}
+	if host, port := backends[0].pushTarget("localhost", 2003); host != "localhost" || port != 2003 {
+		t.Errorf("Expected to push to localhost:2003, got %s:%d", host, port)
+	}
+
+	if _, err := NewCompositeManagerFromConfig(strings.NewReader(`{"backends":[{"name":"CompositeManager"}]}`)); err == nil {
+		t.Errorf("Composite manager with itself as a backend built")
+	}
+	if _, err := NewCompositeManagerFromConfig(strings.NewReader(`{"backends":[]}`)); err == nil {
+		t.Errorf("Composite manager without backends built")
+	}
+}
@@ -1,58 +1,217 @@
 package monitoring
 
-func GetDefaultThresholdValues() (plugins []Plugin) {
-	return []Plugin{
-		{
-			Name:        "df",
-			Description: "Mount Point",
-			Enable:      true,
-			Configs: []PluginConfig{
-				{Category: THRESHOLD, Type: CRITICAL, Value: "90"},
-				{Category: THRESHOLD, Type: WARNING, Value: "80"},
-			},
+import (
+	"fmt"
+)
+
+var (
+	thresholdSchema = ConfigSchema{
+		Category:  THRESHOLD,
+		Types:     []string{CRITICAL, WARNING},
+		ValueKind: CONFIG_VALUE_NUMBER,
+	}
+	percentThresholdSchema = ConfigSchema{
+		Category:  THRESHOLD,
+		Types:     []string{CRITICAL, WARNING},
+		ValueKind: CONFIG_VALUE_NUMBER,
+		Min:       0,
+		Max:       100,
+	}
+	intervalSchema = ConfigSchema{
+		Category:  INTERVAL,
+		ValueKind: CONFIG_VALUE_INTEGER,
+	}
+	miscellaneousSchema = ConfigSchema{
+		Category:  MISCELLANEOUS,
+		ValueKind: CONFIG_VALUE_STRING,
+	}
+)
+
+func defaultThresholds(critical string, warning string) []PluginConfig {
+	return []PluginConfig{
+		{Category: THRESHOLD, Type: CRITICAL, Value: critical},
+		{Category: THRESHOLD, Type: WARNING, Value: warning},
+	}
+}
+
+// The plugins supported out of the box. The collectd plugins come first as
+// the order of the registration is the order the resources are matched in.
+var defaultPluginDescriptors = []PluginDescriptor{
+	{
+		Name:           "df",
+		Description:    "Mount Point",
+		Schema:         []ConfigSchema{percentThresholdSchema, intervalSchema, miscellaneousSchema},
+		Defaults:       defaultThresholds("90", "80"),
+		Enable:         true,
+		Resources:      []string{"df"},
+		ClusterDefault: true,
+		Collectd: &CollectdPlugin{
+			Name:          "df",
+			Options:       map[string]string{"ValuesPercentage": "true"},
+			ThresholdType: PERCENT_USED,
+			TypeInstance:  USED,
 		},
-		{
-			Name:        "memory",
-			Description: "Memory",
-			Enable:      true,
-			Configs: []PluginConfig{
-				{Category: THRESHOLD, Type: CRITICAL, Value: "90"},
-				{Category: THRESHOLD, Type: WARNING, Value: "80"},
-			},
+	},
+	{
+		Name:           MEMORY,
+		Description:    "Memory",
+		Schema:         []ConfigSchema{percentThresholdSchema, intervalSchema, miscellaneousSchema},
+		Defaults:       defaultThresholds("90", "80"),
+		Enable:         true,
+		Resources:      []string{MEMORY, fmt.Sprintf("aggregation-%s-%s", MEMORY, SUM)},
+		ClusterDefault: true,
+		Collectd: &CollectdPlugin{
+			Name:          MEMORY,
+			Options:       map[string]string{"ValuesPercentage": "true"},
+			ThresholdType: PERCENT,
+			TypeInstance:  USED,
 		},
-		{
-			Name:        "cpu",
-			Description: "CPU",
-			Enable:      true,
-			Configs: []PluginConfig{
-				{Category: THRESHOLD, Type: CRITICAL, Value: "90"},
-				{Category: THRESHOLD, Type: WARNING, Value: "80"},
-			},
+	},
+	{
+		Name:           CPU,
+		Description:    "CPU",
+		Schema:         []ConfigSchema{percentThresholdSchema, intervalSchema, miscellaneousSchema},
+		Defaults:       defaultThresholds("90", "80"),
+		Enable:         true,
+		Resources:      []string{CPU},
+		ClusterDefault: true,
+		Collectd: &CollectdPlugin{
+			Name:          CPU,
+			Options:       map[string]string{"ReportByCpu": "false", "ValuesPercentage": "true"},
+			ThresholdType: PERCENT,
+			TypeInstance:  "user",
 		},
-		{
-			Name:        "swap",
-			Description: "Swap",
-			Enable:      true,
-			Configs: []PluginConfig{
-				{Category: THRESHOLD, Type: CRITICAL, Value: "70"},
-				{Category: THRESHOLD, Type: WARNING, Value: "50"},
-			},
+	},
+	{
+		Name:        DISK,
+		Description: "Disk",
+		Schema:      []ConfigSchema{thresholdSchema, intervalSchema, miscellaneousSchema},
+		Enable:      true,
+		Resources:   []string{DISK},
+		Collectd: &CollectdPlugin{
+			Name:          DISK,
+			ThresholdType: "disk_ops",
 		},
+	},
+	{
+		Name:        NETWORK,
+		Description: "Network",
+		Schema:      []ConfigSchema{thresholdSchema, intervalSchema, miscellaneousSchema},
+		Enable:      true,
+		Resources:   []string{NETWORK, INTERFACE},
+		Collectd: &CollectdPlugin{
+			Name:          INTERFACE,
+			Options:       map[string]string{"IgnoreSelected": "true", "Interface": "lo"},
+			ThresholdType: "if_octets",
+		},
+	},
+	{
+		Name:           SWAP,
+		Description:    "Swap",
+		Schema:         []ConfigSchema{percentThresholdSchema, intervalSchema, miscellaneousSchema},
+		Defaults:       defaultThresholds("70", "50"),
+		Enable:         true,
+		Resources:      []string{SWAP, fmt.Sprintf("aggregation-%s-%s", SWAP, SUM)},
+		ClusterDefault: true,
+		Collectd: &CollectdPlugin{
+			Name:          SWAP,
+			Options:       map[string]string{"ValuesPercentage": "true"},
+			ThresholdType: PERCENT,
+			TypeInstance:  USED,
+		},
+	},
+	{
+		Name:        CLUSTER_UTILIZATION,
+		Description: "Cluster",
+		Schema:      []ConfigSchema{percentThresholdSchema},
+		Enable:      true,
+		Resources:   []string{CLUSTER_UTILIZATION},
+	},
+	{
+		Name:        SYSTEM_UTILIZATION,
+		Description: "System",
+		Schema:      []ConfigSchema{percentThresholdSchema},
+		Enable:      true,
+		Resources:   []string{SYSTEM_UTILIZATION},
+	},
+	{
+		Name:          STORAGE_PROFILE_UTILIZATION,
+		Description:   "Storage Profile",
+		Schema:        []ConfigSchema{percentThresholdSchema},
+		Defaults:      defaultThresholds("85", "65"),
+		Enable:        true,
+		Resources:     []string{STORAGE_PROFILE_UTILIZATION},
+		SystemDefault: true,
+	},
+	{
+		Name:        SLU_UTILIZATION,
+		Description: "Storage Logical Unit",
+		Schema:      []ConfigSchema{percentThresholdSchema},
+		Enable:      true,
+		Resources:   []string{SLU_UTILIZATION},
+	},
+	{
+		Name:        NO_OF_OBJECT,
+		Description: "Number of Objects",
+		Enable:      true,
+		Resources:   []string{NO_OF_OBJECT},
+	},
+	{
+		Name:        PG_SUMMARY,
+		Description: "Placement Groups",
+		Enable:      true,
+		Resources:   []string{PG_SUMMARY},
+	},
+	{
+		Name:        "ping",
+		Description: "Network Latency",
+		Enable:      true,
+		Resources:   []string{"ping"},
+	},
+	{
+		Name:        STORAGE_UTILIZATION,
+		Description: "Storage",
+		Schema:      []ConfigSchema{percentThresholdSchema},
+		Enable:      true,
+		Resources:   []string{STORAGE_UTILIZATION},
+	},
+	{
+		Name:        BLOCK_DEVICE_UTILIZATION,
+		Description: "Block Device",
+		Schema:      []ConfigSchema{percentThresholdSchema},
+		Enable:      true,
+	},
+}
+
+func init() {
+	// The logger is not initialized yet and the built in plugins must be
+	// valid, so fail loudly
+	for _, descriptor := range defaultPluginDescriptors {
+		if err := RegisterPlugin(descriptor); err != nil {
+			panic(err)
+		}
 	}
 }
 
+// GetDefaultThresholdValues returns the plugins given to the new clusters.
+func GetDefaultThresholdValues() (plugins []Plugin) {
+	for _, descriptor := range PluginDescriptors() {
+		if descriptor.ClusterDefault {
+			plugins = append(plugins, descriptor.DefaultPlugin())
+		}
+	}
+	return plugins
+}
+
+// GetSystemDefaultThresholdValues returns the system wide default plugins.
 func GetSystemDefaultThresholdValues() map[string]Plugin {
-	return map[string]Plugin{
-		STORAGE_PROFILE_UTILIZATION: {
-			Name:        STORAGE_PROFILE_UTILIZATION,
-			Description: "Storage Profile",
-			Enable:      true,
-			Configs: []PluginConfig{
-				{Category: THRESHOLD, Type: CRITICAL, Value: "85"},
-				{Category: THRESHOLD, Type: WARNING, Value: "65"},
-			},
-		},
+	plugins := make(map[string]Plugin)
+	for _, descriptor := range PluginDescriptors() {
+		if descriptor.SystemDefault {
+			plugins[descriptor.Name] = descriptor.DefaultPlugin()
+		}
 	}
+	return plugins
 }
 
 var DefaultClusterMonitoringInterval = 600
@@ -0,0 +1,330 @@
+package embeddedmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	TimeSeriesDBManagerName = "EmbeddedManager"
+)
+
+// EmbeddedManager keeps the metrics in an on-disk store within the process
+// instead of an external time-series database.
+type EmbeddedManager struct {
+	store *Store
+}
+
+func init() {
+	monitoring.RegisterMonitoringManager(TimeSeriesDBManagerName, func(config io.Reader) (monitoring.MonitoringManagerInterface, error) {
+		return NewEmbeddedManager(config)
+	})
+}
+
+// NewEmbeddedManager opens the store configured by the json config, or the
+// store with DefaultStoreConfig if no config is passed.
+func NewEmbeddedManager(config io.Reader) (*EmbeddedManager, error) {
+	storeConfig := DefaultStoreConfig
+	if config != nil {
+		contents, err := ioutil.ReadAll(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the config. Error: %v", err)
+		}
+		if len(bytes.TrimSpace(contents)) != 0 {
+			if err := json.Unmarshal(contents, &storeConfig); err != nil {
+				return nil, fmt.Errorf("Failed to parse the config. Error: %v", err)
+			}
+		}
+	}
+	store, err := OpenStore(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &EmbeddedManager{store: store}, nil
+}
+
+func (em EmbeddedManager) Store() *Store {
+	return em.store
+}
+
+func (em EmbeddedManager) GetResourceName(params map[string]interface{}) (string, error) {
+	resource_name, ok := params["resource_name"].(string)
+	if !ok {
+		return "", fmt.Errorf("Resource %v not found", params["resource_name"])
+	}
+	buf := new(bytes.Buffer)
+	parsedTemplate, err := template.New("embedded_resource_name").Parse(monitoring.ResourceCollectionName(resource_name))
+	if err != nil {
+		return "", err
+	}
+	if err := parsedTemplate.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// QueryMonitoringDB serves the graphite render style queries with target,
+// from and until parameters directly from the store.
+func (em EmbeddedManager) QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error {
+	query, err := url.ParseQuery(urlStr)
+	if err != nil {
+		return fmt.Errorf("Invalid query %s. Error: %v", urlStr, err)
+	}
+	now := time.Now()
+	from := now.Add(-24 * time.Hour)
+	var until time.Time
+	if fromParam := query.Get("from"); fromParam != "" {
+		if from, err = monitoring.ParseTime(fromParam, now); err != nil {
+			return err
+		}
+	}
+	if untilParam := query.Get("until"); untilParam != "" {
+		if until, err = monitoring.ParseTime(untilParam, now); err != nil {
+			return err
+		}
+	}
+	var metrics []monitoring.Series
+	for _, target := range query["target"] {
+		targetMetrics, err := em.query(globRegex(target), from, until)
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, targetMetrics...)
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		logger.Get().Error("Error encoding the metrics. error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// globRegex translates a series name with graphite style wildcards into a
+// regular expression matching the names of the series, with their tags if
+// any.
+func globRegex(names ...string) *regexp.Regexp {
+	exprs := make([]string, len(names))
+	for index, name := range names {
+		exprs[index] = strings.Replace(regexp.QuoteMeta(name), `\*`, `[^.]*`, -1)
+	}
+	return regexp.MustCompile("^(" + strings.Join(exprs, "|") + ")(;.*)?$")
+}
+
+// seriesRegex returns the regular expression matching the names of the
+// series of the resource of the nodes of the query.
+func seriesRegex(query monitoring.MetricQuery) (*regexp.Regexp, error) {
+	matched, fullQualifiedMetricName := monitoring.MatchResource(query.Resource)
+	if !matched {
+		return nil, fmt.Errorf("%v is an unsupported Resource", query.Resource)
+	}
+	var names []string
+	for _, seriesName := range query.SeriesNames() {
+		name := seriesName.Path()
+		if !fullQualifiedMetricName {
+			name = name + "*.*"
+		}
+		names = append(names, name)
+	}
+	return globRegex(names...), nil
+}
+
+func (em EmbeddedManager) matchingSeries(expr *regexp.Regexp) ([]string, error) {
+	names, err := em.store.Series()
+	if err != nil {
+		return nil, err
+	}
+	var matching []string
+	for _, name := range names {
+		if expr.MatchString(name) {
+			matching = append(matching, name)
+		}
+	}
+	sort.Strings(matching)
+	return matching, nil
+}
+
+func (em EmbeddedManager) query(expr *regexp.Regexp, from time.Time, until time.Time) ([]monitoring.Series, error) {
+	names, err := em.matchingSeries(expr)
+	if err != nil {
+		return nil, err
+	}
+	var series []monitoring.Series
+	for _, name := range names {
+		points, err := em.store.Query(name, from, until)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, monitoring.Series{Name: name, DataPoints: points})
+	}
+	return series, nil
+}
+
+func (em EmbeddedManager) latest(expr *regexp.Regexp) ([]monitoring.Series, error) {
+	names, err := em.matchingSeries(expr)
+	if err != nil {
+		return nil, err
+	}
+	var series []monitoring.Series
+	for _, name := range names {
+		point, err := em.store.Latest(name)
+		if err != nil {
+			logger.Get().Warning("Failed to get the latest value of %s. error: %v", name, err)
+			continue
+		}
+		series = append(series, monitoring.Series{Name: name, DataPoints: []monitoring.DataPoint{point}})
+	}
+	return series, nil
+}
+
+// QueryDB returns the series of the resource of the nodes, holding just the
+// latest point of every series if the interval is monitoring.Latest. The
+// store has no query functions, so the series are aggregated in process.
+func (em EmbeddedManager) QueryDB(query monitoring.MetricQuery) ([]monitoring.Series, error) {
+	if err := query.Valid(); err != nil {
+		return nil, err
+	}
+	expr, err := seriesRegex(query)
+	if err != nil {
+		return nil, err
+	}
+	if query.IsLatest() {
+		series, err := em.latest(expr)
+		if err != nil {
+			return nil, err
+		}
+		return query.AggregateSeries(series, false, true)
+	}
+
+	now := time.Now()
+	from := now.Add(-24 * time.Hour)
+	var until time.Time
+	if query.StartTime != "" {
+		if from, err = monitoring.ParseTime(query.StartTime, now); err != nil {
+			return nil, err
+		}
+	}
+	if query.EndTime != "" {
+		if until, err = monitoring.ParseTime(query.EndTime, now); err != nil {
+			return nil, err
+		}
+	}
+	if query.Interval != "" {
+		if query.EndTime != "" {
+			return nil, fmt.Errorf("Unsupported combination of start time %v and end time %v", query.StartTime, query.EndTime)
+		}
+		duration, err := monitoring.ParseDuration(query.Interval)
+		if err != nil {
+			return nil, err
+		}
+		if query.StartTime != "" {
+			until = from.Add(duration)
+		} else {
+			from = now.Add(-duration)
+		}
+	}
+	series, err := em.query(expr, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return query.AggregateSeries(series, true, true)
+}
+
+func (em EmbeddedManager) GetInstantValue(node string, resource_name string) (float64, error) {
+	node = monitoring.EntityName(node)
+	series, err := em.QueryDB(monitoring.MetricQuery{NodeName: node, Resource: resource_name, Interval: monitoring.Latest})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, err)
+	}
+	if len(series) != 1 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
+	}
+	fVal := series[0].DataPoints[0].Value
+	if math.IsNaN(fVal) {
+		return 0.0, fmt.Errorf("The value %v from resource %v of %v is not a number", fVal, resource_name, node)
+	}
+	return fVal, nil
+}
+
+func isSeriesException(seriesName string, exceptionResources []string, node string) bool {
+	for _, eResource := range exceptionResources {
+		if strings.HasPrefix(seriesName, conf.SystemConfig.TimeSeriesDBConfig.CollectionName+"."+node+"."+eResource+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (em EmbeddedManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
+	node = monitoring.EntityName(node)
+	series, mStatsFetchError := em.QueryDB(monitoring.MetricQuery{NodeName: node, Resource: resource_name, Interval: monitoring.Latest})
+	if mStatsFetchError != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, mStatsFetchError), true
+	}
+	if len(series) == 0 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node), true
+	}
+	metric_cnt := len(series)
+	for _, currentSeries := range series {
+		if isSeriesException(currentSeries.Name, exceptionResources, node) {
+			//The series is in exception list and need not be counted for averaging out.
+			metric_cnt = metric_cnt - 1
+			continue
+		}
+		if val := currentSeries.DataPoints[0].Value; !math.IsNaN(val) {
+			aggregatedValue = aggregatedValue + val
+		}
+	}
+	if metric_cnt == 0 {
+		return 0, fmt.Errorf("No series of %v of %v left to aggregate", resource_name, node), true
+	}
+	return aggregatedValue / float64(metric_cnt), nil, false
+}
+
+// This method takes map[string]map[string]string ==> map[metric/table name]map[timestamp]value
+// The host name and port are not used as the metrics are stored locally.
+// The series are stored relabeled as per the naming scheme.
+func (em EmbeddedManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) (err error) {
+	defer monitoring.ObservePush(TimeSeriesDBManagerName, monitoring.SampleCount(metrics), time.Now(), &err)
+	for tableName, valueMap := range metrics {
+		name, ok, err := monitoring.RelabelSeriesName(tableName)
+		if err != nil {
+			logger.Get().Warning("Skipping the metric %s. Error: %v", tableName, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		points := make([]monitoring.DataPoint, 0, len(valueMap))
+		for timestamp, value := range valueMap {
+			timeInt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse timestamp %v of metric tableName %v.Error: %v", timestamp, tableName, err.Error())
+			}
+			fVal, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse value %v of metric tableName %v.Error: %v", value, tableName, err.Error())
+			}
+			points = append(points, monitoring.DataPoint{Timestamp: timeInt, Value: fVal})
+		}
+		sort.Sort(dataPoints(points))
+		if err := em.store.Append(name.TaggedPath(), points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,587 @@
+package embeddedmanager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+)
+
+// Every point is stored as the unix timestamp followed by the bits of the
+// value, both little endian. The points of the downsampled segments are the
+// averages of their buckets followed by the number of points averaged, so
+// that downsampling them again weighs them right.
+const (
+	pointSize            = 16
+	downsampledPointSize = 24
+)
+
+const segmentSuffix = ".seg"
+
+// RetentionRule overrides the retention of the series with the prefix.
+type RetentionRule struct {
+	Prefix    string `json:"prefix"`
+	Retention int    `json:"retention"`
+}
+
+// DownsamplingRule averages the points older than After seconds into
+// buckets of Resolution seconds.
+type DownsamplingRule struct {
+	After      int `json:"after"`
+	Resolution int `json:"resolution"`
+}
+
+// StoreConfig configures the on-disk store. All durations are in seconds.
+type StoreConfig struct {
+	Path                string             `json:"path"`
+	Retention           int                `json:"retention"`
+	SegmentDuration     int                `json:"segmentduration"`
+	MaintenanceInterval int                `json:"maintenanceinterval"`
+	RetentionRules      []RetentionRule    `json:"retentionrules"`
+	Downsampling        []DownsamplingRule `json:"downsampling"`
+}
+
+var DefaultStoreConfig = StoreConfig{
+	Path:                "/var/lib/skyring/tsdb",
+	Retention:           30 * 24 * 3600,
+	SegmentDuration:     2 * 3600,
+	MaintenanceInterval: 600,
+	Downsampling: []DownsamplingRule{
+		{After: 24 * 3600, Resolution: 300},
+		{After: 7 * 24 * 3600, Resolution: 3600},
+	},
+}
+
+type dataPoints []monitoring.DataPoint
+
+func (slice dataPoints) Len() int {
+	return len(slice)
+}
+
+func (slice dataPoints) Less(i, j int) bool {
+	return slice[i].Timestamp < slice[j].Timestamp
+}
+
+func (slice dataPoints) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// segment is a file holding the points of a series from start for the
+// segment duration. Segments with a non zero resolution are downsampled.
+type segment struct {
+	start      int64
+	resolution int
+	path       string
+}
+
+// Store is an append-only time-series store keeping a directory per series
+// and a file per segment of the series.
+type Store struct {
+	config StoreConfig
+	mutex  sync.RWMutex
+	stopCh chan bool
+}
+
+var (
+	storesMutex sync.Mutex
+	stores      = make(map[string]*Store)
+)
+
+// OpenStore opens the store at the path of the config, creating it if
+// needed. Stores are shared by path so that several managers can use the
+// same directory, all with the same config.
+func OpenStore(config StoreConfig) (*Store, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("Path of the time-series store not specified")
+	}
+	config.Path = filepath.Clean(config.Path)
+	if config.SegmentDuration <= 0 {
+		return nil, fmt.Errorf("Invalid segment duration %d", config.SegmentDuration)
+	}
+	for _, rule := range config.Downsampling {
+		if rule.Resolution <= 0 || rule.After < 0 {
+			return nil, fmt.Errorf("Invalid downsampling rule %v", rule)
+		}
+	}
+	sort.Slice(config.Downsampling, func(i, j int) bool {
+		return config.Downsampling[i].After < config.Downsampling[j].After
+	})
+
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+	path := config.Path
+	if store, ok := stores[path]; ok {
+		if !sameConfig(store.config, config) {
+			return nil, fmt.Errorf("The time-series store at %s is already open with a different config", path)
+		}
+		return store, nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create the time-series store at %s. Error: %v", path, err)
+	}
+	store := &Store{config: config, stopCh: make(chan bool)}
+	stores[path] = store
+	if config.MaintenanceInterval > 0 {
+		go store.maintain(time.Duration(config.MaintenanceInterval) * time.Second)
+	}
+	return store, nil
+}
+
+func sameConfig(a StoreConfig, b StoreConfig) bool {
+	if len(a.RetentionRules) == 0 && len(b.RetentionRules) == 0 {
+		a.RetentionRules, b.RetentionRules = nil, nil
+	}
+	if len(a.Downsampling) == 0 && len(b.Downsampling) == 0 {
+		a.Downsampling, b.Downsampling = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Close stops the periodic maintenance of the store.
+func (s *Store) Close() {
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+	path := s.config.Path
+	if stores[path] == s {
+		delete(stores, path)
+		close(s.stopCh)
+	}
+}
+
+func (s *Store) maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.Compact(time.Now()); err != nil {
+				logger.Get().Error("Failed to compact the time-series store at %s. error: %v", s.config.Path, err)
+			}
+		}
+	}
+}
+
+func (s *Store) seriesDir(name string) string {
+	return filepath.Join(s.config.Path, url.QueryEscape(name))
+}
+
+// Append appends the points to the series.
+func (s *Store) Append(name string, points []monitoring.DataPoint) error {
+	if name == "" {
+		return fmt.Errorf("Series name not specified")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := s.seriesDir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create the directory of series %s. Error: %v", name, err)
+	}
+	segments := make(map[int64][]monitoring.DataPoint)
+	for _, point := range points {
+		start := point.Timestamp - mod(point.Timestamp, int64(s.config.SegmentDuration))
+		segments[start] = append(segments[start], point)
+	}
+	for start, segmentPoints := range segments {
+		if err := appendPoints(filepath.Join(dir, segmentName(start, 0)), segmentPoints); err != nil {
+			return fmt.Errorf("Failed to append to series %s. Error: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func mod(a int64, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m = m + b
+	}
+	return m
+}
+
+func segmentName(start int64, resolution int) string {
+	return fmt.Sprintf("%d-%d%s", start, resolution, segmentSuffix)
+}
+
+func appendPoints(path string, points []monitoring.DataPoint) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writePoints(file, points); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writePoints(w io.Writer, points []monitoring.DataPoint) error {
+	buf := make([]byte, pointSize*len(points))
+	for index, point := range points {
+		binary.LittleEndian.PutUint64(buf[index*pointSize:], uint64(point.Timestamp))
+		binary.LittleEndian.PutUint64(buf[index*pointSize+8:], math.Float64bits(point.Value))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// weightedPoint is a point averaging count points.
+type weightedPoint struct {
+	monitoring.DataPoint
+	count int64
+}
+
+func writeWeightedPoints(w io.Writer, points []weightedPoint) error {
+	buf := make([]byte, downsampledPointSize*len(points))
+	for index, point := range points {
+		binary.LittleEndian.PutUint64(buf[index*downsampledPointSize:], uint64(point.Timestamp))
+		binary.LittleEndian.PutUint64(buf[index*downsampledPointSize+8:], math.Float64bits(point.Value))
+		binary.LittleEndian.PutUint64(buf[index*downsampledPointSize+16:], uint64(point.count))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readWeightedPoints reads the points of the segment, the raw points
+// weighing one.
+func readWeightedPoints(segment segment) ([]weightedPoint, error) {
+	file, err := os.Open(segment.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	size := pointSize
+	if segment.resolution != 0 {
+		size = downsampledPointSize
+	}
+	var points []weightedPoint
+	reader := bufio.NewReader(file)
+	buf := make([]byte, size)
+	for {
+		// A partially written point at the end is ignored
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return points, nil
+			}
+			return nil, err
+		}
+		point := weightedPoint{
+			DataPoint: monitoring.DataPoint{
+				Timestamp: int64(binary.LittleEndian.Uint64(buf)),
+				Value:     math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+			},
+			count: 1,
+		}
+		if size == downsampledPointSize {
+			point.count = int64(binary.LittleEndian.Uint64(buf[16:]))
+		}
+		points = append(points, point)
+	}
+}
+
+func readPoints(segment segment) ([]monitoring.DataPoint, error) {
+	weighted, err := readWeightedPoints(segment)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]monitoring.DataPoint, len(weighted))
+	for index, point := range weighted {
+		points[index] = point.DataPoint
+	}
+	return points, nil
+}
+
+func (s *Store) segments(name string) ([]segment, error) {
+	dir := s.seriesDir(name)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segments []segment
+	for _, file := range files {
+		fileName := file.Name()
+		if !strings.HasSuffix(fileName, segmentSuffix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimSuffix(fileName, segmentSuffix), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		start, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		resolution, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{start: start, resolution: resolution, path: filepath.Join(dir, fileName)})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].start == segments[j].start {
+			return segments[i].resolution > segments[j].resolution
+		}
+		return segments[i].start < segments[j].start
+	})
+	return segments, nil
+}
+
+// Series returns the names of all the series in the store.
+func (s *Store) Series() ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	files, err := ioutil.ReadDir(s.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		name, err := url.QueryUnescape(file.Name())
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Query returns the points of the series from from to until, both
+// inclusive, ordered by time. A zero until leaves the range open.
+func (s *Store) Query(name string, from time.Time, until time.Time) ([]monitoring.DataPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	segments, err := s.segments(name)
+	if err != nil {
+		return nil, err
+	}
+	var points []monitoring.DataPoint
+	for _, segment := range segments {
+		if segment.start+int64(s.config.SegmentDuration) <= from.Unix() || (!until.IsZero() && segment.start > until.Unix()) {
+			continue
+		}
+		segmentPoints, err := readPoints(segment)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the segment %s. Error: %v", segment.path, err)
+		}
+		for _, point := range segmentPoints {
+			if point.Timestamp >= from.Unix() && (until.IsZero() || point.Timestamp <= until.Unix()) {
+				points = append(points, point)
+			}
+		}
+	}
+	sort.Stable(dataPoints(points))
+	return points, nil
+}
+
+// Latest returns the most recent point of the series.
+func (s *Store) Latest(name string) (monitoring.DataPoint, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	segments, err := s.segments(name)
+	if err != nil {
+		return monitoring.DataPoint{}, err
+	}
+	for index := len(segments) - 1; index >= 0; index-- {
+		segmentPoints, err := readPoints(segments[index])
+		if err != nil {
+			return monitoring.DataPoint{}, fmt.Errorf("Failed to read the segment %s. Error: %v", segments[index].path, err)
+		}
+		if len(segmentPoints) == 0 {
+			continue
+		}
+		latest := segmentPoints[0]
+		for _, point := range segmentPoints {
+			if point.Timestamp >= latest.Timestamp {
+				latest = point
+			}
+		}
+		// Other segments of the same start may hold more recent points
+		for index > 0 && segments[index-1].start == segments[index].start {
+			index--
+			otherPoints, err := readPoints(segments[index])
+			if err != nil {
+				return monitoring.DataPoint{}, fmt.Errorf("Failed to read the segment %s. Error: %v", segments[index].path, err)
+			}
+			for _, point := range otherPoints {
+				if point.Timestamp > latest.Timestamp {
+					latest = point
+				}
+			}
+		}
+		return latest, nil
+	}
+	return monitoring.DataPoint{}, fmt.Errorf("No points in series %s", name)
+}
+
+func (s *Store) retention(name string) int {
+	retention := s.config.Retention
+	longestPrefix := -1
+	for _, rule := range s.config.RetentionRules {
+		if strings.HasPrefix(name, rule.Prefix) && len(rule.Prefix) > longestPrefix {
+			retention = rule.Retention
+			longestPrefix = len(rule.Prefix)
+		}
+	}
+	return retention
+}
+
+// Compact drops the segments past the retention of their series and
+// downsamples the segments as per the downsampling rules.
+func (s *Store) Compact(now time.Time) error {
+	names, err := s.Series()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var errs []string
+	for _, name := range names {
+		if err := s.compactSeries(name, now); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *Store) compactSeries(name string, now time.Time) error {
+	segments, err := s.segments(name)
+	if err != nil {
+		return err
+	}
+	retention := s.retention(name)
+	segmentDuration := int64(s.config.SegmentDuration)
+
+	// Segments of the same start are downsampled together
+	groups := make(map[int64][]segment)
+	for _, segment := range segments {
+		if retention > 0 && segment.start+segmentDuration <= now.Unix()-int64(retention) {
+			if err := os.Remove(segment.path); err != nil {
+				return fmt.Errorf("Failed to remove the segment %s. Error: %v", segment.path, err)
+			}
+			continue
+		}
+		groups[segment.start] = append(groups[segment.start], segment)
+	}
+	if len(groups) == 0 {
+		// Drop the series altogether once all of its points expired
+		os.Remove(s.seriesDir(name))
+		return nil
+	}
+
+	for start, group := range groups {
+		resolution := 0
+		for _, rule := range s.config.Downsampling {
+			if start+segmentDuration <= now.Unix()-int64(rule.After) {
+				resolution = rule.Resolution
+			}
+		}
+		if resolution == 0 || (len(group) == 1 && group[0].resolution >= resolution) {
+			continue
+		}
+		if err := s.downsample(name, start, group, resolution); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) downsample(name string, start int64, group []segment, resolution int) error {
+	type bucket struct {
+		sum   float64
+		count int64
+	}
+	buckets := make(map[int64]*bucket)
+	for _, segment := range group {
+		if segment.resolution > resolution {
+			resolution = segment.resolution
+		}
+	}
+	for _, segment := range group {
+		points, err := readWeightedPoints(segment)
+		if err != nil {
+			return fmt.Errorf("Failed to read the segment %s. Error: %v", segment.path, err)
+		}
+		for _, point := range points {
+			if math.IsNaN(point.Value) || point.count <= 0 {
+				continue
+			}
+			timestamp := point.Timestamp - mod(point.Timestamp, int64(resolution))
+			if _, ok := buckets[timestamp]; !ok {
+				buckets[timestamp] = &bucket{}
+			}
+			buckets[timestamp].sum += point.Value * float64(point.count)
+			buckets[timestamp].count += point.count
+		}
+	}
+	points := make([]weightedPoint, 0, len(buckets))
+	for timestamp, bucket := range buckets {
+		points = append(points, weightedPoint{
+			DataPoint: monitoring.DataPoint{Timestamp: timestamp, Value: bucket.sum / float64(bucket.count)},
+			count:     bucket.count,
+		})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+
+	// Write the downsampled segment aside and switch to it atomically
+	path := filepath.Join(s.seriesDir(name), segmentName(start, resolution))
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("Failed to create the segment %s. Error: %v", tmpPath, err)
+	}
+	if err := writeWeightedPoints(file, points); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("Failed to write the segment %s. Error: %v", tmpPath, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("Failed to write the segment %s. Error: %v", tmpPath, err)
+	}
+	file.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Failed to replace the segment %s. Error: %v", path, err)
+	}
+	for _, segment := range group {
+		if segment.path == path {
+			continue
+		}
+		if err := os.Remove(segment.path); err != nil {
+			return fmt.Errorf("Failed to remove the segment %s. Error: %v", segment.path, err)
+		}
+	}
+	return nil
+}
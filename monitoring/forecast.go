@@ -0,0 +1,220 @@
+package monitoring
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Forecasting models
+const (
+	FORECAST_MODEL_LINEAR   = "linear"
+	FORECAST_MODEL_SEASONAL = "seasonal"
+)
+
+var (
+	// The season of the seasonal model
+	ForecastSeason = 24 * time.Hour
+	// The resolution of the seasonal profile
+	ForecastSeasonStep = time.Hour
+	// The crossings further than the horizon are not reported
+	ForecastHorizon = 365 * 24 * time.Hour
+	// The least number of points fitted
+	MinForecastPoints = 10
+)
+
+// Forecast is the growth of a percentage series fitted by a model, with the
+// estimated times the series crosses the warning level and gets full. The
+// times are zero and the durations, in seconds, are -1 if the series is not
+// estimated to cross the levels within ForecastHorizon.
+type Forecast struct {
+	Model         string    `json:"model"`
+	Current       float64   `json:"current"`
+	GrowthPerDay  float64   `json:"growthperday"`
+	Error         float64   `json:"error"`
+	Points        int       `json:"points"`
+	WarningLevel  float64   `json:"warninglevel"`
+	WarningAt     time.Time `json:"warningat"`
+	TimeToWarning int64     `json:"timetowarning"`
+	FullAt        time.Time `json:"fullat"`
+	TimeToFull    int64     `json:"timetofull"`
+	EarlyWarning  bool      `json:"earlywarning"`
+	UpdatedAt     time.Time `json:"updatedat"`
+}
+
+// forecastModel predicts the value of the series at the unix timestamp.
+type forecastModel func(timestamp float64) float64
+
+// fitLinear fits the points by least squares, returning the slope per second
+// and the intercept at the timestamp 0.
+func fitLinear(timestamps []float64, values []float64) (float64, float64) {
+	var sumT, sumV float64
+	n := float64(len(timestamps))
+	for index := range timestamps {
+		sumT = sumT + timestamps[index]
+		sumV = sumV + values[index]
+	}
+	meanT := sumT / n
+	meanV := sumV / n
+	var covariance, variance float64
+	for index := range timestamps {
+		covariance = covariance + (timestamps[index]-meanT)*(values[index]-meanV)
+		variance = variance + (timestamps[index]-meanT)*(timestamps[index]-meanT)
+	}
+	if variance == 0 {
+		return 0, meanV
+	}
+	slope := covariance / variance
+	return slope, meanV - slope*meanT
+}
+
+// rmse is the root mean square error of the model over the points.
+func rmse(model forecastModel, timestamps []float64, values []float64) float64 {
+	var sum float64
+	for index := range timestamps {
+		diff := model(timestamps[index]) - values[index]
+		sum = sum + diff*diff
+	}
+	return math.Sqrt(sum / float64(len(timestamps)))
+}
+
+func seasonBin(timestamp float64) int {
+	season := ForecastSeason.Seconds()
+	phase := math.Mod(timestamp, season)
+	if phase < 0 {
+		phase = phase + season
+	}
+	return int(phase / ForecastSeasonStep.Seconds())
+}
+
+// fitSeasonal fits a linear trend plus the mean offset of every step of the
+// season from that trend.
+func fitSeasonal(timestamps []float64, values []float64) (float64, float64, []float64) {
+	slope, intercept := fitLinear(timestamps, values)
+	bins := int(ForecastSeason / ForecastSeasonStep)
+	sums := make([]float64, bins)
+	counts := make([]float64, bins)
+	for index, timestamp := range timestamps {
+		bin := seasonBin(timestamp)
+		sums[bin] = sums[bin] + values[index] - (slope*timestamp + intercept)
+		counts[bin] = counts[bin] + 1
+	}
+	offsets := make([]float64, bins)
+	for bin := range offsets {
+		if counts[bin] != 0 {
+			offsets[bin] = sums[bin] / counts[bin]
+		}
+	}
+	// Fit the trend again on the values without their seasonal offsets
+	adjusted := make([]float64, len(values))
+	for index, timestamp := range timestamps {
+		adjusted[index] = values[index] - offsets[seasonBin(timestamp)]
+	}
+	slope, intercept = fitLinear(timestamps, adjusted)
+	return slope, intercept, offsets
+}
+
+// crossing returns the first timestamp from now the model reaches the level,
+// scanning ahead by step, and false if it does not within ForecastHorizon.
+func crossing(model forecastModel, now float64, step float64, level float64) (float64, bool) {
+	for timestamp := now; timestamp <= now+ForecastHorizon.Seconds(); timestamp = timestamp + step {
+		if model(timestamp) >= level {
+			return timestamp, true
+		}
+	}
+	return 0, false
+}
+
+// ForecastSeries fits the percentage series with the linear and, given two
+// seasons of data, the seasonal model and forecasts with the model fitting
+// it better. The early warning is set if the series is estimated to cross
+// the warning level within the window.
+func ForecastSeries(series Series, warningLevel float64, window time.Duration, now time.Time) (Forecast, bool) {
+	var points []DataPoint
+	for _, point := range series.DataPoints {
+		if !math.IsNaN(point.Value) && !math.IsInf(point.Value, 0) {
+			points = append(points, point)
+		}
+	}
+	if len(points) < MinForecastPoints {
+		return Forecast{}, false
+	}
+	sort.Sort(dataPoints(points))
+	timestamps := make([]float64, len(points))
+	values := make([]float64, len(points))
+	for index, point := range points {
+		timestamps[index] = float64(point.Timestamp)
+		values[index] = point.Value
+	}
+
+	slope, intercept := fitLinear(timestamps, values)
+	var model forecastModel = func(timestamp float64) float64 {
+		return slope*timestamp + intercept
+	}
+	forecast := Forecast{Model: FORECAST_MODEL_LINEAR, Error: rmse(model, timestamps, values)}
+	if timestamps[len(timestamps)-1]-timestamps[0] >= 2*ForecastSeason.Seconds() {
+		seasonalSlope, seasonalIntercept, offsets := fitSeasonal(timestamps, values)
+		var seasonalModel forecastModel = func(timestamp float64) float64 {
+			return seasonalSlope*timestamp + seasonalIntercept + offsets[seasonBin(timestamp)]
+		}
+		// Prefer the simpler model unless the seasonal one fits clearly better
+		if seasonalError := rmse(seasonalModel, timestamps, values); seasonalError < 0.9*forecast.Error {
+			model = seasonalModel
+			slope = seasonalSlope
+			forecast = Forecast{Model: FORECAST_MODEL_SEASONAL, Error: seasonalError}
+		}
+	}
+
+	nowSeconds := float64(now.Unix())
+	step := ForecastSeasonStep.Seconds()
+	if forecast.Model == FORECAST_MODEL_LINEAR {
+		// The linear model is monotonic, so its crossing is found directly
+		step = ForecastHorizon.Seconds()
+	}
+	forecast.Points = len(points)
+	forecast.Current = values[len(values)-1]
+	forecast.GrowthPerDay = slope * (24 * time.Hour).Seconds()
+	forecast.WarningLevel = warningLevel
+	forecast.UpdatedAt = now
+	forecast.TimeToWarning, forecast.WarningAt = timeTo(model, slope, forecast.Current, nowSeconds, step, warningLevel)
+	forecast.TimeToFull, forecast.FullAt = timeTo(model, slope, forecast.Current, nowSeconds, step, 100)
+	forecast.EarlyWarning = forecast.TimeToWarning >= 0 && forecast.TimeToWarning <= int64(window.Seconds())
+	return forecast, true
+}
+
+// timeTo returns the seconds from now and the time the series reaches the
+// level, or -1 and the zero time if it does not within ForecastHorizon.
+func timeTo(model forecastModel, slope float64, current float64, now float64, step float64, level float64) (int64, time.Time) {
+	if current >= level {
+		return 0, time.Unix(int64(now), 0)
+	}
+	if step >= ForecastHorizon.Seconds() {
+		if slope <= 0 {
+			return -1, time.Time{}
+		}
+		at := now + math.Max(0, (level-model(now))/slope)
+		if at > now+ForecastHorizon.Seconds() {
+			return -1, time.Time{}
+		}
+		return int64(at - now), time.Unix(int64(at), 0)
+	}
+	at, ok := crossing(model, now, step, level)
+	if !ok {
+		return -1, time.Time{}
+	}
+	return int64(at - now), time.Unix(int64(at), 0)
+}
+
+type dataPoints []DataPoint
+
+func (slice dataPoints) Len() int {
+	return len(slice)
+}
+
+func (slice dataPoints) Less(i, j int) bool {
+	return slice[i].Timestamp < slice[j].Timestamp
+}
+
+func (slice dataPoints) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
@@ -0,0 +1,166 @@
+package graphitemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/utils"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackfillOptions select the series copied by Backfill: those prefixed by
+// Prefix, between From and Until. From is required, as the start of the
+// retention of graphite. The series are read in windows of Window,
+// BatchSize series at a time.
+type BackfillOptions struct {
+	Prefix    string
+	From      time.Time
+	Until     time.Time
+	Window    time.Duration
+	BatchSize int
+}
+
+// BackfillStats counts what Backfill copied.
+type BackfillStats struct {
+	Series  int `json:"series"`
+	Samples int `json:"samples"`
+	Failed  int `json:"failed"`
+}
+
+func graphiteHost() string {
+	return net.JoinHostPort(conf.SystemConfig.TimeSeriesDBConfig.Hostname, strconv.Itoa(conf.SystemConfig.TimeSeriesDBConfig.Port))
+}
+
+// listSeries returns the names of all the series of graphite prefixed by
+// prefix.
+func listSeries(prefix string) ([]string, error) {
+	contents, err := util.HTTPGet("http://" + graphiteHost() + "/metrics/index.json")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the series. Error: %v", err)
+	}
+	var names []string
+	if err := json.Unmarshal(contents, &names); err != nil {
+		return nil, fmt.Errorf("Failed to parse the list of the series. Error: %v", err)
+	}
+	var series []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			series = append(series, name)
+		}
+	}
+	return series, nil
+}
+
+// renderSamples reads the values of the series between from and until.
+func renderSamples(names []string, from time.Time, until time.Time) ([]monitoring.Sample, error) {
+	values := url.Values{}
+	for _, name := range names {
+		values.Add("target", name)
+	}
+	values.Set("format", "json")
+	values.Set("from", strconv.FormatInt(from.Unix(), 10))
+	values.Set("until", strconv.FormatInt(until.Unix(), 10))
+	contents, err := util.HTTPGet("http://" + graphiteHost() + "/render?" + values.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var metrics []GraphiteMetric
+	if err := json.Unmarshal(contents, &metrics); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling the metrics. Error: %v", err)
+	}
+	var samples []monitoring.Sample
+	for _, metric := range metrics {
+		for _, statVar := range metric.Stats {
+			if len(statVar) != 2 {
+				continue
+			}
+			value, ok := statVar[0].(float64)
+			if !ok {
+				// Null for the missing values
+				continue
+			}
+			timestamp, ok := statVar[1].(float64)
+			if !ok {
+				continue
+			}
+			samples = append(samples, monitoring.Sample{Name: metric.Target, Timestamp: int64(timestamp), Value: value})
+		}
+	}
+	return samples, nil
+}
+
+// Backfill copies the history of the series of graphite to another
+// monitoring manager, pushing to hostName and port, so that the manager can
+// replace graphite without a gap in the history. The values already copied
+// are overwritten with the same values, so a backfill failed midway can be
+// run again.
+func (tsdbm GraphiteManager) Backfill(target monitoring.MonitoringManagerInterface, hostName string, port int, options BackfillOptions) (BackfillStats, error) {
+	var stats BackfillStats
+	if options.From.IsZero() {
+		return stats, fmt.Errorf("Start of the backfill not specified")
+	}
+	if options.Prefix == "" {
+		options.Prefix = conf.SystemConfig.TimeSeriesDBConfig.CollectionName + "."
+	}
+	if options.Until.IsZero() {
+		options.Until = time.Now()
+	}
+	if options.Window <= 0 {
+		options.Window = 24 * time.Hour
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if !options.From.Before(options.Until) {
+		return stats, fmt.Errorf("Start %v of the backfill is not before its end %v", options.From, options.Until)
+	}
+
+	names, err := listSeries(options.Prefix)
+	if err != nil {
+		return stats, err
+	}
+	stats.Series = len(names)
+	writer, err := monitoring.NewSampleWriter(target, hostName, port)
+	if err != nil {
+		return stats, err
+	}
+	if err := writer.Connect(); err != nil {
+		return stats, err
+	}
+	defer writer.Close()
+
+	for start := 0; start < len(names); start += options.BatchSize {
+		end := start + options.BatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		batch := names[start:end]
+		for from := options.From; from.Before(options.Until); from = from.Add(options.Window) {
+			until := from.Add(options.Window)
+			if until.After(options.Until) {
+				until = options.Until
+			}
+			samples, err := renderSamples(batch, from, until)
+			if err == nil && len(samples) != 0 {
+				err = writer.Write(samples)
+			}
+			if err != nil {
+				logger.Get().Error("Failed to backfill %d series from %v to %v. Error: %v", len(batch), from, until, err)
+				stats.Failed++
+				continue
+			}
+			stats.Samples += len(samples)
+		}
+		logger.Get().Info("Backfilled %d of %d series", end, len(names))
+	}
+	if stats.Failed != 0 {
+		return stats, fmt.Errorf("Failed to backfill %d windows of the series", stats.Failed)
+	}
+	return stats, nil
+}
@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/marpaia/graphite-golang"
 	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/monitoring"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/utils"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"regexp"
@@ -24,6 +24,12 @@ const (
 )
 
 type GraphiteManager struct {
+	proxy *monitoring.RenderProxy
+}
+
+// GraphiteConfig is the json config of the manager.
+type GraphiteConfig struct {
+	Query monitoring.ProxyConfig `json:"query"`
 }
 
 func init() {
@@ -32,21 +38,44 @@ func init() {
 	})
 }
 
+// NewGraphiteManager returns the manager configured by the json config, or
+// with the DefaultProxyConfig if no config is passed.
 func NewGraphiteManager(config io.Reader) (*GraphiteManager, error) {
-	return &GraphiteManager{}, nil
+	graphiteConfig := GraphiteConfig{Query: monitoring.DefaultProxyConfig}
+	if config != nil {
+		contents, err := ioutil.ReadAll(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the config. Error: %v", err)
+		}
+		if len(bytes.TrimSpace(contents)) != 0 {
+			if err := json.Unmarshal(contents, &graphiteConfig); err != nil {
+				return nil, fmt.Errorf("Failed to parse the config. Error: %v", err)
+			}
+		}
+	}
+	// The series are pushed and queried by their paths, the tags would be
+	// lost
+	if monitoring.GetNamingScheme().Tagged() {
+		return nil, fmt.Errorf("The naming scheme tags the series, which graphite does not support")
+	}
+	manager := &GraphiteManager{}
+	switch graphiteConfig.Query.Mode {
+	case "", monitoring.QUERY_MODE_REDIRECT:
+	case monitoring.QUERY_MODE_PROXY:
+		if graphiteConfig.Query.User == "" {
+			graphiteConfig.Query.User = conf.SystemConfig.TimeSeriesDBConfig.User
+			graphiteConfig.Query.Password = conf.SystemConfig.TimeSeriesDBConfig.Password
+		}
+		manager.proxy = monitoring.NewRenderProxy(graphiteConfig.Query, userFacingName(conf.SystemConfig.TimeSeriesDBConfig.CollectionName))
+	default:
+		return nil, fmt.Errorf("Unsupported query mode %s", graphiteConfig.Query.Mode)
+	}
+	return manager, nil
 }
 
 var (
-	templateUrl             = "http://{{.hostname}}:{{.port}}/render?target={{.target}}&format=json"
-	targetLatest            = "cactiStyle({{.targetGeneral}})"
-	targetGeneral           = "{{.collectionname}}.{{.nodename}}.{{.resourcename}}"
-	targetWithParent        = "{{.collectionname}}.{{.parentname}}.{{.resourcename}}_{{.nodename}}"
-	targetWildCard          = "*.*"
-	templateFromTime        = "&from={{.time}}"
-	templateUntilTime       = "&until={{.time}}"
-	timeFormat              = "15:04_20060102"
-	standardTimeFormat      = "2006-01-02T15:04:05.000Z"
-	fullQualifiedMetricName bool
+	timeFormat         = "15:04_20060102"
+	standardTimeFormat = "2006-01-02T15:04:05.000Z"
 )
 
 var SupportedInputTimeFormats = []string{
@@ -99,80 +128,63 @@ func GetTemplateParsedString(urlParams map[string]interface{}, templateString st
 	if err != nil {
 		return "", err
 	}
-	parsedTemplate.Execute(buf, urlParams)
+	if err := parsedTemplate.Execute(buf, urlParams); err != nil {
+		return "", err
+	}
 	return buf.String(), nil
 }
 
-var resourceCollectionNameMapper = map[string]string{
-	monitoring.NETWORK_LATENCY:                                                        "ping.ping-{{.serverName}}",
-	monitoring.CPU_USER:                                                               "cpu.percent-user",
-	monitoring.CPU_SYSTEM:                                                             "cpu.percent-system",
-	monitoring.AGGREGATION + monitoring.INTERFACE + monitoring.OCTETS + monitoring.RX: "interface*.if_octets.rx",
-	monitoring.AGGREGATION + monitoring.INTERFACE + monitoring.OCTETS + monitoring.TX: "interface*.if_octets.tx",
-	monitoring.AGGREGATION + monitoring.DISK + monitoring.READ:                        "disk-*.disk_ops.read",
-	monitoring.AGGREGATION + monitoring.DISK + monitoring.WRITE:                       "disk-*.disk_ops.write",
-	monitoring.AGGREGATION + monitoring.MEMORY:                                        "aggregation-memory-sum.memory",
-	monitoring.AGGREGATION + monitoring.SWAP:                                          "aggregation-swap-sum.swap",
-	monitoring.AVERAGE + monitoring.INTERFACE + monitoring.USED:                       "interface-average.bytes-total_bandwidth_used",
-	monitoring.AVERAGE + monitoring.INTERFACE + monitoring.TOTAL:                      "interface-average.bytes-total_bandwidth",
-	monitoring.AVERAGE + monitoring.INTERFACE + monitoring.PERCENT:                    "interface-average.percent-network_utilization",
-}
-
 func (tsdbm GraphiteManager) GetResourceName(params map[string]interface{}) (string, error) {
 	resource_name, ok := params["resource_name"].(string)
 	if ok {
-		collectionNameTemplate := resourceCollectionNameMapper[resource_name]
+		collectionNameTemplate := monitoring.ResourceCollectionName(resource_name)
 		return GetTemplateParsedString(params, collectionNameTemplate)
 	} else {
 		return "", fmt.Errorf("Resource %v not found", params["resource_name"])
 	}
 }
 
-func getUrlBaseTemplateParams(params map[string]interface{}) (map[string]interface{}, error) {
-	var nodename string
-	var nodeNameError error
-	if nodename, nodeNameError = util.GetString(params["nodename"]); nodeNameError != nil {
-		return nil, nodeNameError
+// getTarget builds the target of the query parameters resource, nodename or
+// nodes, parentName, functions and interval.
+func getTarget(params map[string]interface{}) (Target, error) {
+	resource, err := util.GetString(params["resource"])
+	if err != nil {
+		return Target{}, err
 	}
-	nodename = strings.Replace(nodename, ".", "_", -1)
-	templateString := targetGeneral
-	templateParams := map[string]interface{}{
-		"collectionname": conf.SystemConfig.TimeSeriesDBConfig.CollectionName,
-		"nodename":       nodename,
-		"resourcename":   params["resource"],
+	matched, fullQualifiedMetricName := monitoring.MatchResource(resource)
+	if !matched {
+		return Target{}, fmt.Errorf("%v is an unsupported Resource", resource)
 	}
-	if parentName, ok := params["parentName"]; ok {
-		templateParams["parentname"] = strings.Replace(parentName.(string), ".", "_", -1)
-		templateString = targetWithParent
+	target := Target{
+		Collection:     conf.SystemConfig.TimeSeriesDBConfig.CollectionName,
+		Resource:       resource,
+		FullyQualified: fullQualifiedMetricName,
 	}
-	target, targetErr := GetTemplateParsedString(templateParams, templateString)
-	if targetErr != nil {
-		return nil, targetErr
+	if nodes, ok := params["nodes"].([]string); ok && len(nodes) != 0 {
+		target.Nodes = nodes
+	} else {
+		nodename, err := util.GetString(params["nodename"])
+		if err != nil {
+			return Target{}, err
+		}
+		target.Nodes = []string{nodename}
 	}
-
-	if !fullQualifiedMetricName {
-		target = target + targetWildCard
+	if parentName, ok := params["parentName"].(string); ok {
+		target.Parent = parentName
+	}
+	if functions, ok := params["functions"].([]Function); ok {
+		target.Functions = append(target.Functions, functions...)
 	}
-
 	if params["interval"] == monitoring.Latest {
-		target, targetErr = GetTemplateParsedString(map[string]interface{}{"targetGeneral": target}, targetLatest)
-		if targetErr != nil {
-			return nil, targetErr
-		}
+		target.Functions = append(target.Functions, Call("cactiStyle"))
 	}
-	return map[string]interface{}{
-		"hostname": conf.SystemConfig.TimeSeriesDBConfig.Hostname,
-		"port":     conf.SystemConfig.TimeSeriesDBConfig.Port,
-		"target":   target,
-	}, nil
+	return target, nil
 }
 
+// Matches checks if key is one of the keys or a series name prefixed by one.
 func Matches(key string, keys []string) bool {
 	for _, permittedKey := range keys {
 		if strings.Index(key, permittedKey) == 0 {
-			if key != permittedKey {
-				fullQualifiedMetricName = true
-			}
 			return true
 		}
 	}
@@ -184,7 +196,7 @@ func ValidateRequest(params map[string]interface{}) error {
 	if str, ok := params["resource"].(string); ok {
 		resource = str
 	}
-	if !Matches(resource, monitoring.GeneralResources) {
+	if matched, _ := monitoring.MatchResource(resource); !matched {
 		/*
 			1. Ideally fetch clusterId from nodeId
 			2. Fetch clustertype from clusterId
@@ -199,78 +211,65 @@ func ValidateRequest(params map[string]interface{}) error {
 	return nil
 }
 
-func GetRequestPath(params map[string]interface{}) (string, error) {
-	urlParams, urlParamsError := getUrlBaseTemplateParams(params)
-	if urlParamsError != nil {
-		return "", urlParamsError
-	}
-	url, urlError := GetTemplateParsedString(urlParams, templateUrl)
-	if urlError != nil {
-		return "", urlError
-	}
-	if startTime, ok := params["start_time"]; ok && startTime != "" {
-		validatedStartTime, validatedStartTimeError := GetValidatedGraphiteSupportedTime(startTime, templateFromTime)
-		if validatedStartTimeError != nil {
-			return "", validatedStartTimeError
+// GetRenderRequest builds the render request of the query parameters.
+func GetRenderRequest(params map[string]interface{}) (RenderRequest, error) {
+	target, err := getTarget(params)
+	if err != nil {
+		return RenderRequest{}, err
+	}
+	request := RenderRequest{
+		Hostname: conf.SystemConfig.TimeSeriesDBConfig.Hostname,
+		Port:     conf.SystemConfig.TimeSeriesDBConfig.Port,
+		Targets:  []Target{target},
+	}
+	startTime, _ := params["start_time"].(string)
+	endTime, _ := params["end_time"].(string)
+	if startTime != "" {
+		if request.From, err = GetValidatedGraphiteSupportedTime(startTime); err != nil {
+			return RenderRequest{}, err
 		}
-		url = url + validatedStartTime
 	}
-
-	if endTime, ok := params["end_time"]; ok && endTime != "" {
-		validatedEndTime, validatedEndTimeError := GetValidatedGraphiteSupportedTime(endTime, templateUntilTime)
-		if validatedEndTimeError != nil {
-			return "", validatedEndTimeError
+	if endTime != "" {
+		if request.Until, err = GetValidatedGraphiteSupportedTime(endTime); err != nil {
+			return RenderRequest{}, err
 		}
-		url = url + validatedEndTime
 	}
 
-	if params["interval"] != "" && params["interval"] != monitoring.Latest {
+	if params["interval"] != nil && params["interval"] != "" && params["interval"] != monitoring.Latest {
 		timeString, timeStringError := util.GetString(params["interval"])
 		if timeStringError != nil {
-			return "", fmt.Errorf("Start time %v. Error: %v", params["start_time"], timeStringError)
+			return RenderRequest{}, fmt.Errorf("Start time %v. Error: %v", params["start_time"], timeStringError)
 		}
-		dateFormat, dateFormatErr := formatDate(timeString)
-		if dateFormatErr != nil {
-			return "", dateFormatErr
+		interval, err := GetValidatedGraphiteSupportedTime(timeString)
+		if err != nil {
+			return RenderRequest{}, err
 		}
-		params["interval"] = dateFormat
-		var templ string
-		if params["start_time"] == "" && params["end_time"] == "" {
-			templ = templateFromTime
-		} else if params["start_time"] != "" && params["end_time"] == "" {
-			templ = templateUntilTime
+		if startTime == "" && endTime == "" {
+			request.From = interval
+		} else if startTime != "" && endTime == "" {
+			request.Until = interval
 		} else {
-			return "", fmt.Errorf("Unsupported combination of start time %v and end time %v", params["start_time"], params["end_time"])
-		}
-		urlTime, urlTimeError := GetValidatedGraphiteSupportedTime(timeString, templ)
-		if urlTimeError != nil {
-			return "", urlTimeError
+			return RenderRequest{}, fmt.Errorf("Unsupported combination of start time %v and end time %v", startTime, endTime)
 		}
-		url = url + urlTime
 	}
-	return url, nil
+	return request, nil
 }
 
-func GetValidatedGraphiteSupportedTime(time interface{}, graphiteTimeTemplate string) (string, error) {
+func GetRequestPath(params map[string]interface{}) (string, error) {
+	request, err := GetRenderRequest(params)
+	if err != nil {
+		return "", err
+	}
+	return request.URL()
+}
+
+// GetValidatedGraphiteSupportedTime formats the time as accepted by graphite.
+func GetValidatedGraphiteSupportedTime(time interface{}) (string, error) {
 	timeString, timeStringError := util.GetString(time)
 	if timeStringError != nil {
 		return "", fmt.Errorf("Time %v. Error: %v", time, timeStringError)
 	}
-
-	dateFormat, dateFormatErr := formatDate(timeString)
-	if dateFormatErr != nil {
-		return "", dateFormatErr
-	}
-
-	params := make(map[string]interface{})
-	params["time"] = dateFormat
-
-	urlTime, urlTimeError := GetTemplateParsedString(params, graphiteTimeTemplate)
-	if urlTimeError != nil {
-		return "", urlTimeError
-	}
-
-	return urlTime, nil
+	return formatDate(timeString)
 }
 
 func HttpResponse(w http.ResponseWriter, status_code int, msg string, args ...string) {
@@ -286,13 +285,128 @@ func HttpResponse(w http.ResponseWriter, status_code int, msg string, args ...st
 	return
 }
 
+// userFacingName returns the function stripping the collection off the
+// names of the series within the target expression.
+func userFacingName(collection string) func(string) string {
+	collectionPrefix := regexp.MustCompile(`(^|[(,{\s])` + regexp.QuoteMeta(collection+"."))
+	return func(name string) string {
+		return collectionPrefix.ReplaceAllString(name, "$1")
+	}
+}
+
+// QueryMonitoringDB redirects the client to graphite or, in the proxy mode,
+// serves the response of graphite itself.
 func (tsdbm GraphiteManager) QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error {
 	url := fmt.Sprintf("http://%s:%s/render?%s", conf.SystemConfig.TimeSeriesDBConfig.Hostname, strconv.Itoa(conf.SystemConfig.TimeSeriesDBConfig.Port), urlStr)
+	if tsdbm.proxy != nil {
+		return tsdbm.proxy.Serve(url, w, r)
+	}
 	http.Redirect(w, r, url, http.StatusFound)
 	return nil
 }
 
-func (tsdbm GraphiteManager) QueryDB(params map[string]interface{}) (interface{}, error) {
+var (
+	summarizeFunctions = map[string]string{
+		monitoring.AGGREGATE_AVG: "avg",
+		monitoring.AGGREGATE_MIN: "min",
+		monitoring.AGGREGATE_MAX: "max",
+		monitoring.AGGREGATE_SUM: "sum",
+	}
+	combineFunctions = map[string]string{
+		monitoring.AGGREGATE_AVG: "averageSeries",
+		monitoring.AGGREGATE_MIN: "minSeries",
+		monitoring.AGGREGATE_MAX: "maxSeries",
+		monitoring.AGGREGATE_SUM: "sumSeries",
+	}
+)
+
+// graphiteFunctions translates the aggregation of the query to the graphite
+// functions to be applied to the target in order. The downsampling or the
+// grouping is left to be done in process where graphite lacks the function.
+func graphiteFunctions(query monitoring.MetricQuery) (functions []Function, nativeStep bool, nativeGroup bool) {
+	aggregation := *query.Aggregation
+	if query.IsLatest() {
+		// The latest values are grouped in process
+		return nil, true, aggregation.GroupBy == ""
+	}
+	if aggregation.Step != "" {
+		summarize, ok := summarizeFunctions[aggregation.Function]
+		if !ok {
+			return nil, false, false
+		}
+		step, _ := aggregation.StepDuration()
+		functions = append(functions, Call("summarize", fmt.Sprintf("%ds", int64(step.Seconds())), summarize))
+	}
+	switch aggregation.GroupBy {
+	case "":
+		return functions, true, true
+	case monitoring.GROUP_BY_CLUSTER:
+		if aggregation.Function == monitoring.AGGREGATE_PERCENTILE {
+			functions = append(functions, Call("percentileOfSeries", aggregation.Percentile))
+		} else {
+			functions = append(functions, Call(combineFunctions[aggregation.Function]))
+		}
+		functions = append(functions, Call("alias", query.GroupOf("")))
+		return functions, true, true
+	case monitoring.GROUP_BY_NODE:
+		// The node is the second node of the series names unless within a parent
+		if query.ParentName == "" && aggregation.Function != monitoring.AGGREGATE_PERCENTILE {
+			functions = append(functions, Call("groupByNode", 1, combineFunctions[aggregation.Function]))
+			return functions, true, true
+		}
+	}
+	return functions, true, false
+}
+
+func (tsdbm GraphiteManager) QueryDB(query monitoring.MetricQuery) ([]monitoring.Series, error) {
+	if err := query.Valid(); err != nil {
+		return nil, err
+	}
+	params := query.Params()
+	var downsample, group bool
+	if query.Aggregation != nil {
+		functions, nativeStep, nativeGroup := graphiteFunctions(query)
+		params["functions"] = functions
+		downsample, group = !nativeStep, !nativeGroup
+	}
+	metrics, err := queryMetrics(params)
+	if err != nil {
+		return nil, err
+	}
+	series := make([]monitoring.Series, len(metrics))
+	for metricIndex, metric := range metrics {
+		series[metricIndex] = monitoring.Series{Name: metric.Target, DataPoints: make([]monitoring.DataPoint, 0, len(metric.Stats))}
+		for _, statVar := range metric.Stats {
+			if len(statVar) != 2 {
+				continue
+			}
+			var dataPoint monitoring.DataPoint
+			switch value := statVar[0].(type) {
+			case float64:
+				dataPoint.Value = value
+			case string:
+				// The latest value is passed on as a string by cactiStyle
+				if fVal, err := strconv.ParseFloat(value, 64); err == nil {
+					dataPoint.Value = fVal
+				} else {
+					dataPoint.Value = math.NaN()
+				}
+			default:
+				continue
+			}
+			switch timestamp := statVar[1].(type) {
+			case float64:
+				dataPoint.Timestamp = int64(timestamp)
+			case int:
+				dataPoint.Timestamp = int64(timestamp)
+			}
+			series[metricIndex].DataPoints = append(series[metricIndex].DataPoints, dataPoint)
+		}
+	}
+	return query.AggregateSeries(series, downsample, group)
+}
+
+func queryMetrics(params map[string]interface{}) ([]GraphiteMetric, error) {
 	err := ValidateRequest(params)
 	if err != nil {
 		return nil, err
@@ -372,21 +486,17 @@ func (tsdbm GraphiteManager) QueryDB(params map[string]interface{}) (interface{}
 }
 
 func (tsdbm GraphiteManager) GetInstantValue(node string, resource_name string) (float64, error) {
-	node = strings.Replace(node, ".", "_", -1)
+	node = monitoring.EntityName(node)
 	paramsToQuery := map[string]interface{}{"nodename": node, "resource": resource_name, "interval": monitoring.Latest}
-	mStats, memoryStatsFetchError := tsdbm.QueryDB(paramsToQuery)
+	metrics, memoryStatsFetchError := queryMetrics(paramsToQuery)
 	if memoryStatsFetchError != nil {
 		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, memoryStatsFetchError)
 	}
-	metrics, ok := mStats.([]GraphiteMetric)
-	if !ok || len(metrics) != 1 {
+	if len(metrics) != 1 {
 		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
 	}
 	statistics := []stat(metrics[0].Stats)
 	timeStampValueArr := []interface{}(statistics[0])
-	if !ok {
-		return 0.0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
-	}
 	value, ok := timeStampValueArr[0].(string)
 	if !ok {
 		return 0.0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
@@ -412,14 +522,13 @@ func isSeriesException(seriesName string, exceptionResources []string, node stri
 
 func (tsdbm GraphiteManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
 	var err_str string
-	node = strings.Replace(node, ".", "_", -1)
+	node = monitoring.EntityName(node)
 	paramsToQuery := map[string]interface{}{"nodename": node, "resource": resource_name, "interval": monitoring.Latest}
-	mStats, mStatsFetchError := tsdbm.QueryDB(paramsToQuery)
+	metrics, mStatsFetchError := queryMetrics(paramsToQuery)
 	if mStatsFetchError != nil {
 		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, mStatsFetchError), true
 	}
-	metrics, ok := mStats.([]GraphiteMetric)
-	if !ok || len(metrics) == 0 {
+	if len(metrics) == 0 {
 		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node), true
 	}
 	metric_cnt := len(metrics)
@@ -427,12 +536,6 @@ func (tsdbm GraphiteManager) GetInstantValuesAggregation(node string, resource_n
 		if !isSeriesException(metric.Target, exceptionResources, node) {
 			statistics := []stat(metric.Stats)
 			timeStampValueArr := []interface{}(statistics[0])
-			if !ok {
-				err_str = err_str + fmt.Sprintf("Failed to get the instant stat of %v from %v of %v\n", resource_name, metric.Target, node)
-				// Decrement metric_cnt for average as the current node is not contributing.
-				metric_cnt = metric_cnt - 1
-				continue
-			}
 			value, ok := timeStampValueArr[0].(string)
 			if !ok {
 				err_str = err_str + fmt.Sprintf("Failed to get the instant stat of %v from %v of %v\n", resource_name, metric.Target, node)
@@ -455,31 +558,35 @@ func (tsdbm GraphiteManager) GetInstantValuesAggregation(node string, resource_n
 			metric_cnt = metric_cnt - 1
 		}
 	}
+	if metric_cnt == 0 {
+		if err_str == "" {
+			err_str = fmt.Sprintf("No series of %v of %v left to aggregate", resource_name, node)
+		}
+		return 0, fmt.Errorf("%v", strings.TrimSpace(err_str)), true
+	}
 	if err_str != "" {
-		return aggregatedValue / float64(metric_cnt), fmt.Errorf("%v", strings.TrimSpace(err_str)), metric_cnt == 0
+		return aggregatedValue / float64(metric_cnt), fmt.Errorf("%v", strings.TrimSpace(err_str)), false
 	}
-	return aggregatedValue / float64(metric_cnt), nil, metric_cnt == 0
+	return aggregatedValue / float64(metric_cnt), nil, false
 }
 
 //This method takes map[string]map[string]string ==> map[metric/table name]map[timestamp]value
 func (tsdbm GraphiteManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) error {
-	data := make([]graphite.Metric, 1)
-	for tableName, valueMap := range metrics {
-		for timestamp, value := range valueMap {
-			timeInt, err := strconv.ParseInt(timestamp, 10, 64)
-			if err != nil {
-				return fmt.Errorf("Failed to parse timestamp %v of metric tableName %v.Error: %v", timestamp, tableName, err.Error())
-			}
-			data = append(data, graphite.Metric{Name: tableName, Value: value, Timestamp: timeInt})
-		}
-	}
-	Graphite, err := graphite.NewGraphite(hostName, port)
+	samples, err := monitoring.SamplesFromMetrics(metrics)
 	if err != nil {
-		return fmt.Errorf("%v", err)
+		return err
 	}
-	Graphite.SendMetrics(data)
-	if err := Graphite.Disconnect(); err != nil {
-		logger.Get().Warning("Failed to disconnect the graphite conn.Error %v", err)
+	if len(samples) == 0 {
+		return nil
 	}
-	return nil
+	writer := NewGraphiteWriter(hostName, port)
+	if err := writer.Connect(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := writer.Close(); err != nil {
+			logger.Get().Warning("Failed to disconnect the graphite conn.Error %v", err)
+		}
+	}()
+	return writer.Write(samples)
 }
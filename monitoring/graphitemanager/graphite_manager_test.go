@@ -0,0 +1,37 @@
+package graphitemanager
+
+import (
+	"github.com/skyrings/skyring-common/monitoring"
+	"testing"
+)
+
+func TestNewGraphiteManagerNamingScheme(t *testing.T) {
+	defer monitoring.SetNamingScheme(monitoring.DefaultNamingScheme)
+	tests := []struct {
+		name  string
+		rule  monitoring.RelabelRule
+		fails bool
+	}{
+		{
+			name: "rename rule",
+			rule: monitoring.RelabelRule{Action: monitoring.RELABEL_RENAME, Regex: "cpu", Replacement: "processor"},
+		},
+		{
+			name:  "tag rule",
+			rule:  monitoring.RelabelRule{Action: monitoring.RELABEL_TAG, Regex: "disk-(.*)", Replacement: "$1", Tag: "disk"},
+			fails: true,
+		},
+	}
+	for _, test := range tests {
+		if err := monitoring.SetNamingScheme(monitoring.NamingScheme{Rules: []monitoring.RelabelRule{test.rule}}); err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		_, err := NewGraphiteManager(nil)
+		if test.fails && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
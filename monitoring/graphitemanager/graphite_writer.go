@@ -0,0 +1,72 @@
+package graphitemanager
+
+import (
+	"fmt"
+	"github.com/marpaia/graphite-golang"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"strconv"
+	"time"
+)
+
+// GraphiteWriter writes samples to carbon over a persistent connection.
+type GraphiteWriter struct {
+	hostName string
+	port     int
+	conn     *graphite.Graphite
+}
+
+func NewGraphiteWriter(hostName string, port int) *GraphiteWriter {
+	return &GraphiteWriter{hostName: hostName, port: port}
+}
+
+func (gw *GraphiteWriter) Connect() error {
+	if gw.conn != nil {
+		return nil
+	}
+	conn, err := graphite.NewGraphite(gw.hostName, gw.port)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to graphite at %s:%d.Error: %v", gw.hostName, gw.port, err)
+	}
+	gw.conn = conn
+	return nil
+}
+
+// Write sends the samples relabeled as per the naming scheme. The tags are
+// left out, graphite being queried by the plain paths, and the graphite
+// manager refuses the schemes having tag rules.
+func (gw *GraphiteWriter) Write(samples []monitoring.Sample) (err error) {
+	defer monitoring.ObservePush(TimeSeriesDBManagerName, len(samples), time.Now(), &err)
+	if gw.conn == nil {
+		return fmt.Errorf("Not connected to graphite at %s:%d", gw.hostName, gw.port)
+	}
+	data := make([]graphite.Metric, 0, len(samples))
+	for _, sample := range samples {
+		name, ok, err := monitoring.RelabelSeriesName(sample.Name)
+		if err != nil {
+			logger.Get().Warning("Skipping the metric %s. Error: %v", sample.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		data = append(data, graphite.Metric{Name: name.Path(), Value: strconv.FormatFloat(sample.Value, 'f', -1, 64), Timestamp: sample.Timestamp})
+	}
+	if err := gw.conn.SendMetrics(data); err != nil {
+		return fmt.Errorf("Failed to send %d metrics to graphite at %s:%d.Error: %v", len(data), gw.hostName, gw.port, err)
+	}
+	return nil
+}
+
+func (gw *GraphiteWriter) Close() error {
+	if gw.conn == nil {
+		return nil
+	}
+	err := gw.conn.Disconnect()
+	gw.conn = nil
+	return err
+}
+
+func (tsdbm GraphiteManager) NewSampleWriter(hostName string, port int) (monitoring.SampleWriter, error) {
+	return NewGraphiteWriter(hostName, port), nil
+}
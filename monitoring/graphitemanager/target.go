@@ -0,0 +1,216 @@
+package graphitemanager
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/monitoring"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// The characters collectd does not escape in the names of the nodes.
+	// Wildcards are kept so that all the nodes can be queried.
+	invalidNodeNameChars = regexp.MustCompile(`[^A-Za-z0-9_*-]`)
+	// The resources may name a series fully and carry graphite wildcards
+	// but nothing breaking out of the path into the target expression.
+	validResource     = regexp.MustCompile(`^[A-Za-z0-9_*?:-]+(\.[A-Za-z0-9_*?:-]+)*$`)
+	validFunctionName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+// Function is a graphite function applied to a target. The target is the
+// first argument of the function and Args follow it.
+type Function struct {
+	Name string
+	Args []interface{}
+}
+
+func Call(name string, args ...interface{}) Function {
+	return Function{Name: name, Args: args}
+}
+
+// Target is a graphite target: the series of the resource of the nodes,
+// within the parent if any, with the functions applied in order.
+type Target struct {
+	Collection string
+	Parent     string
+	Nodes      []string
+	Resource   string
+	// FullyQualified tells the resource names the series fully instead of
+	// being a prefix of the series
+	FullyQualified bool
+	Functions      []Function
+}
+
+// escapeNodeName escapes the name of a node or a parent the way collectd
+// does before sending the values.
+func escapeNodeName(name string) string {
+	return invalidNodeNameChars.ReplaceAllString(name, "_")
+}
+
+// quoteArg quotes a string argument of a function.
+func quoteArg(arg string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(arg) + "'"
+}
+
+func formatArg(arg interface{}) (string, error) {
+	switch value := arg.(type) {
+	case string:
+		return quoteArg(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", fmt.Errorf("Unsupported argument %v of type %T", arg, arg)
+}
+
+// alternatives returns the graphite pattern matching any of the values.
+func alternatives(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// joinPaths returns the pattern matching the paths of all the names,
+// keeping the alternatives to the part the names differ in if they differ
+// in just one.
+func joinPaths(names []monitoring.MetricName) string {
+	var collections, entities, resources, paths []string
+	for _, name := range names {
+		collections = append(collections, name.Collection)
+		entities = append(entities, name.Entity)
+		resources = append(resources, name.Resource)
+		paths = append(paths, name.Path())
+	}
+	same := func(values []string) bool {
+		for _, value := range values {
+			if value != values[0] {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case same(collections) && same(entities):
+		return collections[0] + "." + entities[0] + "." + alternatives(resources)
+	case same(collections) && same(resources):
+		return collections[0] + "." + alternatives(entities) + "." + resources[0]
+	}
+	return alternatives(paths)
+}
+
+// Path returns the series path of the target, the names of the series of
+// the nodes being relabeled as per the naming scheme.
+func (t Target) Path() (string, error) {
+	if len(t.Nodes) == 0 {
+		return "", fmt.Errorf("Node not specified")
+	}
+	if !validResource.MatchString(t.Resource) {
+		return "", fmt.Errorf("Invalid resource %s", t.Resource)
+	}
+	collection := escapeNodeName(t.Collection)
+	if collection == "" {
+		return "", fmt.Errorf("Collection not specified")
+	}
+	var parent string
+	if t.Parent != "" {
+		parent = escapeNodeName(monitoring.EntityName(t.Parent))
+	}
+	var names []monitoring.MetricName
+	for _, node := range t.Nodes {
+		node = escapeNodeName(monitoring.EntityName(node))
+		if node == "" {
+			return "", fmt.Errorf("Empty node name")
+		}
+		name, ok := monitoring.QueryName(collection, parent, node, t.Resource)
+		if !ok {
+			continue
+		}
+		// The rules may not break out of the path either
+		name.Collection = escapeNodeName(name.Collection)
+		name.Entity = escapeNodeName(name.Entity)
+		if name.Collection == "" || name.Entity == "" || !validResource.MatchString(name.Resource) {
+			return "", fmt.Errorf("Invalid series name %s of node %s", name.Path(), node)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("Series of the nodes %v dropped by the naming scheme", t.Nodes)
+	}
+	path := joinPaths(names)
+	if !t.FullyQualified {
+		path = path + "*.*"
+	}
+	return path, nil
+}
+
+// Build returns the target expression.
+func (t Target) Build() (string, error) {
+	target, err := t.Path()
+	if err != nil {
+		return "", err
+	}
+	for _, function := range t.Functions {
+		if !validFunctionName.MatchString(function.Name) {
+			return "", fmt.Errorf("Invalid function %s", function.Name)
+		}
+		args := []string{target}
+		for _, arg := range function.Args {
+			formatted, err := formatArg(arg)
+			if err != nil {
+				return "", fmt.Errorf("Invalid argument of function %s. Error: %v", function.Name, err)
+			}
+			args = append(args, formatted)
+		}
+		target = function.Name + "(" + strings.Join(args, ",") + ")"
+	}
+	return target, nil
+}
+
+// RenderRequest is a request to the render API of graphite for the values
+// of the targets between From and Until, which are formatted as accepted by
+// graphite.
+type RenderRequest struct {
+	Hostname string
+	Port     int
+	Targets  []Target
+	From     string
+	Until    string
+}
+
+// URL returns the URL of the request with all the values escaped.
+func (r RenderRequest) URL() (string, error) {
+	if len(r.Targets) == 0 {
+		return "", fmt.Errorf("Target not specified")
+	}
+	values := url.Values{}
+	for _, target := range r.Targets {
+		expr, err := target.Build()
+		if err != nil {
+			return "", err
+		}
+		values.Add("target", expr)
+	}
+	values.Set("format", "json")
+	if r.From != "" {
+		values.Set("from", r.From)
+	}
+	if r.Until != "" {
+		values.Set("until", r.Until)
+	}
+	renderUrl := url.URL{
+		Scheme:   "http",
+		Host:     net.JoinHostPort(r.Hostname, strconv.Itoa(r.Port)),
+		Path:     "/render",
+		RawQuery: values.Encode(),
+	}
+	return renderUrl.String(), nil
+}
@@ -0,0 +1,245 @@
+package graphitemanager
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestTargetPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		path   string
+		fails  bool
+	}{
+		{
+			name:   "node with dots",
+			target: Target{Collection: "collectd", Nodes: []string{"node1.example.com"}, Resource: "cpu"},
+			path:   "collectd.node1_example_com.cpu*.*",
+		},
+		{
+			name:   "node breaking out of the path",
+			target: Target{Collection: "collectd", Nodes: []string{"node1),sumSeries(x"}, Resource: "cpu"},
+			path:   "collectd.node1__sumSeries_x.cpu*.*",
+		},
+		{
+			name:   "node with quotes and spaces",
+			target: Target{Collection: "collectd", Nodes: []string{"it's node 1"}, Resource: "memory"},
+			path:   "collectd.it_s_node_1.memory*.*",
+		},
+		{
+			name:   "wildcard node",
+			target: Target{Collection: "collectd", Nodes: []string{"*"}, Resource: "cpu"},
+			path:   "collectd.*.cpu*.*",
+		},
+		{
+			name:   "collection escaped",
+			target: Target{Collection: "col{lectd}", Nodes: []string{"node1"}, Resource: "cpu"},
+			path:   "col_lectd_.node1.cpu*.*",
+		},
+		{
+			name:   "fully qualified resource",
+			target: Target{Collection: "collectd", Nodes: []string{"node1"}, Resource: "cpu.percent-user", FullyQualified: true},
+			path:   "collectd.node1.cpu.percent-user",
+		},
+		{
+			name:   "resource breaking out of the path",
+			target: Target{Collection: "collectd", Nodes: []string{"node1"}, Resource: "cpu),sumSeries(x"},
+			fails:  true,
+		},
+		{
+			name:   "resource with an empty part",
+			target: Target{Collection: "collectd", Nodes: []string{"node1"}, Resource: "cpu..user"},
+			fails:  true,
+		},
+		{
+			name:   "parent",
+			target: Target{Collection: "collectd", Parent: "cluster.one", Nodes: []string{"osd.0"}, Resource: "slu_utilization"},
+			path:   "collectd.cluster_one.slu_utilization_osd_0*.*",
+		},
+		{
+			name:   "parent breaking out of the path",
+			target: Target{Collection: "collectd", Parent: "my cluster,x)", Nodes: []string{"osd.0"}, Resource: "slu_utilization"},
+			path:   "collectd.my_cluster_x_.slu_utilization_osd_0*.*",
+		},
+		{
+			name:   "nodes",
+			target: Target{Collection: "collectd", Nodes: []string{"node1", "node2.example.com"}, Resource: "cpu"},
+			path:   "collectd.{node1,node2_example_com}.cpu*.*",
+		},
+		{
+			name:   "nodes of a parent",
+			target: Target{Collection: "collectd", Parent: "cluster1", Nodes: []string{"osd.0", "osd.1"}, Resource: "slu_utilization"},
+			path:   "collectd.cluster1.{slu_utilization_osd_0,slu_utilization_osd_1}*.*",
+		},
+		{
+			name:   "no node",
+			target: Target{Collection: "collectd", Resource: "cpu"},
+			fails:  true,
+		},
+		{
+			name:   "empty node",
+			target: Target{Collection: "collectd", Nodes: []string{"node1", ""}, Resource: "cpu"},
+			fails:  true,
+		},
+		{
+			name:   "no collection",
+			target: Target{Nodes: []string{"node1"}, Resource: "cpu"},
+			fails:  true,
+		},
+	}
+	for _, test := range tests {
+		path, err := test.target.Path()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got the path %s", test.name, path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if path != test.path {
+			t.Errorf("%s: expected the path %s, got %s", test.name, test.path, path)
+		}
+	}
+}
+
+func TestTargetBuild(t *testing.T) {
+	node1 := Target{Collection: "collectd", Nodes: []string{"node1"}, Resource: "cpu"}
+	withFunctions := func(functions ...Function) Target {
+		target := node1
+		target.Functions = functions
+		return target
+	}
+	tests := []struct {
+		name   string
+		target Target
+		expr   string
+		fails  bool
+	}{
+		{
+			name:   "no function",
+			target: node1,
+			expr:   "collectd.node1.cpu*.*",
+		},
+		{
+			name:   "functions chained in order",
+			target: withFunctions(Call("averageSeries"), Call("movingAverage", 5), Call("scale", 0.5), Call("alias", "avg")),
+			expr:   "alias(scale(movingAverage(averageSeries(collectd.node1.cpu*.*),5),0.5),'avg')",
+		},
+		{
+			name:   "argument kinds",
+			target: withFunctions(Call("f", int64(-3), true, 1e-7)),
+			expr:   "f(collectd.node1.cpu*.*,-3,true,0.0000001)",
+		},
+		{
+			name:   "quoted arguments escaped",
+			target: withFunctions(Call("alias", `it's a \ test')`)),
+			expr:   `alias(collectd.node1.cpu*.*,'it\'s a \\ test\')')`,
+		},
+		{
+			name:   "function name breaking out of the expression",
+			target: withFunctions(Call("alias),sumSeries(")),
+			fails:  true,
+		},
+		{
+			name:   "unsupported argument",
+			target: withFunctions(Call("f", []int{1})),
+			fails:  true,
+		},
+		{
+			name:   "invalid path",
+			target: Target{Collection: "collectd", Resource: "cpu", Functions: []Function{Call("averageSeries")}},
+			fails:  true,
+		},
+	}
+	for _, test := range tests {
+		expr, err := test.target.Build()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got the target %s", test.name, expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if expr != test.expr {
+			t.Errorf("%s: expected the target %s, got %s", test.name, test.expr, expr)
+		}
+	}
+}
+
+func TestRenderRequestURL(t *testing.T) {
+	cpu := Target{Collection: "collectd", Nodes: []string{"node1"}, Resource: "cpu", Functions: []Function{Call("alias", "a&b=c")}}
+	memory := Target{Collection: "collectd", Parent: "cluster1", Nodes: []string{"node1", "node2"}, Resource: "memory"}
+	tests := []struct {
+		name    string
+		request RenderRequest
+		targets []string
+		from    string
+		until   string
+		fails   bool
+	}{
+		{
+			name:    "single target",
+			request: RenderRequest{Hostname: "localhost", Port: 8080, Targets: []Target{cpu}, From: "-1h"},
+			targets: []string{"alias(collectd.node1.cpu*.*,'a&b=c')"},
+			from:    "-1h",
+		},
+		{
+			name:    "targets",
+			request: RenderRequest{Hostname: "localhost", Port: 8080, Targets: []Target{cpu, memory}, From: "-1d", Until: "now"},
+			targets: []string{"alias(collectd.node1.cpu*.*,'a&b=c')", "collectd.cluster1.{memory_node1,memory_node2}*.*"},
+			from:    "-1d",
+			until:   "now",
+		},
+		{
+			name:    "no target",
+			request: RenderRequest{Hostname: "localhost", Port: 8080},
+			fails:   true,
+		},
+		{
+			name:    "invalid target",
+			request: RenderRequest{Hostname: "localhost", Port: 8080, Targets: []Target{cpu, {Collection: "collectd", Resource: "cpu"}}},
+			fails:   true,
+		},
+	}
+	for _, test := range tests {
+		renderUrl, err := test.request.URL()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got the url %s", test.name, renderUrl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		parsed, err := url.Parse(renderUrl)
+		if err != nil {
+			t.Errorf("%s: invalid url %s. Error: %v", test.name, renderUrl, err)
+			continue
+		}
+		if parsed.Host != "localhost:8080" || parsed.Path != "/render" {
+			t.Errorf("%s: unexpected url %s", test.name, renderUrl)
+		}
+		values := parsed.Query()
+		if len(values["target"]) != len(test.targets) {
+			t.Errorf("%s: expected the targets %v, got %v", test.name, test.targets, values["target"])
+			continue
+		}
+		for index, target := range test.targets {
+			if values["target"][index] != target {
+				t.Errorf("%s: expected the target %s, got %s", test.name, target, values["target"][index])
+			}
+		}
+		if values.Get("format") != "json" || values.Get("from") != test.from || values.Get("until") != test.until {
+			t.Errorf("%s: unexpected parameters %v", test.name, values)
+		}
+	}
+}
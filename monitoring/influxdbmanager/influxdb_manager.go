@@ -1,20 +1,29 @@
 package influxdbmanager
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	influxdb "github.com/influxdb/influxdb/client"
 	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/db"
 	"github.com/skyrings/skyring-common/monitoring"
-	"github.com/skyrings/skyring-common/utils"
+	"github.com/skyrings/skyring-common/tools/logger"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
 const (
 	TimeSeriesDBManagerName = "InfluxdbManager"
+	// Field holding the value of the points written by collectd
+	valueField = "value"
 )
 
 type InfluxdbManager struct {
@@ -30,92 +39,378 @@ func NewInfluxdbManager(config io.Reader) (*InfluxdbManager, error) {
 	return &InfluxdbManager{}, nil
 }
 
+var SupportedInputTimeFormats = []string{
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02",
+	"20060102",
+}
+
 func queryDB(cmd string) (res []influxdb.Result, err error) {
+	client := db.GetMonitoringDBClient()
+	if client == nil {
+		return nil, fmt.Errorf("Monitoring db client not initialized")
+	}
 	q := influxdb.Query{
 		Command:  cmd,
 		Database: conf.SystemConfig.TimeSeriesDBConfig.CollectionName,
 	}
 
-	if response, err := db.GetMonitoringDBClient().Query(q); err == nil {
-		if response.Error() != nil {
-			return res, response.Error()
-		}
-		res = response.Results
+	response, err := client.Query(q)
+	if err != nil {
+		return res, err
+	}
+	if response.Error() != nil {
+		return res, response.Error()
 	}
-	return
+	return response.Results, nil
 }
 
 func (idm InfluxdbManager) GetResourceName(params map[string]interface{}) (string, error) {
-	return "", nil
+	resource_name, ok := params["resource_name"].(string)
+	if !ok {
+		return "", fmt.Errorf("Resource %v not found", params["resource_name"])
+	}
+	buf := new(bytes.Buffer)
+	parsedTemplate, err := template.New("influxdb_resource_name").Parse(monitoring.ResourceCollectionName(resource_name))
+	if err != nil {
+		return "", err
+	}
+	if err := parsedTemplate.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 func (idm InfluxdbManager) QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error {
+	query, err := url.ParseQuery(urlStr)
+	if err != nil {
+		return fmt.Errorf("Invalid query %s. Error: %v", urlStr, err)
+	}
+	if query.Get("db") == "" {
+		query.Set("db", conf.SystemConfig.TimeSeriesDBConfig.CollectionName)
+	}
+	url := fmt.Sprintf("http://%s:%s/query?%s", conf.SystemConfig.TimeSeriesDBConfig.Hostname, strconv.Itoa(conf.SystemConfig.TimeSeriesDBConfig.Port), query.Encode())
+	http.Redirect(w, r, url, http.StatusFound)
 	return nil
 }
 
-func (idm InfluxdbManager) QueryDB(params map[string]interface{}) (interface{}, error) {
-	var resource_name string
-	var nodename string
-	var startTime string
-	var endTime string
-	var interval string
-	var err error
+// seriesRegex translates the collectd series names with graphite style
+// wildcards into a regular expression matching the measurements.
+func seriesRegex(names []string, fullQualifiedMetricName bool) string {
+	exprs := make([]string, len(names))
+	for index, name := range names {
+		exprs[index] = strings.Replace(regexp.QuoteMeta(name), `\*`, `[^.]*`, -1)
+		if !fullQualifiedMetricName {
+			exprs[index] = exprs[index] + `[^.]*\.[^.]*`
+		}
+	}
+	// Slashes delimit the regular expression in InfluxQL
+	return "/^(" + strings.Replace(strings.Join(exprs, "|"), "/", `\/`, -1) + ")$/"
+}
 
-	if resource_name, err = util.GetString(params["resource"]); err != nil {
-		return nil, err
+func getSeriesRegex(query monitoring.MetricQuery) (string, error) {
+	matched, fullQualifiedMetricName := monitoring.MatchResource(query.Resource)
+	if !matched {
+		return "", fmt.Errorf("%v is an unsupported Resource", query.Resource)
 	}
-	if nodename, err = util.GetString(params["nodename"]); err != nil {
-		return nil, err
+	var names []string
+	for _, name := range query.SeriesNames() {
+		names = append(names, name.Path())
 	}
-	if startTime, err = util.GetString(params["start_time"]); err != nil {
-		return nil, err
+	return seriesRegex(names, fullQualifiedMetricName), nil
+}
+
+func formatTime(timeString string) (string, error) {
+	for _, currentFormat := range SupportedInputTimeFormats {
+		if parsedTime, err := time.Parse(currentFormat, timeString); err == nil {
+			return "'" + parsedTime.UTC().Format(time.RFC3339Nano) + "'", nil
+		}
+	}
+	if duration, err := formatDuration(timeString); err == nil {
+		return "now() - " + duration, nil
 	}
-	if endTime, err = util.GetString(params["end_time"]); err != nil {
+	return "", fmt.Errorf("The time %v is of unsupported type", timeString)
+}
+
+// formatDuration accepts both the influxdb durations like 10m and the
+// graphite style relative times like -10min.
+func formatDuration(interval string) (string, error) {
+	duration := strings.Replace(strings.TrimPrefix(interval, "-"), "min", "m", 1)
+	if matched, _ := regexp.Match("^([0-5]?[0-9])?s$|^([0-5]?[0-9])?m$|^([0-2]?[0-9])?h$|^([0-9])*d$|^([0-9])*w$", []byte(duration)); !matched {
+		return "", fmt.Errorf("Invalid duration passed: %s", interval)
+	}
+	return duration, nil
+}
+
+var selectors = map[string]string{
+	monitoring.AGGREGATE_AVG: "mean",
+	monitoring.AGGREGATE_MIN: "min",
+	monitoring.AGGREGATE_MAX: "max",
+	monitoring.AGGREGATE_SUM: "sum",
+}
+
+// aggregationSelector returns the selector of the values downsampled as per
+// the aggregation.
+func aggregationSelector(aggregation monitoring.Aggregation) string {
+	if aggregation.Function == monitoring.AGGREGATE_PERCENTILE {
+		return fmt.Sprintf("percentile(%s, %v)", valueField, aggregation.Percentile)
+	}
+	return fmt.Sprintf("%s(%s)", selectors[aggregation.Function], valueField)
+}
+
+// QueryDB downsamples the series using GROUP BY time(). The series are
+// grouped in process as a query yields a series per measurement and set of
+// tags, named after both.
+func (idm InfluxdbManager) QueryDB(query monitoring.MetricQuery) ([]monitoring.Series, error) {
+	if err := query.Valid(); err != nil {
 		return nil, err
 	}
-	if interval, err = util.GetString(params["interval"]); err != nil {
+	series, err := getSeriesRegex(query)
+	if err != nil {
 		return nil, err
 	}
+	if query.IsLatest() {
+		latest, err := querySeries(fmt.Sprintf("SELECT last(%s) FROM %s GROUP BY *", valueField, series))
+		if err != nil {
+			return nil, err
+		}
+		return query.AggregateSeries(latest, false, true)
+	}
+
+	var conditions []string
+	if query.StartTime != "" {
+		validatedStartTime, err := formatTime(query.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "time > "+validatedStartTime)
+	}
+	if query.EndTime != "" {
+		validatedEndTime, err := formatTime(query.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "time < "+validatedEndTime)
+	}
+	if query.Interval != "" {
+		if len(conditions) != 0 {
+			return nil, fmt.Errorf("Unsupported combination of start time %v, end time %v and interval %v", query.StartTime, query.EndTime, query.Interval)
+		}
+		duration, err := formatDuration(query.Interval)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "time > now() - "+duration)
+	}
 
-	var query_cmd string
-	if resource_name != "" {
-		query_cmd = fmt.Sprintf("SELECT * FROM /(%s.%s).*/", nodename, resource_name)
-	} else {
-		query_cmd = fmt.Sprintf("SELECT * FROM /(%s).*/", nodename)
+	selector := valueField
+	// A series per set of tags
+	groupBy := " GROUP BY *"
+	if query.Aggregation != nil && query.Aggregation.Step != "" {
+		step, _ := query.Aggregation.StepDuration()
+		selector = aggregationSelector(*query.Aggregation)
+		groupBy = fmt.Sprintf(" GROUP BY time(%ds), *", int64(step.Seconds()))
+		// Downsampling needs the start of the time range
+		if query.StartTime == "" && query.Interval == "" {
+			conditions = append(conditions, "time > now() - 1d")
+		}
+	}
+	query_cmd := fmt.Sprintf("SELECT %s FROM %s", selector, series)
+	if len(conditions) != 0 {
+		query_cmd += " WHERE " + strings.Join(conditions, " AND ")
 	}
+	result, err := querySeries(query_cmd + groupBy)
+	if err != nil {
+		return nil, err
+	}
+	return query.AggregateSeries(result, false, true)
+}
 
-	if startTime != "" && endTime != "" {
-		if _, err := time.Parse("2006-01-02T15:04:05.000Z", startTime); err != nil {
-			return nil, fmt.Errorf("Error parsing start time: %s", startTime)
+// querySeries runs the query and converts the rows of the results into
+// series. Points without a numeric value are left out.
+func querySeries(cmd string) ([]monitoring.Series, error) {
+	res, err := queryDB(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var series []monitoring.Series
+	for _, result := range res {
+		if result.Err != nil {
+			return nil, result.Err
 		}
-		if _, err := time.Parse("2006-01-02T15:04:05.000Z", endTime); err != nil {
-			return nil, fmt.Errorf("Error parsing end time: %s", endTime)
+		for _, row := range result.Series {
+			currentSeries := monitoring.Series{Name: monitoring.TaggedName(row.Name, row.Tags), DataPoints: make([]monitoring.DataPoint, 0, len(row.Values))}
+			for _, values := range row.Values {
+				if len(values) < 2 {
+					continue
+				}
+				value, err := toFloat(values[1])
+				if err != nil {
+					continue
+				}
+				timestamp, err := toTimestamp(values[0])
+				if err != nil {
+					return nil, err
+				}
+				currentSeries.DataPoints = append(currentSeries.DataPoints, monitoring.DataPoint{Timestamp: timestamp, Value: value})
+			}
+			series = append(series, currentSeries)
 		}
-		query_cmd += " WHERE time > '" + startTime + "' and time < '" + endTime + "'"
 	}
+	return series, nil
+}
 
-	if interval != "" {
-		if matched, _ := regexp.Match("^([0-5]?[0-9])?s$|^([0-5]?[0-9])?m$|^([0-2]?[0-3])?h$|^([0-9])*d$|^([0-9])*w$", []byte(interval)); !matched {
-			return nil, fmt.Errorf("Invalid duration passed: %s", interval)
+func toTimestamp(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case string:
+		parsedTime, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid time %v. Error: %v", v, err)
 		}
-		query_cmd += " WHERE time > now() - " + interval
+		return parsedTime.Unix(), nil
+	case json.Number:
+		return v.Int64()
+	case float64:
+		return int64(v), nil
 	}
+	return 0, fmt.Errorf("Invalid time %v", value)
+}
 
-	res, err := queryDB(query_cmd)
-	return res, err
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0.0, fmt.Errorf("%v is not a number", value)
 }
 
 func (idm InfluxdbManager) GetInstantValue(node string, resource_name string) (float64, error) {
-	return 0.0, nil
+	node = monitoring.EntityName(node)
+	series, err := idm.QueryDB(monitoring.MetricQuery{NodeName: node, Resource: resource_name, Interval: monitoring.Latest})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, err)
+	}
+	if len(series) != 1 || len(series[0].DataPoints) == 0 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
+	}
+	fVal := series[0].DataPoints[0].Value
+	if math.IsNaN(fVal) {
+		return 0.0, fmt.Errorf("The value %v from resource %v of %v is not a number", fVal, resource_name, node)
+	}
+	return fVal, nil
 }
 
-func (idm InfluxdbManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) error {
-	/*
-		TODO Implement
-	*/
-	return nil
+func isSeriesException(seriesName string, exceptionResources []string, node string) bool {
+	for _, eResource := range exceptionResources {
+		if strings.HasPrefix(seriesName, conf.SystemConfig.TimeSeriesDBConfig.CollectionName+"."+node+"."+eResource+".") {
+			return true
+		}
+	}
+	return false
 }
 
 func (idm InfluxdbManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
-	return 0.0, nil, false
+	var err_str string
+	node = monitoring.EntityName(node)
+	series, mStatsFetchError := idm.QueryDB(monitoring.MetricQuery{NodeName: node, Resource: resource_name, Interval: monitoring.Latest})
+	if mStatsFetchError != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, mStatsFetchError), true
+	}
+	if len(series) == 0 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node), true
+	}
+	metric_cnt := len(series)
+	for _, currentSeries := range series {
+		if isSeriesException(currentSeries.Name, exceptionResources, node) {
+			//The series is in exception list and need not be counted for averaging out.
+			metric_cnt = metric_cnt - 1
+			continue
+		}
+		if len(currentSeries.DataPoints) == 0 {
+			err_str = err_str + fmt.Sprintf("Failed to get the instant stat of %v from %v of %v\n", resource_name, currentSeries.Name, node)
+			// Decrement metric_cnt for average as the current node is not contributing.
+			metric_cnt = metric_cnt - 1
+			continue
+		}
+		if val := currentSeries.DataPoints[0].Value; !math.IsNaN(val) {
+			aggregatedValue = aggregatedValue + val
+		}
+	}
+	if metric_cnt == 0 {
+		if err_str == "" {
+			err_str = fmt.Sprintf("No series of %v of %v left to aggregate", resource_name, node)
+		}
+		return 0, fmt.Errorf("%v", strings.TrimSpace(err_str)), true
+	}
+	if err_str != "" {
+		return aggregatedValue / float64(metric_cnt), fmt.Errorf("%v", strings.TrimSpace(err_str)), false
+	}
+	return aggregatedValue / float64(metric_cnt), nil, false
+}
+
+//This method takes map[string]map[string]string ==> map[metric/table name]map[timestamp]value
+// The table names are relabeled as per the naming scheme, the tags of the
+// names becoming the tags of the points.
+func (idm InfluxdbManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) (err error) {
+	defer monitoring.ObservePush(TimeSeriesDBManagerName, monitoring.SampleCount(metrics), time.Now(), &err)
+	var points []influxdb.Point
+	for tableName, valueMap := range metrics {
+		name, ok, err := monitoring.RelabelSeriesName(tableName)
+		if err != nil {
+			logger.Get().Warning("Skipping the metric %s. Error: %v", tableName, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		for timestamp, value := range valueMap {
+			timeInt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse timestamp %v of metric tableName %v.Error: %v", timestamp, tableName, err.Error())
+			}
+			fVal, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse value %v of metric tableName %v.Error: %v", value, tableName, err.Error())
+			}
+			points = append(points, influxdb.Point{
+				Measurement: name.Path(),
+				Tags:        name.Tags,
+				Fields:      map[string]interface{}{valueField: fVal},
+				Time:        time.Unix(timeInt, 0),
+				Precision:   "s",
+			})
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(fmt.Sprintf("http://%s:%d", hostName, port))
+	if err != nil {
+		return fmt.Errorf("Invalid influxdb address %s:%d.Error: %v", hostName, port, err)
+	}
+	client, err := influxdb.NewClient(influxdb.Config{
+		URL:      *u,
+		Username: conf.SystemConfig.TimeSeriesDBConfig.User,
+		Password: conf.SystemConfig.TimeSeriesDBConfig.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	response, err := client.Write(influxdb.BatchPoints{
+		Points:    points,
+		Database:  conf.SystemConfig.TimeSeriesDBConfig.CollectionName,
+		Precision: "s",
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to push %d points to influxdb.Error: %v", len(points), err)
+	}
+	if response != nil && response.Error() != nil {
+		return fmt.Errorf("Failed to push %d points to influxdb.Error: %v", len(points), response.Error())
+	}
+	return nil
 }
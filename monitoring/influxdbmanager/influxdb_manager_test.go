@@ -0,0 +1,164 @@
+package influxdbmanager
+
+import (
+	"encoding/json"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/monitoring"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// standIn is a local stand-in of influxdb answering the queries with the
+// rows given and recording the queries and the writes.
+type standIn struct {
+	server  *httptest.Server
+	rows    []map[string]interface{}
+	queries []string
+	writes  []string
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			s.queries = append(s.queries, r.URL.Query().Get("q"))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"results": []interface{}{map[string]interface{}{"series": s.rows}},
+			})
+		case "/write":
+			body, _ := ioutil.ReadAll(r.Body)
+			s.writes = append(s.writes, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	host, port := s.hostPort(t)
+	conf.SystemConfig.TimeSeriesDBConfig = conf.MonitoringDBconfig{Hostname: host, Port: port, CollectionName: "collectd"}
+	if err := db.InitMonitoringDB(conf.SystemConfig.TimeSeriesDBConfig); err != nil {
+		t.Fatalf("Failed to init the monitoring db client. Error: %v", err)
+	}
+	return s
+}
+
+func (s *standIn) hostPort(t *testing.T) (string, int) {
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(s.server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Invalid stand-in address %s. Error: %v", s.server.URL, err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+func row(name string, values ...[]interface{}) map[string]interface{} {
+	return map[string]interface{}{"name": name, "columns": []string{"time", "value"}, "values": values}
+}
+
+func TestQueryDB(t *testing.T) {
+	s := newStandIn(t)
+	defer s.server.Close()
+	s.rows = []map[string]interface{}{
+		row("collectd.node1.cpu.percent-user", []interface{}{"2016-01-01T00:00:00Z", 10}, []interface{}{"2016-01-01T00:01:00Z", 20}),
+		row("collectd.node1.cpu.percent-system", []interface{}{"2016-01-01T00:00:00Z", nil}),
+	}
+
+	series, err := InfluxdbManager{}.QueryDB(monitoring.MetricQuery{NodeName: "node1", Resource: monitoring.CPU, StartTime: "2016-01-01"})
+	if err != nil {
+		t.Fatalf("Query failed. Error: %v", err)
+	}
+	if len(s.queries) != 1 {
+		t.Fatalf("Expected a query, got %v", s.queries)
+	}
+	for _, part := range []string{"SELECT value FROM /^(collectd\\.node1\\.cpu", "time > '2016-01-01T00:00:00Z'", "GROUP BY *"} {
+		if !strings.Contains(s.queries[0], part) {
+			t.Errorf("Query %s does not contain %s", s.queries[0], part)
+		}
+	}
+	if len(series) != 2 {
+		t.Fatalf("Expected 2 series, got %v", series)
+	}
+	if series[0].Name != "collectd.node1.cpu.percent-user" || len(series[0].DataPoints) != 2 || series[0].DataPoints[1].Value != 20 || series[0].DataPoints[1].Timestamp != 1451606460 {
+		t.Errorf("Unexpected series %v", series[0])
+	}
+	// Points without a value are left out
+	if len(series[1].DataPoints) != 0 {
+		t.Errorf("Unexpected points %v", series[1].DataPoints)
+	}
+
+	if _, err := (InfluxdbManager{}).QueryDB(monitoring.MetricQuery{NodeName: "node1", Resource: "unknown"}); err == nil {
+		t.Errorf("Query of an unsupported resource succeeded")
+	}
+}
+
+func TestGetInstantValue(t *testing.T) {
+	s := newStandIn(t)
+	defer s.server.Close()
+	s.rows = []map[string]interface{}{row("collectd.node1_example_com.memory.percent-used", []interface{}{"2016-01-01T00:00:00Z", 42.5})}
+
+	value, err := InfluxdbManager{}.GetInstantValue("node1.example.com", monitoring.MEMORY)
+	if err != nil {
+		t.Fatalf("Failed to get the instant value. Error: %v", err)
+	}
+	if value != 42.5 {
+		t.Errorf("Expected 42.5, got %v", value)
+	}
+	if len(s.queries) != 1 || !strings.HasPrefix(s.queries[0], "SELECT last(value) FROM /^(collectd\\.node1_example_com\\.memory") {
+		t.Errorf("Unexpected queries %v", s.queries)
+	}
+
+	s.rows = nil
+	if _, err := (InfluxdbManager{}).GetInstantValue("node1.example.com", monitoring.MEMORY); err == nil {
+		t.Errorf("Instant value of no series succeeded")
+	}
+}
+
+func TestGetInstantValuesAggregation(t *testing.T) {
+	s := newStandIn(t)
+	defer s.server.Close()
+	s.rows = []map[string]interface{}{
+		row("collectd.node1.df-root.percent_bytes-used", []interface{}{"2016-01-01T00:00:00Z", 30}),
+		row("collectd.node1.df-boot.percent_bytes-used", []interface{}{"2016-01-01T00:00:00Z", 50}),
+	}
+
+	value, err, isCompleteFailure := InfluxdbManager{}.GetInstantValuesAggregation("node1", "df", nil)
+	if err != nil || isCompleteFailure || value != 40 {
+		t.Errorf("Expected an average of 40, got %v, %v, %v", value, err, isCompleteFailure)
+	}
+
+	// All the series excluded
+	value, err, isCompleteFailure = InfluxdbManager{}.GetInstantValuesAggregation("node1", "df", []string{"df-root", "df-boot"})
+	if err == nil || !isCompleteFailure || value != 0 {
+		t.Errorf("Expected a complete failure, got %v, %v, %v", value, err, isCompleteFailure)
+	}
+}
+
+func TestPushToDb(t *testing.T) {
+	s := newStandIn(t)
+	defer s.server.Close()
+	host, port := s.hostPort(t)
+
+	metrics := map[string]map[string]string{
+		"collectd.node1.cpu.percent-user": {"1451606400": "12.5"},
+	}
+	if err := (InfluxdbManager{}).PushToDb(metrics, host, port); err != nil {
+		t.Fatalf("Push failed. Error: %v", err)
+	}
+	if len(s.writes) != 1 || !strings.Contains(s.writes[0], "collectd.node1.cpu.percent-user") || !strings.Contains(s.writes[0], "12.5") {
+		t.Errorf("Unexpected writes %v", s.writes)
+	}
+
+	if err := (InfluxdbManager{}).PushToDb(map[string]map[string]string{"collectd.node1.cpu.percent-user": {"now": "1"}}, host, port); err == nil {
+		t.Errorf("Push of an invalid timestamp succeeded")
+	}
+	if len(s.writes) != 1 {
+		t.Errorf("Unexpected writes %v", s.writes)
+	}
+}
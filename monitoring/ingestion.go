@@ -0,0 +1,514 @@
+package monitoring
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sample is a value of a series at the unix timestamp.
+type Sample struct {
+	Name      string  `json:"name"`
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// SampleWriter writes batches of samples to a backend. The writers keep their
+// connection open across writes; the pipeline closes and connects again a
+// writer which failed to write.
+type SampleWriter interface {
+	Connect() error
+	Write(samples []Sample) error
+	Close() error
+}
+
+// SampleWriterProvider is implemented by the monitoring managers able to
+// write samples natively over a persistent connection.
+type SampleWriterProvider interface {
+	NewSampleWriter(hostName string, port int) (SampleWriter, error)
+}
+
+// SamplesFromMetrics converts the metrics in the form taken by PushToDb,
+// map[metric/table name]map[timestamp]value, into samples.
+func SamplesFromMetrics(metrics map[string]map[string]string) ([]Sample, error) {
+	var samples []Sample
+	for tableName, valueMap := range metrics {
+		for timestamp, value := range valueMap {
+			timeInt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse timestamp %v of metric tableName %v.Error: %v", timestamp, tableName, err)
+			}
+			val, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse value %v of metric tableName %v.Error: %v", value, tableName, err)
+			}
+			samples = append(samples, Sample{Name: tableName, Timestamp: timeInt, Value: val})
+		}
+	}
+	return samples, nil
+}
+
+// MetricsFromSamples converts the samples into the form taken by PushToDb.
+func MetricsFromSamples(samples []Sample) map[string]map[string]string {
+	metrics := make(map[string]map[string]string)
+	for _, sample := range samples {
+		if _, ok := metrics[sample.Name]; !ok {
+			metrics[sample.Name] = make(map[string]string)
+		}
+		metrics[sample.Name][strconv.FormatInt(sample.Timestamp, 10)] = strconv.FormatFloat(sample.Value, 'f', -1, 64)
+	}
+	return metrics
+}
+
+// ManagerWriter writes the samples through the PushToDb of a monitoring
+// manager, for the managers which are not SampleWriterProviders.
+type ManagerWriter struct {
+	Manager  MonitoringManagerInterface
+	HostName string
+	Port     int
+}
+
+func (mw ManagerWriter) Connect() error {
+	return nil
+}
+
+func (mw ManagerWriter) Write(samples []Sample) error {
+	return mw.Manager.PushToDb(MetricsFromSamples(samples), mw.HostName, mw.Port)
+}
+
+func (mw ManagerWriter) Close() error {
+	return nil
+}
+
+// NewSampleWriter returns the native writer of the manager if it provides
+// one, or a ManagerWriter otherwise.
+func NewSampleWriter(manager MonitoringManagerInterface, hostName string, port int) (SampleWriter, error) {
+	if provider, ok := manager.(SampleWriterProvider); ok {
+		return provider.NewSampleWriter(hostName, port)
+	}
+	return ManagerWriter{Manager: manager, HostName: hostName, Port: port}, nil
+}
+
+// IngestionConfig tunes the ingestion pipeline. All durations are in
+// seconds.
+//
+// A batch is written once it holds BatchSize samples or FlushInterval after
+// its first sample, whichever comes first. Up to QueueSize samples wait for
+// their batch; further samples are dropped. A failed write is retried up to
+// MaxRetries times, waiting from InitialBackoff to MaxBackoff in between.
+// The batches still failing are spooled to SpoolDir, up to MaxSpoolSize
+// bytes, and written again once the backend is back. Without a SpoolDir
+// they are dropped. A spooled batch failing MaxReplayFailures times while
+// the backend takes the other writes is moved aside with the suffix
+// .failed, so that it does not hold back the batches spooled after it.
+type IngestionConfig struct {
+	BatchSize         int    `json:"batchsize"`
+	FlushInterval     int    `json:"flushinterval"`
+	QueueSize         int    `json:"queuesize"`
+	MaxRetries        int    `json:"maxretries"`
+	InitialBackoff    int    `json:"initialbackoff"`
+	MaxBackoff        int    `json:"maxbackoff"`
+	SpoolDir          string `json:"spooldir"`
+	MaxSpoolSize      int64  `json:"maxspoolsize"`
+	MaxReplayFailures int    `json:"maxreplayfailures"`
+}
+
+var DefaultIngestionConfig = IngestionConfig{
+	BatchSize:         500,
+	FlushInterval:     10,
+	QueueSize:         10000,
+	MaxRetries:        3,
+	InitialBackoff:    1,
+	MaxBackoff:        30,
+	SpoolDir:          "/var/lib/skyring/spool",
+	MaxSpoolSize:      100 * 1024 * 1024,
+	MaxReplayFailures: 5,
+}
+
+// IngestionStats counts the samples gone through the pipeline.
+type IngestionStats struct {
+	Received uint64 `json:"received"`
+	Written  uint64 `json:"written"`
+	Retried  uint64 `json:"retried"`
+	Spooled  uint64 `json:"spooled"`
+	Replayed uint64 `json:"replayed"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+const (
+	spoolSuffix      = ".spool"
+	quarantineSuffix = ".failed"
+)
+
+// Pipeline buffers the samples pushed to it and writes them in batches
+// through a SampleWriter from a goroutine of its own.
+type Pipeline struct {
+	writer    SampleWriter
+	config    IngestionConfig
+	connected bool
+	stats     IngestionStats
+	// Whether the last write went through, and the failed replays of the
+	// spool files while it did
+	healthy        bool
+	replayFailures map[string]int
+
+	samples chan Sample
+	flushes chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewPipeline starts a pipeline writing through the writer. The unset fields
+// of the config are taken from DefaultIngestionConfig, but for SpoolDir.
+func NewPipeline(writer SampleWriter, config IngestionConfig) (*Pipeline, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultIngestionConfig.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultIngestionConfig.FlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultIngestionConfig.QueueSize
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultIngestionConfig.InitialBackoff
+	}
+	if config.MaxBackoff < config.InitialBackoff {
+		config.MaxBackoff = config.InitialBackoff
+	}
+	if config.MaxSpoolSize <= 0 {
+		config.MaxSpoolSize = DefaultIngestionConfig.MaxSpoolSize
+	}
+	if config.MaxReplayFailures <= 0 {
+		config.MaxReplayFailures = DefaultIngestionConfig.MaxReplayFailures
+	}
+	if config.SpoolDir != "" {
+		if err := os.MkdirAll(config.SpoolDir, 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create the spool directory %s.Error: %v", config.SpoolDir, err)
+		}
+	}
+	p := &Pipeline{
+		writer:         writer,
+		config:         config,
+		replayFailures: make(map[string]int),
+		samples:        make(chan Sample, config.QueueSize),
+		flushes:        make(chan chan struct{}),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Push queues the samples without blocking. The samples not fitting in the
+// queue and those not being numbers are dropped.
+func (p *Pipeline) Push(samples ...Sample) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return fmt.Errorf("Ingestion pipeline is closed")
+	}
+	var dropped uint64
+	for _, sample := range samples {
+		atomic.AddUint64(&p.stats.Received, 1)
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			dropped++
+			continue
+		}
+		select {
+		case p.samples <- sample:
+		default:
+			dropped++
+		}
+	}
+	if dropped != 0 {
+		atomic.AddUint64(&p.stats.Dropped, dropped)
+		return fmt.Errorf("Dropped %d of %d samples", dropped, len(samples))
+	}
+	return nil
+}
+
+// PushMetrics queues the metrics in the form taken by PushToDb.
+func (p *Pipeline) PushMetrics(metrics map[string]map[string]string) error {
+	samples, err := SamplesFromMetrics(metrics)
+	if err != nil {
+		return err
+	}
+	return p.Push(samples...)
+}
+
+// Flush writes the queued samples and waits for the write, spooling or
+// dropping of the batch.
+func (p *Pipeline) Flush() {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return
+	}
+	flushed := make(chan struct{})
+	p.flushes <- flushed
+	<-flushed
+}
+
+// Close writes the queued samples, spooling them right away if the write
+// fails, and closes the writer.
+func (p *Pipeline) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mutex.Unlock()
+
+	close(p.stop)
+	<-p.done
+	if p.connected {
+		return p.writer.Close()
+	}
+	return nil
+}
+
+// Stats returns the counters of the pipeline.
+func (p *Pipeline) Stats() IngestionStats {
+	return IngestionStats{
+		Received: atomic.LoadUint64(&p.stats.Received),
+		Written:  atomic.LoadUint64(&p.stats.Written),
+		Retried:  atomic.LoadUint64(&p.stats.Retried),
+		Spooled:  atomic.LoadUint64(&p.stats.Spooled),
+		Replayed: atomic.LoadUint64(&p.stats.Replayed),
+		Dropped:  atomic.LoadUint64(&p.stats.Dropped),
+	}
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+	flushInterval := time.Duration(p.config.FlushInterval) * time.Second
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []Sample
+	var batchStart time.Time
+	for {
+		select {
+		case sample := <-p.samples:
+			if len(batch) == 0 {
+				batchStart = time.Now()
+			}
+			batch = append(batch, sample)
+			if len(batch) >= p.config.BatchSize {
+				p.flush(batch, p.config.MaxRetries)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) != 0 && time.Since(batchStart) >= flushInterval {
+				p.flush(batch, p.config.MaxRetries)
+				batch = nil
+			}
+			p.replay()
+		case flushed := <-p.flushes:
+			batch = p.drain(batch)
+			p.flush(batch, p.config.MaxRetries)
+			batch = nil
+			close(flushed)
+		case <-p.stop:
+			batch = p.drain(batch)
+			p.flush(batch, 0)
+			return
+		}
+	}
+}
+
+// drain moves the queued samples to the batch, flushing full batches.
+func (p *Pipeline) drain(batch []Sample) []Sample {
+	for {
+		select {
+		case sample := <-p.samples:
+			batch = append(batch, sample)
+			if len(batch) >= p.config.BatchSize {
+				p.flush(batch, p.config.MaxRetries)
+				batch = nil
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// flush writes the batch, spooling it if the write fails.
+func (p *Pipeline) flush(batch []Sample, retries int) {
+	if len(batch) == 0 {
+		return
+	}
+	err := p.write(batch, retries)
+	p.healthy = err == nil
+	if err == nil {
+		atomic.AddUint64(&p.stats.Written, uint64(len(batch)))
+		return
+	}
+	logger.Get().Warning("Failed to write %d samples. Error: %v", len(batch), err)
+	if err := p.spool(batch); err != nil {
+		logger.Get().Error("Dropping %d samples. Error: %v", len(batch), err)
+		atomic.AddUint64(&p.stats.Dropped, uint64(len(batch)))
+		return
+	}
+	atomic.AddUint64(&p.stats.Spooled, uint64(len(batch)))
+}
+
+// write writes the batch, connecting the writer again and backing off
+// between the attempts.
+func (p *Pipeline) write(batch []Sample, retries int) error {
+	var err error
+	backoff := time.Duration(p.config.InitialBackoff) * time.Second
+	maxBackoff := time.Duration(p.config.MaxBackoff) * time.Second
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt != 0 {
+			atomic.AddUint64(&p.stats.Retried, uint64(len(batch)))
+			time.Sleep(backoff)
+			if backoff = 2 * backoff; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if !p.connected {
+			if err = p.writer.Connect(); err != nil {
+				continue
+			}
+			p.connected = true
+		}
+		if err = p.writer.Write(batch); err == nil {
+			return nil
+		}
+		if closeErr := p.writer.Close(); closeErr != nil {
+			logger.Get().Warning("Failed to close the sample writer. Error: %v", closeErr)
+		}
+		p.connected = false
+	}
+	return err
+}
+
+// spoolFiles returns the spooled batches, oldest first, and their size.
+func (p *Pipeline) spoolFiles() ([]string, int64, error) {
+	files, err := ioutil.ReadDir(p.config.SpoolDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var names []string
+	var size int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), spoolSuffix) {
+			continue
+		}
+		names = append(names, filepath.Join(p.config.SpoolDir, file.Name()))
+		size = size + file.Size()
+	}
+	sort.Strings(names)
+	return names, size, nil
+}
+
+// spool saves the batch as a file of json samples, one per line.
+func (p *Pipeline) spool(batch []Sample) error {
+	if p.config.SpoolDir == "" {
+		return fmt.Errorf("No spool directory configured")
+	}
+	_, size, err := p.spoolFiles()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, sample := range batch {
+		line, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if size+int64(len(data)) > p.config.MaxSpoolSize {
+		return fmt.Errorf("Spool directory %s is full", p.config.SpoolDir)
+	}
+	// Write the batch aside so that a partial file is never replayed
+	path := filepath.Join(p.config.SpoolDir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolSuffix))
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// replay writes the spooled batches, oldest first, until a write fails.
+func (p *Pipeline) replay() {
+	if p.config.SpoolDir == "" {
+		return
+	}
+	names, _, err := p.spoolFiles()
+	if err != nil {
+		logger.Get().Error("Failed to list the spooled samples. Error: %v", err)
+		return
+	}
+	for _, name := range names {
+		batch, err := readSpoolFile(name)
+		if err != nil {
+			logger.Get().Error("Dropping the unreadable spool file %s. Error: %v", name, err)
+			atomic.AddUint64(&p.stats.Dropped, uint64(len(batch)))
+			os.Remove(name)
+			continue
+		}
+		if err := p.write(batch, 0); err != nil {
+			// Failing while the backend is down says nothing of the file
+			if !p.healthy {
+				return
+			}
+			if p.replayFailures[name]++; p.replayFailures[name] < p.config.MaxReplayFailures {
+				return
+			}
+			delete(p.replayFailures, name)
+			logger.Get().Error("Moving aside the spool file %s failing %d times. Error: %v", name, p.config.MaxReplayFailures, err)
+			if err := os.Rename(name, strings.TrimSuffix(name, spoolSuffix)+quarantineSuffix); err != nil {
+				logger.Get().Error("Failed to move aside the spool file %s. Error: %v", name, err)
+				return
+			}
+			continue
+		}
+		p.healthy = true
+		delete(p.replayFailures, name)
+		if err := os.Remove(name); err != nil {
+			logger.Get().Error("Failed to remove the spool file %s. Error: %v", name, err)
+		}
+		atomic.AddUint64(&p.stats.Replayed, uint64(len(batch)))
+		atomic.AddUint64(&p.stats.Written, uint64(len(batch)))
+	}
+}
+
+func readSpoolFile(name string) ([]Sample, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var samples []Sample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample Sample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
@@ -5,7 +5,7 @@ import (
 )
 
 type MonitoringManagerInterface interface {
-	QueryDB(params map[string]interface{}) (interface{}, error)
+	QueryDB(query MetricQuery) ([]Series, error)
 	QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error
 	PushToDb(metrics map[string]map[string]string, hostName string, port int) error
 	GetInstantValue(node string, resource_name string) (float64, error)
@@ -2,6 +2,7 @@ package monitoring
 
 import (
 	"fmt"
+	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"io"
 	"os"
@@ -10,24 +11,24 @@ import (
 
 type MonitoringManagersFactory func(config io.Reader) (MonitoringManagerInterface, error)
 
-var GeneralResources = []string{
-	"df",
-	MEMORY,
-	"cpu",
-	"disk",
-	"network",
-	"swap",
-	CLUSTER_UTILIZATION,
-	SYSTEM_UTILIZATION,
-	STORAGE_PROFILE_UTILIZATION,
-	SLU_UTILIZATION,
-	NO_OF_OBJECT,
-	PG_SUMMARY,
-	"ping",
-	"interface",
-	STORAGE_UTILIZATION,
-	fmt.Sprintf("aggregation-%s-%s", MEMORY, SUM),
-	fmt.Sprintf("aggregation-%s-%s", SWAP, SUM),
+// Deprecated: use Resources. The resources of the registered plugins.
+var GeneralResources []string
+
+// Series names of the resources as written by collectd. The names are
+// templates and may carry wildcards.
+var ResourceCollectionNameMapper = map[string]string{
+	NETWORK_LATENCY:                       "ping.ping-{{.serverName}}",
+	CPU_USER:                              "cpu.percent-user",
+	CPU_SYSTEM:                            "cpu.percent-system",
+	AGGREGATION + INTERFACE + OCTETS + RX: "interface*.if_octets.rx",
+	AGGREGATION + INTERFACE + OCTETS + TX: "interface*.if_octets.tx",
+	AGGREGATION + DISK + READ:             "disk-*.disk_ops.read",
+	AGGREGATION + DISK + WRITE:            "disk-*.disk_ops.write",
+	AGGREGATION + MEMORY:                  "aggregation-memory-sum.memory",
+	AGGREGATION + SWAP:                    "aggregation-swap-sum.swap",
+	AVERAGE + INTERFACE + USED:            "interface-average.bytes-total_bandwidth_used",
+	AVERAGE + INTERFACE + TOTAL:           "interface-average.bytes-total_bandwidth",
+	AVERAGE + INTERFACE + PERCENT:         "interface-average.percent-network_utilization",
 }
 
 var (
@@ -47,10 +48,10 @@ func RegisterMonitoringManager(name string, factory MonitoringManagersFactory) {
 }
 
 func GetMonitoringManager(name string, config io.Reader) (MonitoringManagerInterface, error) {
+	// The factory is called unlocked, as a factory may get other managers
 	monitoringManagersMutex.Lock()
-	defer monitoringManagersMutex.Unlock()
-
 	factory_func, found := monitoringManagers[name]
+	monitoringManagersMutex.Unlock()
 	if !found {
 		logger.Get().Info("Monitoring manager not found", name)
 		return nil, nil
@@ -66,6 +67,10 @@ func InitMonitoringManager(name string, configPath string) (MonitoringManagerInt
 		return nil, nil
 	}
 
+	if err := InitNamingScheme(conf.SystemConfig.TimeSeriesDBConfig.NamingFilePath); err != nil {
+		return nil, err
+	}
+
 	var err error
 	if configPath != "" {
 		config, err := os.Open(configPath)
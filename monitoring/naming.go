@@ -0,0 +1,295 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Actions of the relabeling rules
+const (
+	RELABEL_RENAME = "rename"
+	RELABEL_DROP   = "drop"
+	RELABEL_TAG    = "tag"
+)
+
+// Parts of a metric name the relabeling rules apply to
+const (
+	NAME_COLLECTION = "collection"
+	NAME_ENTITY     = "entity"
+	NAME_RESOURCE   = "resource"
+)
+
+// MetricName is the name of a series split into its parts. The series are
+// named <collection>.<entity>.<resource>, the entity being the node, or the
+// parent of the series like a cluster with the node suffixed to the resource
+// as in <collection>.<parent>.<resource>_<node>. The tags are the dimensions
+// extracted from the name by the relabeling rules.
+type MetricName struct {
+	Collection string
+	Entity     string
+	Resource   string
+	Tags       map[string]string
+}
+
+// Path returns the hierarchical name of the series, without the tags.
+func (m MetricName) Path() string {
+	return m.Collection + "." + m.Entity + "." + m.Resource
+}
+
+// TaggedPath returns the name of the series with the tags appended in the
+// graphite tagged series format, <path>;<tag>=<value>, sorted by tag.
+func (m MetricName) TaggedPath() string {
+	return TaggedName(m.Path(), m.Tags)
+}
+
+// TaggedName appends the tags to the name in the graphite tagged series
+// format.
+func TaggedName(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name = name + ";" + key + "=" + tags[key]
+	}
+	return name
+}
+
+// ParseSeriesName splits the name of a series, possibly carrying tags in the
+// graphite tagged series format.
+func ParseSeriesName(seriesName string) (MetricName, error) {
+	parts := strings.Split(seriesName, ";")
+	path := strings.SplitN(parts[0], ".", 3)
+	if len(path) != 3 || path[0] == "" || path[1] == "" || path[2] == "" {
+		return MetricName{}, fmt.Errorf("Series name %s is not of the form <collection>.<entity>.<resource>", seriesName)
+	}
+	name := MetricName{Collection: path[0], Entity: path[1], Resource: path[2]}
+	for _, tag := range parts[1:] {
+		pair := strings.SplitN(tag, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return MetricName{}, fmt.Errorf("Invalid tag %s of series %s", tag, seriesName)
+		}
+		if name.Tags == nil {
+			name.Tags = make(map[string]string)
+		}
+		name.Tags[pair[0]] = pair[1]
+	}
+	return name, nil
+}
+
+// RelabelRule rewrites the names of the series whose Source part, the
+// resource by default, fully matches Regex. A rename rule replaces the part
+// with Replacement, a tag rule sets the Tag to Replacement, and a drop rule
+// drops the series. The Replacement may refer to the groups of the regex as
+// $1 or ${name}.
+type RelabelRule struct {
+	Action      string `json:"action"`
+	Source      string `json:"source"`
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	Tag         string `json:"tag"`
+
+	regex *regexp.Regexp
+}
+
+func (r *RelabelRule) compile() error {
+	switch r.Action {
+	case RELABEL_RENAME, RELABEL_DROP:
+	case RELABEL_TAG:
+		if r.Tag == "" {
+			return fmt.Errorf("Tag of the %s rule %s not specified", r.Action, r.Regex)
+		}
+	default:
+		return fmt.Errorf("Unsupported relabeling action %s", r.Action)
+	}
+	switch r.Source {
+	case "":
+		r.Source = NAME_RESOURCE
+	case NAME_COLLECTION, NAME_ENTITY, NAME_RESOURCE:
+	default:
+		return fmt.Errorf("Unsupported part %s of the metric names", r.Source)
+	}
+	regex, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("Invalid regex %s of the %s rule. Error: %v", r.Regex, r.Action, err)
+	}
+	r.regex = regex
+	return nil
+}
+
+// NamingScheme configures the names of the series. The dots in the names of
+// the entities, like the node FQDNs, are replaced by EntitySeparator as the
+// dots separate the parts of the names. ResourceNames overrides the series
+// names of ResourceCollectionNameMapper. The rules are applied in order to
+// the names of the series pushed and to the names the queries select, so
+// that the series are found under the names they were pushed with. The
+// rules applied to the queries see the resources queried, which may be the
+// prefixes of the series names carrying wildcards, and the tags are not
+// used to select the series. Graphite is pushed and queried by the plain
+// paths, dropping the tags, so the graphite manager refuses the schemes
+// having tag rules.
+type NamingScheme struct {
+	EntitySeparator string            `json:"entityseparator"`
+	ResourceNames   map[string]string `json:"resourcenames"`
+	Rules           []RelabelRule     `json:"rules"`
+}
+
+var DefaultNamingScheme = NamingScheme{EntitySeparator: "_"}
+
+var (
+	namingSchemeMutex sync.RWMutex
+	namingScheme      = DefaultNamingScheme
+)
+
+// SetNamingScheme validates the scheme and makes it the scheme of all the
+// monitoring managers.
+func SetNamingScheme(scheme NamingScheme) error {
+	if scheme.EntitySeparator == "" {
+		scheme.EntitySeparator = DefaultNamingScheme.EntitySeparator
+	}
+	if strings.ContainsAny(scheme.EntitySeparator, ".;") {
+		return fmt.Errorf("Invalid entity separator %s", scheme.EntitySeparator)
+	}
+	rules := make([]RelabelRule, len(scheme.Rules))
+	copy(rules, scheme.Rules)
+	for index := range rules {
+		if err := rules[index].compile(); err != nil {
+			return err
+		}
+	}
+	scheme.Rules = rules
+	namingSchemeMutex.Lock()
+	defer namingSchemeMutex.Unlock()
+	namingScheme = scheme
+	return nil
+}
+
+// Tagged reports whether the rules of the scheme tag the series.
+func (s NamingScheme) Tagged() bool {
+	for _, rule := range s.Rules {
+		if rule.Action == RELABEL_TAG {
+			return true
+		}
+	}
+	return false
+}
+
+func GetNamingScheme() NamingScheme {
+	namingSchemeMutex.RLock()
+	defer namingSchemeMutex.RUnlock()
+	return namingScheme
+}
+
+// InitNamingScheme sets the scheme read from the json config file, the
+// namingfilepath of the timeseriesdbconfig, leaving the default scheme if no
+// file is given.
+func InitNamingScheme(configPath string) error {
+	if configPath == "" {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read the naming scheme %s. Error: %v", configPath, err)
+	}
+	var scheme NamingScheme
+	if err := json.Unmarshal(contents, &scheme); err != nil {
+		return fmt.Errorf("Failed to parse the naming scheme %s. Error: %v", configPath, err)
+	}
+	return SetNamingScheme(scheme)
+}
+
+// EntityName escapes the dots in the name of a node or a parent.
+func EntityName(name string) string {
+	return strings.Replace(name, ".", GetNamingScheme().EntitySeparator, -1)
+}
+
+// ResourceCollectionName returns the series name template of the resource.
+func ResourceCollectionName(resource string) string {
+	if name, ok := GetNamingScheme().ResourceNames[resource]; ok {
+		return name
+	}
+	return ResourceCollectionNameMapper[resource]
+}
+
+func (m *MetricName) part(source string) *string {
+	switch source {
+	case NAME_COLLECTION:
+		return &m.Collection
+	case NAME_ENTITY:
+		return &m.Entity
+	}
+	return &m.Resource
+}
+
+// Relabel applies the rules of the scheme to the name, returning false if
+// the series is dropped.
+func (s NamingScheme) Relabel(name MetricName) (MetricName, bool) {
+	tags := make(map[string]string, len(name.Tags))
+	for key, value := range name.Tags {
+		tags[key] = value
+	}
+	name.Tags = tags
+	for _, rule := range s.Rules {
+		part := name.part(rule.Source)
+		match := rule.regex.FindStringSubmatchIndex(*part)
+		if match == nil {
+			continue
+		}
+		switch rule.Action {
+		case RELABEL_DROP:
+			return MetricName{}, false
+		case RELABEL_RENAME:
+			*part = string(rule.regex.ExpandString(nil, rule.Replacement, *part, match))
+		case RELABEL_TAG:
+			name.Tags[rule.Tag] = string(rule.regex.ExpandString(nil, rule.Replacement, *part, match))
+		}
+	}
+	if len(name.Tags) == 0 {
+		name.Tags = nil
+	}
+	return name, true
+}
+
+// RelabelSeriesName relabels the name of a series pushed, returning false if
+// the series is dropped.
+func RelabelSeriesName(seriesName string) (MetricName, bool, error) {
+	name, err := ParseSeriesName(seriesName)
+	if err != nil {
+		return MetricName{}, false, err
+	}
+	relabeled, ok := GetNamingScheme().Relabel(name)
+	return relabeled, ok, nil
+}
+
+// QueryName returns the name the series of the resource of the node, within
+// the parent if any, is pushed with, or false if such series are dropped.
+func QueryName(collection string, parent string, node string, resource string) (MetricName, bool) {
+	scheme := GetNamingScheme()
+	escape := func(name string) string {
+		return strings.Replace(name, ".", scheme.EntitySeparator, -1)
+	}
+	name := MetricName{Collection: collection, Entity: escape(node), Resource: resource}
+	if parent != "" {
+		name = MetricName{Collection: collection, Entity: escape(parent), Resource: resource + "_" + escape(node)}
+	}
+	return scheme.Relabel(name)
+}
+
+// SeriesNames returns the names of the series selected by the query, one per
+// node, as they are pushed. The nodes whose series are dropped are left out.
+func (q MetricQuery) SeriesNames() []MetricName {
+	var names []MetricName
+	for _, node := range q.nodes() {
+		if name, ok := QueryName(conf.SystemConfig.TimeSeriesDBConfig.CollectionName, q.ParentName, node, q.Resource); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -69,25 +69,15 @@ const (
 )
 
 var (
-	SupportedConfigCategories = []string{
-		THRESHOLD,
-		"interval",
-		"miscellaneous",
-	}
+	// Deprecated: the configs are validated against the schemas of the
+	// registered plugins. The categories accepted by any of them.
+	SupportedConfigCategories []string
 
-	SupportedThresholdTypes = []string{
-		CRITICAL,
-		WARNING,
-	}
+	// Deprecated: the threshold types accepted by any registered plugin.
+	SupportedThresholdTypes []string
 
-	SupportedMonitoringPlugins = []string{
-		"df",
-		MEMORY,
-		"cpu",
-		"disk",
-		"network",
-		SWAP,
-	}
+	// Deprecated: use PluginNames. The names of the registered plugins.
+	SupportedMonitoringPlugins []string
 
 	MonitoringWritePlugin = "dbpush"
 )
@@ -101,33 +91,42 @@ func Contains(key string, keys []string) bool {
 	return false
 }
 
-func (p Plugin) Valid() bool {
-	validPluginName := Contains(p.Name, SupportedMonitoringPlugins)
-	if validPluginName {
-		for _, config := range p.Configs {
-			if !config.Valid() {
-				return false
-			}
+// Validate checks the plugin is registered and its configs are accepted by
+// its descriptor.
+func (p Plugin) Validate() error {
+	descriptor, ok := GetPluginDescriptor(p.Name)
+	if !ok {
+		return fmt.Errorf("Unsupported plugin %s", p.Name)
+	}
+	for _, config := range p.Configs {
+		if err := descriptor.ValidateConfig(config); err != nil {
+			return err
 		}
-		return true
 	}
-	return false
+	return nil
+}
+
+func (p Plugin) Valid() bool {
+	return p.Validate() == nil
 }
 
 func (c PluginConfig) ValidConfigType() bool {
-	switch c.Category {
-	case "threshold":
-		return c.Type != "" && Contains(c.Type, SupportedThresholdTypes)
-	}
-	return true
+	return knownConfig(c)
 }
 
 func (c PluginConfig) ValidConfigCategory() bool {
-	return Contains(c.Category, SupportedConfigCategories)
+	for _, descriptor := range PluginDescriptors() {
+		if _, ok := descriptor.schema(c.Category); ok {
+			return true
+		}
+	}
+	return false
 }
 
+// Valid checks the config is accepted by any registered plugin. The config
+// is checked against the descriptor of its plugin by Plugin.Validate.
 func (c PluginConfig) Valid() bool {
-	return c.ValidConfigCategory() && c.ValidConfigType()
+	return knownConfig(c)
 }
 
 type collectd_config PluginConfig
@@ -138,8 +137,8 @@ func (p *Plugin) UnmarshalJSON(data []byte) (err error) {
 	if err := json.Unmarshal(data, &tPlugin); err != nil {
 		return err
 	}
-	if !(Plugin(tPlugin)).Valid() {
-		return fmt.Errorf("Couldn't Parse %v", tPlugin)
+	if err := (Plugin(tPlugin)).Validate(); err != nil {
+		return fmt.Errorf("Couldn't Parse %v. Error: %v", tPlugin, err)
 	}
 	*p = Plugin(tPlugin)
 	return nil
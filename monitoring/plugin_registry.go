@@ -0,0 +1,216 @@
+package monitoring
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Kinds of the values of the plugin configs
+const (
+	CONFIG_VALUE_NUMBER  = "number"
+	CONFIG_VALUE_INTEGER = "integer"
+	CONFIG_VALUE_BOOL    = "bool"
+	CONFIG_VALUE_STRING  = "string"
+)
+
+// ConfigSchema describes the configs of a category a plugin accepts. Types
+// lists the allowed types, any type being allowed if it is empty. The
+// numbers are bounded by Min and Max if Max is above Min.
+type ConfigSchema struct {
+	Category  string   `json:"category"`
+	Types     []string `json:"types"`
+	ValueKind string   `json:"valuekind"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+}
+
+// PluginDescriptor describes a monitoring plugin: the configs it accepts,
+// its defaults, the resources, the prefixes of its series, it is queried by
+// and how it maps to collectd.
+//
+// The plugins with ClusterDefault set are given to the new clusters and
+// those with SystemDefault set make the system wide defaults.
+type PluginDescriptor struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	Schema         []ConfigSchema  `json:"schema"`
+	Defaults       []PluginConfig  `json:"defaults"`
+	Enable         bool            `json:"enable"`
+	Resources      []string        `json:"resources"`
+	ClusterDefault bool            `json:"clusterdefault"`
+	SystemDefault  bool            `json:"systemdefault"`
+	Collectd       *CollectdPlugin `json:"collectd,omitempty"`
+}
+
+var (
+	pluginRegistryMutex sync.RWMutex
+	pluginDescriptors   = make(map[string]PluginDescriptor)
+	// The registration order, which is the order the resources are matched
+	pluginNames []string
+)
+
+// RegisterPlugin registers the descriptor of a plugin, replacing the one
+// registered earlier with the same name.
+func RegisterPlugin(descriptor PluginDescriptor) error {
+	if descriptor.Name == "" {
+		return fmt.Errorf("Plugin name not specified")
+	}
+	plugin := descriptor.DefaultPlugin()
+	for _, config := range plugin.Configs {
+		if err := descriptor.ValidateConfig(config); err != nil {
+			return fmt.Errorf("Invalid default of plugin %s. Error: %v", descriptor.Name, err)
+		}
+	}
+
+	pluginRegistryMutex.Lock()
+	defer pluginRegistryMutex.Unlock()
+	if _, found := pluginDescriptors[descriptor.Name]; !found {
+		pluginNames = append(pluginNames, descriptor.Name)
+	}
+	pluginDescriptors[descriptor.Name] = descriptor
+	updateDeprecatedLists()
+	return nil
+}
+
+// updateDeprecatedLists fills the lists deprecated by the registry from the
+// registered plugins. The registry lock must be held.
+func updateDeprecatedLists() {
+	var resources, categories, thresholdTypes []string
+	for _, name := range pluginNames {
+		descriptor := pluginDescriptors[name]
+		for _, resource := range descriptor.Resources {
+			if !Contains(resource, resources) {
+				resources = append(resources, resource)
+			}
+		}
+		for _, schema := range descriptor.Schema {
+			if !Contains(schema.Category, categories) {
+				categories = append(categories, schema.Category)
+			}
+			if schema.Category != THRESHOLD {
+				continue
+			}
+			for _, thresholdType := range schema.Types {
+				if !Contains(thresholdType, thresholdTypes) {
+					thresholdTypes = append(thresholdTypes, thresholdType)
+				}
+			}
+		}
+	}
+	names := make([]string, len(pluginNames))
+	copy(names, pluginNames)
+	SupportedMonitoringPlugins = names
+	GeneralResources = resources
+	SupportedConfigCategories = categories
+	SupportedThresholdTypes = thresholdTypes
+}
+
+func GetPluginDescriptor(name string) (PluginDescriptor, bool) {
+	pluginRegistryMutex.RLock()
+	defer pluginRegistryMutex.RUnlock()
+	descriptor, found := pluginDescriptors[name]
+	return descriptor, found
+}
+
+// PluginDescriptors returns the registered descriptors in the order of
+// their registration.
+func PluginDescriptors() []PluginDescriptor {
+	pluginRegistryMutex.RLock()
+	defer pluginRegistryMutex.RUnlock()
+	descriptors := make([]PluginDescriptor, len(pluginNames))
+	for index, name := range pluginNames {
+		descriptors[index] = pluginDescriptors[name]
+	}
+	return descriptors
+}
+
+// PluginNames returns the names of the registered plugins.
+func PluginNames() []string {
+	pluginRegistryMutex.RLock()
+	defer pluginRegistryMutex.RUnlock()
+	names := make([]string, len(pluginNames))
+	copy(names, pluginNames)
+	return names
+}
+
+// Resources returns the resources of the registered plugins.
+func Resources() []string {
+	var resources []string
+	for _, descriptor := range PluginDescriptors() {
+		for _, resource := range descriptor.Resources {
+			if !Contains(resource, resources) {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	return resources
+}
+
+// MatchResource tells if the resource is a registered resource or a series
+// name prefixed by one, in which case the resource is fully qualified.
+func MatchResource(resource string) (matched bool, fullQualifiedMetricName bool) {
+	for _, permittedKey := range Resources() {
+		if len(resource) >= len(permittedKey) && resource[:len(permittedKey)] == permittedKey {
+			return true, resource != permittedKey
+		}
+	}
+	return false, false
+}
+
+// DefaultPlugin returns the plugin with its default configs.
+func (d PluginDescriptor) DefaultPlugin() Plugin {
+	configs := make([]PluginConfig, len(d.Defaults))
+	copy(configs, d.Defaults)
+	return Plugin{Name: d.Name, Description: d.Description, Enable: d.Enable, Configs: configs}
+}
+
+func (d PluginDescriptor) schema(category string) (ConfigSchema, bool) {
+	for _, schema := range d.Schema {
+		if schema.Category == category {
+			return schema, true
+		}
+	}
+	return ConfigSchema{}, false
+}
+
+// ValidateConfig checks the config against the schema of the plugin.
+func (d PluginDescriptor) ValidateConfig(config PluginConfig) error {
+	schema, ok := d.schema(config.Category)
+	if !ok {
+		return fmt.Errorf("Plugin %s does not accept %s configs", d.Name, config.Category)
+	}
+	if len(schema.Types) != 0 && !Contains(config.Type, schema.Types) {
+		return fmt.Errorf("Plugin %s does not accept %s config %s", d.Name, config.Category, config.Type)
+	}
+	switch schema.ValueKind {
+	case CONFIG_VALUE_NUMBER, CONFIG_VALUE_INTEGER:
+		value, err := strconv.ParseFloat(config.Value, 64)
+		if err == nil && schema.ValueKind == CONFIG_VALUE_INTEGER {
+			_, err = strconv.Atoi(config.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s config %s of plugin %s with value %v is not a %s", config.Category, config.Type, d.Name, config.Value, schema.ValueKind)
+		}
+		if schema.Max > schema.Min && (value < schema.Min || value > schema.Max) {
+			return fmt.Errorf("%s config %s of plugin %s with value %v is not within %v and %v", config.Category, config.Type, d.Name, config.Value, schema.Min, schema.Max)
+		}
+	case CONFIG_VALUE_BOOL:
+		if _, err := strconv.ParseBool(config.Value); err != nil {
+			return fmt.Errorf("%s config %s of plugin %s with value %v is not a bool", config.Category, config.Type, d.Name, config.Value)
+		}
+	}
+	return nil
+}
+
+// knownConfig tells if any registered plugin accepts the config.
+func knownConfig(config PluginConfig) bool {
+	for _, descriptor := range PluginDescriptors() {
+		if schema, ok := descriptor.schema(config.Category); ok {
+			if len(schema.Types) == 0 || Contains(config.Type, schema.Types) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -5,34 +5,25 @@ import (
 	"strconv"
 )
 
+// UpdatePluginsConfigs sets the expected configs on the current plugins of a
+// cluster, replacing the configs of the same category and type. A plugin
+// missing from the cluster is added with the defaults of its registered
+// descriptor.
 func UpdatePluginsConfigs(currentPlugins []Plugin, expectedPlugins []Plugin) ([]Plugin, error) {
-	var updated bool
-	newPluginExpected := true
 	for _, ePlugin := range expectedPlugins {
-		for cPluginIndex, cPlugin := range currentPlugins {
-			if ePlugin.Name == cPlugin.Name {
-				for _, eConfig := range ePlugin.Configs {
-					for cConfigIndex, cConfig := range cPlugin.Configs {
-						if eConfig.Type == cConfig.Type {
-							updated = true
-							currentPlugins[cPluginIndex].Configs[cConfigIndex] = eConfig
-						}
-					}
-					if !updated {
-						currentPlugins[cPluginIndex].Configs = append(currentPlugins[cPluginIndex].Configs, eConfig)
-					}
-					updated = false
-				}
-				newPluginExpected = false
-			}
-			if ePlugin.Name != cPlugin.Name && cPluginIndex == len(currentPlugins) {
-				newPluginExpected = true
-				break
-			}
-		}
-		if newPluginExpected {
+		descriptor, ok := GetPluginDescriptor(ePlugin.Name)
+		if !ok {
 			return nil, fmt.Errorf("Cannot add plugin %s", ePlugin.Name)
 		}
+		cPluginIndex := GetPluginIndex(ePlugin.Name, currentPlugins)
+		if cPluginIndex == -1 {
+			currentPlugins = append(currentPlugins, descriptor.DefaultPlugin())
+			cPluginIndex = len(currentPlugins) - 1
+		}
+		currentPlugins[cPluginIndex].Configs = mergePluginConfigs(currentPlugins[cPluginIndex].Configs, ePlugin.Configs)
+		if err := currentPlugins[cPluginIndex].Validate(); err != nil {
+			return nil, err
+		}
 	}
 	return currentPlugins, nil
 }
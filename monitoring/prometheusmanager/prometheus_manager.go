@@ -0,0 +1,563 @@
+package prometheusmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/utils"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	TimeSeriesDBManagerName = "PrometheusManager"
+	// Job under which the metrics are pushed to the pushgateway
+	pushJobName = "skyring"
+	// Label carrying the node the metrics belong to
+	instanceLabel = "instance"
+)
+
+// Resolution of the range queries unless a step is passed
+var DefaultStep = time.Minute
+
+type PrometheusManager struct {
+}
+
+func init() {
+	monitoring.RegisterMonitoringManager(TimeSeriesDBManagerName, func(config io.Reader) (monitoring.MonitoringManagerInterface, error) {
+		return NewPrometheusManager(config)
+	})
+}
+
+func NewPrometheusManager(config io.Reader) (*PrometheusManager, error) {
+	return &PrometheusManager{}, nil
+}
+
+// PrometheusMetric is a series of the result of a query. Range queries fill
+// Values and instant queries fill Value, each sample being a pair of the
+// unix timestamp and the value as a string.
+type PrometheusMetric struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values,omitempty"`
+	Value  []interface{}     `json:"value,omitempty"`
+}
+
+type queryResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string             `json:"resultType"`
+		Result     []PrometheusMetric `json:"result"`
+	} `json:"data"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+var invalidMetricNameChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// MetricName maps the collectd series name of a resource to the name of
+// the prometheus metric, e.g. cpu-0.percent-user to <collection>_cpu_0_percent_user.
+func MetricName(resource string) string {
+	return metricName(conf.SystemConfig.TimeSeriesDBConfig.CollectionName, resource)
+}
+
+func metricName(collection string, resource string) string {
+	return invalidMetricNameChars.ReplaceAllString(collection+"_"+resource, "_")
+}
+
+func (pm PrometheusManager) GetResourceName(params map[string]interface{}) (string, error) {
+	resource_name, ok := params["resource_name"].(string)
+	if !ok {
+		return "", fmt.Errorf("Resource %v not found", params["resource_name"])
+	}
+	buf := new(bytes.Buffer)
+	parsedTemplate, err := template.New("prometheus_resource_name").Parse(monitoring.ResourceCollectionName(resource_name))
+	if err != nil {
+		return "", err
+	}
+	if err := parsedTemplate.Execute(buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (pm PrometheusManager) QueryMonitoringDB(urlStr string, w http.ResponseWriter, r *http.Request) error {
+	url := fmt.Sprintf("http://%s:%s/api/v1/query?%s", conf.SystemConfig.TimeSeriesDBConfig.Hostname, strconv.Itoa(conf.SystemConfig.TimeSeriesDBConfig.Port), urlStr)
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// nameRegex translates the collectd series name with graphite style
+// wildcards into a regular expression matching the metric names.
+func nameRegex(collection string, resource string, fullQualifiedMetricName bool) string {
+	parts := strings.Split(resource, "*")
+	for index, part := range parts {
+		parts[index] = regexp.QuoteMeta(invalidMetricNameChars.ReplaceAllString(part, "_"))
+	}
+	expr := metricName(collection, "") + strings.Join(parts, ".*")
+	if !fullQualifiedMetricName {
+		expr = expr + "_.*"
+	}
+	return expr
+}
+
+// appendUnique appends the value to the values if not there yet.
+func appendUnique(values []string, value string) []string {
+	for _, current := range values {
+		if current == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// selector builds the PromQL selector of the series of the resource, named
+// as per the naming scheme, leaving out the series of the exception
+// resources.
+func selector(resource string, seriesNames []monitoring.MetricName, exceptionResources []string) (string, error) {
+	matched, fullQualifiedMetricName := monitoring.MatchResource(resource)
+	if !matched {
+		return "", fmt.Errorf("%v is an unsupported Resource", resource)
+	}
+	if len(seriesNames) == 0 {
+		return "", fmt.Errorf("Series of %v dropped by the naming scheme", resource)
+	}
+	var names, instances []string
+	for _, seriesName := range seriesNames {
+		names = appendUnique(names, nameRegex(seriesName.Collection, seriesName.Resource, fullQualifiedMetricName))
+		instances = appendUnique(instances, regexp.QuoteMeta(seriesName.Entity))
+	}
+	matchers := []string{
+		fmt.Sprintf("__name__=~%s", strconv.Quote(strings.Join(names, "|"))),
+		fmt.Sprintf("%s=~%s", instanceLabel, strconv.Quote(strings.Join(instances, "|"))),
+	}
+	for _, eResource := range exceptionResources {
+		matchers = append(matchers, fmt.Sprintf("__name__!~%s", strconv.Quote(nameRegex(conf.SystemConfig.TimeSeriesDBConfig.CollectionName, eResource, false))))
+	}
+	return "{" + strings.Join(matchers, ",") + "}", nil
+}
+
+func formatUnixTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', 3, 64)
+}
+
+func apiURL(path string, values url.Values) string {
+	return fmt.Sprintf("http://%s:%d/api/v1/%s?%s", conf.SystemConfig.TimeSeriesDBConfig.Hostname, conf.SystemConfig.TimeSeriesDBConfig.Port, path, values.Encode())
+}
+
+func query(path string, values url.Values) ([]PrometheusMetric, error) {
+	results, err := util.HTTPGet(apiURL(path, values))
+	if err != nil {
+		return nil, err
+	}
+	var response queryResponse
+	if err := json.Unmarshal(results, &response); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling the metrics %s.Error:%v", string(results), err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("Query %s failed. %s: %s", values.Get("query"), response.ErrorType, response.Error)
+	}
+	return response.Data.Result, nil
+}
+
+type seriesResponse struct {
+	Status    string              `json:"status"`
+	Data      []map[string]string `json:"data"`
+	ErrorType string              `json:"errorType"`
+	Error     string              `json:"error"`
+}
+
+// metricNames returns the names of the metrics of the series matched by the
+// selector between start and end, sorted.
+func metricNames(selector string, start time.Time, end time.Time) ([]string, error) {
+	results, err := util.HTTPGet(apiURL("series", url.Values{
+		"match[]": {selector},
+		"start":   {formatUnixTime(start)},
+		"end":     {formatUnixTime(end)},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var response seriesResponse
+	if err := json.Unmarshal(results, &response); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling the series %s.Error:%v", string(results), err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("Query of the series %s failed. %s: %s", selector, response.ErrorType, response.Error)
+	}
+	var names []string
+	for _, labels := range response.Data {
+		names = appendUnique(names, labels["__name__"])
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+var (
+	overTimeFunctions = map[string]string{
+		monitoring.AGGREGATE_AVG: "avg_over_time",
+		monitoring.AGGREGATE_MIN: "min_over_time",
+		monitoring.AGGREGATE_MAX: "max_over_time",
+		monitoring.AGGREGATE_SUM: "sum_over_time",
+	}
+	aggregationOperators = map[string]string{
+		monitoring.AGGREGATE_AVG: "avg",
+		monitoring.AGGREGATE_MIN: "min",
+		monitoring.AGGREGATE_MAX: "max",
+		monitoring.AGGREGATE_SUM: "sum",
+	}
+)
+
+// aggregate wraps the PromQL expression as per the aggregation of the query.
+func aggregate(promQL string, metricQuery monitoring.MetricQuery) (string, error) {
+	aggregation := *metricQuery.Aggregation
+	if aggregation.Step != "" && !metricQuery.IsLatest() {
+		step, _ := aggregation.StepDuration()
+		if aggregation.Function == monitoring.AGGREGATE_PERCENTILE {
+			promQL = fmt.Sprintf("quantile_over_time(%v, %s[%ds])", aggregation.Percentile/100, promQL, int64(step.Seconds()))
+		} else {
+			promQL = fmt.Sprintf("%s(%s[%ds])", overTimeFunctions[aggregation.Function], promQL, int64(step.Seconds()))
+		}
+	}
+	var grouping string
+	switch aggregation.GroupBy {
+	case "":
+		return promQL, nil
+	case monitoring.GROUP_BY_NODE:
+		// Within a parent the instance is the parent and not the node
+		if metricQuery.ParentName != "" {
+			return "", fmt.Errorf("Grouping by node within %s is not supported", metricQuery.ParentName)
+		}
+		grouping = fmt.Sprintf(" by (%s)", instanceLabel)
+	}
+	if aggregation.Function == monitoring.AGGREGATE_PERCENTILE {
+		return fmt.Sprintf("quantile%s(%v, %s)", grouping, aggregation.Percentile/100, promQL), nil
+	}
+	return fmt.Sprintf("%s%s(%s)", aggregationOperators[aggregation.Function], grouping, promQL), nil
+}
+
+// namedOverTime downsamples the series of each of the metrics matched by
+// the selector apart, labelling the results with the name of their metric
+// as the *_over_time functions drop it. Without it the series of the
+// different metrics would not be told apart, or even be rejected by
+// prometheus as having the same labels.
+func namedOverTime(sel string, metricQuery monitoring.MetricQuery, start time.Time, end time.Time) (string, error) {
+	names, err := metricNames(sel, start, end)
+	if err != nil {
+		return "", err
+	}
+	var exprs []string
+	for _, name := range names {
+		expr, err := aggregate("{__name__="+strconv.Quote(name)+","+sel[1:], metricQuery)
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, fmt.Sprintf(`label_replace(%s, "__name__", %s, "", "")`, expr, strconv.Quote(name)))
+	}
+	return strings.Join(exprs, " or "), nil
+}
+
+// QueryDB runs a range query for the resource of the nodes, or an instant
+// query if the interval is monitoring.Latest. The aggregation is done using
+// the PromQL aggregation operators and the *_over_time functions.
+func (pm PrometheusManager) QueryDB(metricQuery monitoring.MetricQuery) ([]monitoring.Series, error) {
+	if err := metricQuery.Valid(); err != nil {
+		return nil, err
+	}
+	sel, err := selector(metricQuery.Resource, metricQuery.SeriesNames(), nil)
+	if err != nil {
+		return nil, err
+	}
+	promQL := sel
+	if metricQuery.Aggregation != nil {
+		if promQL, err = aggregate(promQL, metricQuery); err != nil {
+			return nil, err
+		}
+	}
+	if metricQuery.IsLatest() {
+		metrics, err := query("query", url.Values{"query": {promQL}})
+		if err != nil {
+			return nil, err
+		}
+		return toSeries(metrics, metricQuery), nil
+	}
+
+	now := time.Now()
+	start := now.Add(-24 * time.Hour)
+	end := now
+	if metricQuery.StartTime != "" {
+		if start, err = monitoring.ParseTime(metricQuery.StartTime, now); err != nil {
+			return nil, err
+		}
+	}
+	if metricQuery.EndTime != "" {
+		if end, err = monitoring.ParseTime(metricQuery.EndTime, now); err != nil {
+			return nil, err
+		}
+	}
+	if metricQuery.Interval != "" {
+		if metricQuery.EndTime != "" {
+			return nil, fmt.Errorf("Unsupported combination of start time %v and end time %v", metricQuery.StartTime, metricQuery.EndTime)
+		}
+		duration, err := monitoring.ParseDuration(metricQuery.Interval)
+		if err != nil {
+			return nil, err
+		}
+		if metricQuery.StartTime != "" {
+			end = start.Add(duration)
+		} else {
+			start = now.Add(-duration)
+		}
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("Start time %v is not before end time %v", start, end)
+	}
+
+	step := DefaultStep
+	if metricQuery.Aggregation != nil && metricQuery.Aggregation.Step != "" {
+		step, _ = metricQuery.Aggregation.StepDuration()
+		// Align the buckets with the step
+		start = start.Truncate(step)
+	}
+	// Prometheus refuses queries resulting in more than 11000 points per series
+	if minStep := end.Sub(start) / 11000; step < minStep {
+		step = minStep + time.Second
+	}
+	// The series downsampled but not grouped are named after their metrics
+	if metricQuery.Aggregation != nil && metricQuery.Aggregation.Step != "" && metricQuery.Aggregation.GroupBy == "" {
+		if promQL, err = namedOverTime(sel, metricQuery, start, end); err != nil {
+			return nil, err
+		}
+		if promQL == "" {
+			return []monitoring.Series{}, nil
+		}
+	}
+
+	metrics, err := query("query_range", url.Values{
+		"query": {promQL},
+		"start": {formatUnixTime(start)},
+		"end":   {formatUnixTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toSeries(metrics, metricQuery), nil
+}
+
+var (
+	invalidLabelNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+	labelValueEscaper     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	// Labels set by prometheus and not by the naming scheme
+	reservedLabels = map[string]bool{"__name__": true, instanceLabel: true, "job": true}
+)
+
+// formatLabels formats the tags of a series as the labels of its metric.
+func formatLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var labels []string
+	for key, value := range tags {
+		labels = append(labels, invalidLabelNameChars.ReplaceAllString(key, "_")+`="`+labelValueEscaper.Replace(value)+`"`)
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// tags returns the labels of a metric which are the tags of its series.
+func tags(labels map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for key, value := range labels {
+		if !reservedLabels[key] {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// toSeries converts the results of a query into series named after the
+// metrics, or after the groups for grouped results. Samples which are not
+// numbers are left out.
+func toSeries(metrics []PrometheusMetric, metricQuery monitoring.MetricQuery) []monitoring.Series {
+	series := make([]monitoring.Series, len(metrics))
+	for index, metric := range metrics {
+		samples := metric.Values
+		if metric.Value != nil {
+			samples = [][]interface{}{metric.Value}
+		}
+		name := monitoring.TaggedName(metric.Metric["__name__"], tags(metric.Metric))
+		if metricQuery.Aggregation != nil {
+			switch metricQuery.Aggregation.GroupBy {
+			case monitoring.GROUP_BY_NODE:
+				name = metric.Metric[instanceLabel]
+			case monitoring.GROUP_BY_CLUSTER:
+				name = metricQuery.GroupOf("")
+			}
+		}
+		series[index] = monitoring.Series{Name: name, DataPoints: make([]monitoring.DataPoint, 0, len(samples))}
+		for _, sample := range samples {
+			value, err := sampleValue(sample)
+			if err != nil {
+				continue
+			}
+			timestamp, _ := sample[0].(float64)
+			series[index].DataPoints = append(series[index].DataPoints, monitoring.DataPoint{Timestamp: int64(timestamp), Value: value})
+		}
+	}
+	return series
+}
+
+func sampleValue(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0.0, fmt.Errorf("Invalid sample %v", sample)
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0.0, fmt.Errorf("Invalid sample %v", sample)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func (pm PrometheusManager) GetInstantValue(node string, resource_name string) (float64, error) {
+	node = monitoring.EntityName(node)
+	series, err := pm.QueryDB(monitoring.MetricQuery{NodeName: node, Resource: resource_name, Interval: monitoring.Latest})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, err)
+	}
+	if len(series) != 1 || len(series[0].DataPoints) == 0 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node)
+	}
+	fVal := series[0].DataPoints[0].Value
+	if math.IsNaN(fVal) {
+		return 0.0, fmt.Errorf("The value %v from resource %v of %v is not a number", fVal, resource_name, node)
+	}
+	return fVal, nil
+}
+
+func (pm PrometheusManager) GetInstantValuesAggregation(node string, resource_name string, exceptionResources []string) (aggregatedValue float64, err error, isCompleteFailure bool) {
+	var err_str string
+	node = monitoring.EntityName(node)
+	seriesNames := monitoring.MetricQuery{NodeName: node, Resource: resource_name}.SeriesNames()
+	promQL, err := selector(resource_name, seriesNames, exceptionResources)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, err), true
+	}
+	metrics, mStatsFetchError := query("query", url.Values{"query": {promQL}})
+	if mStatsFetchError != nil {
+		return 0, fmt.Errorf("Failed to fetch instant %v statistics for %s.Error: %v", resource_name, node, mStatsFetchError), true
+	}
+	if len(metrics) == 0 {
+		return 0, fmt.Errorf("Failed to get the instant stat of %v resource of %v", resource_name, node), true
+	}
+	metric_cnt := len(metrics)
+	for _, metric := range metrics {
+		val, err := sampleValue(metric.Value)
+		if err != nil {
+			err_str = err_str + fmt.Sprintf("Failed to get the instant stat of %v from %v of %v\n", resource_name, metric.Metric["__name__"], node)
+			// Decrement metric_cnt for average as the current node is not contributing.
+			metric_cnt = metric_cnt - 1
+			continue
+		}
+		if !math.IsNaN(val) {
+			aggregatedValue = aggregatedValue + val
+		}
+	}
+	if metric_cnt == 0 {
+		if err_str == "" {
+			err_str = fmt.Sprintf("No series of %v of %v left to aggregate", resource_name, node)
+		}
+		return 0, fmt.Errorf("%v", strings.TrimSpace(err_str)), true
+	}
+	if err_str != "" {
+		return aggregatedValue / float64(metric_cnt), fmt.Errorf("%v", strings.TrimSpace(err_str)), false
+	}
+	return aggregatedValue / float64(metric_cnt), nil, false
+}
+
+// This method takes map[string]map[string]string ==> map[metric/table name]map[timestamp]value
+// The table names are of the form <collection>.<node>.<resource> and are relabeled
+// as per the naming scheme, the tags becoming labels. The pushgateway keeps only
+// the latest sample of a series, so the older samples are dropped.
+func (pm PrometheusManager) PushToDb(metrics map[string]map[string]string, hostName string, port int) (err error) {
+	defer monitoring.ObservePush(TimeSeriesDBManagerName, monitoring.SampleCount(metrics), time.Now(), &err)
+	// Latest sample of every metric grouped by node
+	samples := make(map[string]map[string]float64)
+	for tableName, valueMap := range metrics {
+		name, ok, err := monitoring.RelabelSeriesName(tableName)
+		if err != nil {
+			logger.Get().Warning("Skipping the metric %s. Error: %v", tableName, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var latest int64 = -1
+		var latestValue float64
+		for timestamp, value := range valueMap {
+			timeInt, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse timestamp %v of metric tableName %v.Error: %v", timestamp, tableName, err.Error())
+			}
+			fVal, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("Failed to parse value %v of metric tableName %v.Error: %v", value, tableName, err.Error())
+			}
+			if timeInt > latest {
+				latest = timeInt
+				latestValue = fVal
+			}
+		}
+		if latest == -1 {
+			continue
+		}
+		if _, ok := samples[name.Entity]; !ok {
+			samples[name.Entity] = make(map[string]float64)
+		}
+		// The labels follow the metric name, keeping the series of a
+		// metric together once sorted
+		samples[name.Entity][metricName(name.Collection, name.Resource)+"\x00"+formatLabels(name.Tags)] = latestValue
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for node, nodeSamples := range samples {
+		names := make([]string, 0, len(nodeSamples))
+		for name := range nodeSamples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		body := new(bytes.Buffer)
+		var typed string
+		for _, name := range names {
+			parts := strings.SplitN(name, "\x00", 2)
+			if parts[0] != typed {
+				fmt.Fprintf(body, "# TYPE %s untyped\n", parts[0])
+				typed = parts[0]
+			}
+			fmt.Fprintf(body, "%s%s %s\n", parts[0], parts[1], strconv.FormatFloat(nodeSamples[name], 'g', -1, 64))
+		}
+		url := fmt.Sprintf("http://%s:%d/metrics/job/%s/%s/%s", hostName, port, pushJobName, instanceLabel, url.PathEscape(node))
+		response, err := client.Post(url, "text/plain; version=0.0.4", body)
+		if err != nil {
+			return fmt.Errorf("Failed to push the metrics of %s to %s.Error: %v", node, url, err)
+		}
+		contents, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("Failed to push the metrics of %s to %s. Status: %s %s", node, url, response.Status, strings.TrimSpace(string(contents)))
+		}
+		logger.Get().Debug("Pushed %d metrics of %s to the pushgateway", len(names), node)
+	}
+	return nil
+}
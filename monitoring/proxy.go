@@ -0,0 +1,221 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modes of serving the queries to the monitoring db
+const (
+	QUERY_MODE_REDIRECT = "redirect"
+	QUERY_MODE_PROXY    = "proxy"
+)
+
+// ProxyConfig configures how the queries to the monitoring db are served.
+// In the redirect mode the clients are redirected to the monitoring db. In
+// the proxy mode the queries are forwarded to it with the PassHeaders of
+// the request, or the User and Password if the request carries no
+// authorization, and the response is passed back. The responses above
+// MaxResponseSize bytes are refused and the successful ones are cached for
+// CacheTTL seconds, up to CacheSize of them. The timeout is in seconds.
+type ProxyConfig struct {
+	Mode            string   `json:"mode"`
+	Timeout         int      `json:"timeout"`
+	MaxResponseSize int64    `json:"maxresponsesize"`
+	CacheTTL        int      `json:"cachettl"`
+	CacheSize       int      `json:"cachesize"`
+	PassHeaders     []string `json:"passheaders"`
+	User            string   `json:"user"`
+	Password        string   `json:"password"`
+}
+
+var DefaultProxyConfig = ProxyConfig{
+	Mode:            QUERY_MODE_REDIRECT,
+	Timeout:         30,
+	MaxResponseSize: 10 * 1024 * 1024,
+	CacheTTL:        60,
+	CacheSize:       256,
+	PassHeaders:     []string{"Authorization"},
+}
+
+type proxyResponse struct {
+	status      int
+	contentType string
+	// The challenge of the responses refusing the credentials
+	wwwAuthenticate string
+	body            []byte
+	expires         time.Time
+}
+
+// RenderProxy forwards the render queries to the monitoring db, rewriting
+// the names of the series in the json responses to the names shown to the
+// users.
+type RenderProxy struct {
+	config  ProxyConfig
+	client  *http.Client
+	rewrite func(name string) string
+
+	mutex sync.Mutex
+	cache map[string]proxyResponse
+	// The cached keys, oldest first
+	keys []string
+}
+
+// NewRenderProxy returns the proxy rewriting the series names with rewrite,
+// which may be nil to leave them as they are.
+func NewRenderProxy(config ProxyConfig, rewrite func(name string) string) *RenderProxy {
+	return &RenderProxy{
+		config:  config,
+		client:  &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		rewrite: rewrite,
+		cache:   make(map[string]proxyResponse),
+	}
+}
+
+// Serve serves the request with the response of the monitoring db to the
+// url. The authentication failures of the monitoring db are passed back
+// as they are, uncached. Nothing is written to w on failure.
+func (p *RenderProxy) Serve(url string, w http.ResponseWriter, r *http.Request) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("Invalid query %s. Error: %v", url, err)
+	}
+	// The cached responses are shared only by the requests carrying the
+	// same credentials
+	key := url
+	for _, header := range p.config.PassHeaders {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+			key = key + "\n" + header + ": " + value
+		}
+	}
+	if req.Header.Get("Authorization") == "" && p.config.User != "" {
+		req.SetBasicAuth(p.config.User, p.config.Password)
+	}
+
+	response, cached := p.lookup(key)
+	if !cached {
+		if response, err = p.fetch(req); err != nil {
+			return err
+		}
+		if response.status == http.StatusOK {
+			p.store(key, response)
+		}
+	}
+	if response.contentType != "" {
+		w.Header().Set("Content-Type", response.contentType)
+	}
+	if response.wwwAuthenticate != "" {
+		w.Header().Set("WWW-Authenticate", response.wwwAuthenticate)
+	}
+	if cached {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.WriteHeader(response.status)
+	_, err = w.Write(response.body)
+	return err
+}
+
+func (p *RenderProxy) fetch(req *http.Request) (proxyResponse, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return proxyResponse{}, fmt.Errorf("Failed to query the monitoring db. Error: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := io.Reader(resp.Body)
+	if p.config.MaxResponseSize > 0 {
+		reader = io.LimitReader(resp.Body, p.config.MaxResponseSize+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return proxyResponse{}, fmt.Errorf("Failed to read the response of the monitoring db. Error: %v", err)
+	}
+	if p.config.MaxResponseSize > 0 && int64(len(body)) > p.config.MaxResponseSize {
+		return proxyResponse{}, fmt.Errorf("Response of the monitoring db exceeds %d bytes", p.config.MaxResponseSize)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return proxyResponse{
+			status:          resp.StatusCode,
+			contentType:     resp.Header.Get("Content-Type"),
+			wwwAuthenticate: resp.Header.Get("WWW-Authenticate"),
+			body:            body,
+		}, nil
+	default:
+		return proxyResponse{}, fmt.Errorf("Monitoring db responded with %s. Error: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	response := proxyResponse{status: http.StatusOK, contentType: resp.Header.Get("Content-Type"), body: body}
+	if p.rewrite != nil && strings.Contains(response.contentType, "json") {
+		response.body = p.rewriteNames(body)
+	}
+	return response, nil
+}
+
+// rewriteNames rewrites the names of the series, the targets of the render
+// responses, leaving the body as is if it is not a list of series.
+func (p *RenderProxy) rewriteNames(body []byte) []byte {
+	var series []map[string]interface{}
+	if err := json.Unmarshal(body, &series); err != nil {
+		return body
+	}
+	for _, current := range series {
+		if name, ok := current["target"].(string); ok {
+			current["target"] = p.rewrite(name)
+		}
+	}
+	rewritten, err := json.Marshal(series)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
+func (p *RenderProxy) lookup(key string) (proxyResponse, bool) {
+	if p.config.CacheTTL <= 0 {
+		return proxyResponse{}, false
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	response, ok := p.cache[key]
+	if !ok || time.Now().After(response.expires) {
+		return proxyResponse{}, false
+	}
+	return response, true
+}
+
+func (p *RenderProxy) store(key string, response proxyResponse) {
+	if p.config.CacheTTL <= 0 || p.config.CacheSize <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	response.expires = now.Add(time.Duration(p.config.CacheTTL) * time.Second)
+	if _, ok := p.cache[key]; !ok {
+		p.keys = append(p.keys, key)
+	}
+	p.cache[key] = response
+	// Drop the expired responses, then the oldest ones beyond the size
+	var keys []string
+	for _, current := range p.keys {
+		if now.After(p.cache[current].expires) {
+			delete(p.cache, current)
+			continue
+		}
+		keys = append(keys, current)
+	}
+	for len(keys) > p.config.CacheSize {
+		delete(p.cache, keys[0])
+		keys = keys[1:]
+	}
+	p.keys = keys
+}
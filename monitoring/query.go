@@ -0,0 +1,190 @@
+package monitoring
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetricQuery selects the series of a resource of a node, or of the nodes
+// in Nodes if set.
+//
+// Resource is a general resource or a series name prefixed by one. The
+// series of a node within its parent, like a cluster, are selected by
+// setting ParentName. The time range is given by StartTime and EndTime, by
+// an Interval relative to StartTime or to now, or is Latest to fetch just
+// the latest value of every series. The series are reduced by the backend
+// as per Aggregation if set.
+type MetricQuery struct {
+	Resource    string       `json:"resource"`
+	NodeName    string       `json:"nodename"`
+	Nodes       []string     `json:"nodes,omitempty"`
+	ParentName  string       `json:"parentName,omitempty"`
+	StartTime   string       `json:"start_time,omitempty"`
+	EndTime     string       `json:"end_time,omitempty"`
+	Interval    string       `json:"interval,omitempty"`
+	Aggregation *Aggregation `json:"aggregation,omitempty"`
+}
+
+// IsLatest tells if the query asks for the latest value of the series.
+func (q MetricQuery) IsLatest() bool {
+	return q.Interval == Latest
+}
+
+// Valid checks the query has a resource and a supported aggregation.
+func (q MetricQuery) Valid() error {
+	if q.Resource == "" {
+		return fmt.Errorf("Resource not specified")
+	}
+	if q.Aggregation != nil {
+		return q.Aggregation.Valid()
+	}
+	return nil
+}
+
+func (q MetricQuery) nodes() []string {
+	if len(q.Nodes) == 0 {
+		return []string{q.NodeName}
+	}
+	return q.Nodes
+}
+
+// NodeNames returns the nodes selected by the query with the dots in their
+// names replaced as in the series names.
+func (q MetricQuery) NodeNames() []string {
+	nodes := q.nodes()
+	names := make([]string, len(nodes))
+	for index, node := range nodes {
+		names[index] = EntityName(node)
+	}
+	return names
+}
+
+// NodeOf returns the node a series selected by the query belongs to, as
+// named by NodeNames. The series are matched against the names the series
+// of the nodes are pushed with.
+func (q MetricQuery) NodeOf(seriesName string) string {
+	name, err := ParseSeriesName(seriesName)
+	if err != nil {
+		return seriesName
+	}
+	for _, node := range q.nodes() {
+		pushed, ok := QueryName(name.Collection, q.ParentName, node, q.Resource)
+		if !ok || pushed.Entity != name.Entity {
+			continue
+		}
+		if q.ParentName == "" {
+			return EntityName(node)
+		}
+		if strings.HasPrefix(name.Resource, q.Resource) && strings.Contains(name.Resource, "_"+EntityName(node)) {
+			return EntityName(node)
+		}
+	}
+	return name.Entity
+}
+
+// GroupOf returns the group of a series as per the grouping of the query.
+func (q MetricQuery) GroupOf(seriesName string) string {
+	if q.Aggregation == nil {
+		return seriesName
+	}
+	switch q.Aggregation.GroupBy {
+	case GROUP_BY_NODE:
+		return q.NodeOf(seriesName)
+	case GROUP_BY_CLUSTER:
+		if q.ParentName != "" {
+			return q.ParentName
+		}
+		return GROUP_BY_CLUSTER
+	}
+	return seriesName
+}
+
+// AggregateSeries applies the aggregation of the query to the series within
+// the process. It is used by the backends lacking native functions for the
+// downsampling, if downsample is set, or for the grouping, if group is set.
+func (q MetricQuery) AggregateSeries(series []Series, downsample bool, group bool) ([]Series, error) {
+	if q.Aggregation == nil {
+		return series, nil
+	}
+	if downsample && !q.IsLatest() {
+		step, err := q.Aggregation.StepDuration()
+		if err != nil {
+			return nil, err
+		}
+		series = q.Aggregation.Downsample(series, step)
+	}
+	if group && q.Aggregation.GroupBy != "" {
+		series = q.Aggregation.Group(series, q.GroupOf)
+	}
+	return series, nil
+}
+
+// Params returns the query in the form of the untyped query parameters.
+func (q MetricQuery) Params() map[string]interface{} {
+	params := map[string]interface{}{
+		"resource":   q.Resource,
+		"nodename":   q.NodeName,
+		"start_time": q.StartTime,
+		"end_time":   q.EndTime,
+		"interval":   q.Interval,
+	}
+	if q.ParentName != "" {
+		params["parentName"] = q.ParentName
+	}
+	if len(q.Nodes) != 0 {
+		params["nodes"] = q.Nodes
+	}
+	return params
+}
+
+// MetricQueryFromParams builds the query from the untyped query parameters
+// resource, nodename, nodes, parentName, start_time, end_time, interval and
+// aggregation.
+func MetricQueryFromParams(params map[string]interface{}) (MetricQuery, error) {
+	var query MetricQuery
+	fields := map[string]*string{
+		"resource":   &query.Resource,
+		"nodename":   &query.NodeName,
+		"parentName": &query.ParentName,
+		"start_time": &query.StartTime,
+		"end_time":   &query.EndTime,
+		"interval":   &query.Interval,
+	}
+	for key, field := range fields {
+		value, ok := params[key]
+		if !ok || value == nil {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return MetricQuery{}, fmt.Errorf("Query parameter %s: %v is not a string", key, value)
+		}
+		*field = str
+	}
+	if query.Resource == "" {
+		return MetricQuery{}, fmt.Errorf("Resource not specified")
+	}
+	if nodes, ok := params["nodes"].([]string); ok {
+		query.Nodes = nodes
+	}
+	if aggregation, ok := params["aggregation"].(Aggregation); ok {
+		if err := aggregation.Valid(); err != nil {
+			return MetricQuery{}, err
+		}
+		query.Aggregation = &aggregation
+	}
+	return query, nil
+}
+
+// DataPoint is a value of a series at the unix timestamp. The timestamp of
+// the latest values is 0 for the backends not reporting it.
+type DataPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Series is a named series of data points ordered by time.
+type Series struct {
+	Name       string      `json:"name"`
+	DataPoints []DataPoint `json:"datapoints"`
+}
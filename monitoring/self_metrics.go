@@ -0,0 +1,33 @@
+package monitoring
+
+import (
+	"github.com/skyrings/skyring-common/tools/metrics"
+	"time"
+)
+
+var (
+	pushDurations = metrics.NewHistogram("skyring_monitoring_push_duration_seconds", "Durations of the pushes to the monitoring db.", nil, "manager")
+	pushedSamples = metrics.NewCounter("skyring_monitoring_pushed_samples_total", "Samples pushed to the monitoring db.", "manager")
+	pushErrors    = metrics.NewCounter("skyring_monitoring_push_errors_total", "Failed pushes to the monitoring db.", "manager")
+)
+
+// SampleCount returns the number of values of the metrics in the form taken
+// by PushToDb.
+func SampleCount(metrics map[string]map[string]string) int {
+	count := 0
+	for _, valueMap := range metrics {
+		count += len(valueMap)
+	}
+	return count
+}
+
+// ObservePush records the push of the samples by the manager started at
+// start, to be deferred with the address of the error returned.
+func ObservePush(manager string, samples int, start time.Time, err *error) {
+	pushDurations.With(manager).ObserveSince(start)
+	if *err != nil {
+		pushErrors.With(manager).Inc()
+		return
+	}
+	pushedSamples.With(manager).Add(float64(samples))
+}
@@ -0,0 +1,165 @@
+package monitoring
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SystemThresholdPlugins returns the system wide plugins, the top of the
+// threshold hierarchy: the defaults of the clusters plus the system
+// defaults.
+func SystemThresholdPlugins() []Plugin {
+	plugins := GetDefaultThresholdValues()
+	systemPlugins := GetSystemDefaultThresholdValues()
+	var names []string
+	for name := range systemPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		plugins = append(plugins, systemPlugins[name])
+	}
+	return plugins
+}
+
+// copyPlugin returns a copy of the plugin not sharing its configs.
+func copyPlugin(plugin Plugin) Plugin {
+	configs := make([]PluginConfig, len(plugin.Configs))
+	copy(configs, plugin.Configs)
+	plugin.Configs = configs
+	return plugin
+}
+
+// mergePluginConfigs sets the configs on the current ones, replacing the
+// configs of the same category and type.
+func mergePluginConfigs(currentConfigs []PluginConfig, configs []PluginConfig) []PluginConfig {
+	for _, config := range configs {
+		replaced := false
+		for index, current := range currentConfigs {
+			if current.Category == config.Category && current.Type == config.Type {
+				currentConfigs[index] = config
+				replaced = true
+			}
+		}
+		if !replaced {
+			currentConfigs = append(currentConfigs, config)
+		}
+	}
+	return currentConfigs
+}
+
+// PluginOverride overrides, on an entity, the configs of a plugin it
+// inherits and, if Enable is given, its enablement.
+type PluginOverride struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Enable      *bool          `json:"enable,omitempty"`
+	Configs     []PluginConfig `json:"configs"`
+}
+
+// copyOverride returns a copy of the override not sharing its configs.
+func copyOverride(override PluginOverride) PluginOverride {
+	configs := make([]PluginConfig, len(override.Configs))
+	copy(configs, override.Configs)
+	override.Configs = configs
+	if override.Enable != nil {
+		enable := *override.Enable
+		override.Enable = &enable
+	}
+	return override
+}
+
+func getOverrideIndex(name string, overrides []PluginOverride) int {
+	for index, override := range overrides {
+		if override.Name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// ResolvePlugins resolves the threshold hierarchy, from the top level, as
+// the system, down to the cluster level. Every level inherits the plugins of
+// the levels above and overrides their configs of the same category and
+// type and their enablement. The plugins of the levels are not modified.
+func ResolvePlugins(levels ...[]Plugin) []Plugin {
+	var resolved []Plugin
+	for _, plugins := range levels {
+		for _, plugin := range plugins {
+			index := GetPluginIndex(plugin.Name, resolved)
+			if index == -1 {
+				resolved = append(resolved, copyPlugin(plugin))
+				continue
+			}
+			resolved[index].Enable = plugin.Enable
+			if plugin.Description != "" {
+				resolved[index].Description = plugin.Description
+			}
+			resolved[index].Configs = mergePluginConfigs(resolved[index].Configs, plugin.Configs)
+		}
+	}
+	return resolved
+}
+
+// ApplyPluginsOverrides resolves the plugins of an entity, as a node, a
+// storage or an SLU, from the plugins it inherits and its overrides. The
+// overrides of the plugins not inherited are ignored. The inherited plugins
+// are not modified.
+func ApplyPluginsOverrides(plugins []Plugin, overrides []PluginOverride) []Plugin {
+	resolved := ResolvePlugins(plugins)
+	for _, override := range overrides {
+		index := GetPluginIndex(override.Name, resolved)
+		if index == -1 {
+			continue
+		}
+		if override.Enable != nil {
+			resolved[index].Enable = *override.Enable
+		}
+		if override.Description != "" {
+			resolved[index].Description = override.Description
+		}
+		resolved[index].Configs = mergePluginConfigs(resolved[index].Configs, override.Configs)
+	}
+	return resolved
+}
+
+// UpdatePluginsOverrides sets the expected configs, and the enablement when
+// given, on the overrides of an entity. Only the plugins inherited by the
+// entity can be overridden.
+func UpdatePluginsOverrides(currentOverrides []PluginOverride, expectedOverrides []PluginOverride, inheritedPlugins []Plugin) ([]PluginOverride, error) {
+	var overrides []PluginOverride
+	for _, override := range currentOverrides {
+		overrides = append(overrides, copyOverride(override))
+	}
+	for _, eOverride := range expectedOverrides {
+		if GetPluginIndex(eOverride.Name, inheritedPlugins) == -1 {
+			return nil, fmt.Errorf("Cannot override plugin %s not inherited by the entity", eOverride.Name)
+		}
+		index := getOverrideIndex(eOverride.Name, overrides)
+		if index == -1 {
+			overrides = append(overrides, copyOverride(eOverride))
+			continue
+		}
+		if eOverride.Enable != nil {
+			enable := *eOverride.Enable
+			overrides[index].Enable = &enable
+		}
+		if eOverride.Description != "" {
+			overrides[index].Description = eOverride.Description
+		}
+		overrides[index].Configs = mergePluginConfigs(overrides[index].Configs, eOverride.Configs)
+	}
+	return overrides, nil
+}
+
+// RemovePluginsOverrides removes the overrides of the named plugins, so that
+// the entity inherits them again.
+func RemovePluginsOverrides(currentOverrides []PluginOverride, pluginNames []string) []PluginOverride {
+	var overrides []PluginOverride
+	for _, override := range currentOverrides {
+		if !Contains(override.Name, pluginNames) {
+			overrides = append(overrides, override)
+		}
+	}
+	return overrides
+}
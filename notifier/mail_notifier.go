@@ -21,6 +21,7 @@ import (
 	"github.com/skyrings/skyring-common/dbprovider"
 	"github.com/skyrings/skyring-common/models"
 	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/metrics"
 	"gopkg.in/mgo.v2/bson"
 	"net"
 	"net/smtp"
@@ -31,6 +32,8 @@ import (
 var client *smtp.Client
 var clientLock sync.Mutex
 
+var mails = metrics.NewCounter("skyring_mails_total", "Mails sent or failed to be sent.", "result")
+
 func setTLSMailClient(addr string, a smtp.Auth, skipVerify bool) error {
 	c, err := smtp.Dial(addr)
 
@@ -191,14 +194,17 @@ func MailNotify(subject string, body string, dbProvider dbprovider.DbInterface,
 		// retry once again after setting the client, as client might have timed out
 		if err := SetMailClient(notifier, ctxt); err != nil {
 			if err != nil {
+				mails.With("failed").Inc()
 				return err
 			}
 		}
 		if err = sendMail(notifier.MailId, recepients, msg); err != nil {
 			logger.Get().Error("%s-Could not Send the Mail Notification. Error: %v", ctxt, err)
+			mails.With("failed").Inc()
 			return err
 		}
 	}
+	mails.With("sent").Inc()
 	return nil
 }
 
@@ -220,14 +226,17 @@ func TestMailNotify(notifier models.MailNotifier, subject string, body string, r
 		if err := SetMailClient(notifier, ctxt); err != nil {
 			if err != nil {
 				logger.Get().Error("%s-Error setting the Mail Client Error: %v", ctxt, err)
+				mails.With("failed").Inc()
 				return err
 			}
 		}
 		if err := sendMail(notifier.MailId, recepient, msg); err != nil {
 			logger.Get().Error("%s-Could not Send the Mail Notification. Error: %v", ctxt, err)
+			mails.With("failed").Inc()
 			return err
 		}
 	}
+	mails.With("sent").Inc()
 	client = nil
 	return nil
 }
@@ -0,0 +1,246 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rollup
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"github.com/skyrings/skyring-common/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHourlyRetention = 31 * 24 * time.Hour
+	DefaultDailyRetention  = 2 * 365 * 24 * time.Hour
+)
+
+// Roller rolls the utilization of the clusters and of the system, as held
+// by their summaries, up into hourly and daily minimums, averages and
+// maximums. Every Run samples the summaries once, so the roll-ups are as
+// fine as the Run interval. The roll-ups older than their retention are
+// dropped.
+type Roller struct {
+	HourlyRetention time.Duration
+	DailyRetention  time.Duration
+	indexed         bool
+}
+
+// sample is the values of the summary of a cluster or of the system.
+type sample struct {
+	clusterId   uuid.UUID
+	name        string
+	usage       models.Utilization
+	objectCount map[string]int64
+	sluCount    map[string]int
+}
+
+func NewRoller() *Roller {
+	return &Roller{
+		HourlyRetention: DefaultHourlyRetention,
+		DailyRetention:  DefaultDailyRetention,
+	}
+}
+
+// periodStart returns the start of the period the time falls in, in UTC.
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	if period == models.ROLLUP_PERIOD_DAILY {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Run samples the summaries of all the clusters and of the system into
+// their roll-ups.
+func (r *Roller) Run(ctxt string) error {
+	now := time.Now()
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_UTILIZATION_ROLLUPS)
+	if !r.indexed {
+		index := mgo.Index{Key: []string{"clusterid", "period", "start"}, Unique: true}
+		if err := coll.EnsureIndex(index); err != nil {
+			logger.Get().Warning("%s - Failed to index the utilization roll-ups. Error: %v", ctxt, err)
+		} else {
+			r.indexed = true
+		}
+	}
+
+	summaries, err := util.GetClusterSummaries(nil)
+	if err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("%s - Failed to fetch the cluster summaries. Error: %v", ctxt, err)
+	}
+	var samples []sample
+	// The system summary carries no object count, it is the sum of those of
+	// the clusters
+	systemObjectCount := make(map[string]int64)
+	for _, summary := range summaries {
+		samples = append(samples, sample{
+			clusterId:   summary.ClusterId,
+			name:        summary.Name,
+			usage:       summary.Usage,
+			objectCount: summary.ObjectCount,
+			sluCount:    summary.SLUCount,
+		})
+		for key, count := range summary.ObjectCount {
+			systemObjectCount[key] += count
+		}
+	}
+	system, err := util.GetSystem()
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("%s - Failed to fetch the system summary. Error: %v", ctxt, err)
+	} else if err == nil {
+		samples = append(samples, sample{
+			name:        monitoring.SYSTEM,
+			usage:       system.Usage,
+			objectCount: systemObjectCount,
+			sluCount:    system.SLUCount,
+		})
+	}
+
+	var failed int
+	for _, current := range samples {
+		for _, period := range []string{models.ROLLUP_PERIOD_HOURLY, models.ROLLUP_PERIOD_DAILY} {
+			if err := current.record(coll, period, now); err != nil {
+				logger.Get().Error("%s - Failed to roll up the utilization of %s. Error: %v", ctxt, current.name, err)
+				failed++
+			}
+		}
+	}
+	r.prune(ctxt, coll, now)
+	if failed != 0 {
+		return fmt.Errorf("%s - Failed to update %d utilization roll-ups", ctxt, failed)
+	}
+	return nil
+}
+
+// fieldKey escapes the characters of the map keys mongo does not accept in
+// the field names.
+func fieldKey(key string) string {
+	return strings.NewReplacer(".", "_", "$", "_").Replace(key)
+}
+
+// observe adds the value to the update of the stats of the field.
+func observe(update bson.M, field string, value float64) {
+	update["$min"].(bson.M)[field+".min"] = value
+	update["$max"].(bson.M)[field+".max"] = value
+	update["$inc"].(bson.M)[field+".sum"] = value
+	update["$inc"].(bson.M)[field+".count"] = 1
+}
+
+// record adds the sample to the roll-up of the period it falls in. The
+// update is atomic, so the samples taken concurrently are all counted.
+func (s sample) record(coll *mgo.Collection, period string, now time.Time) error {
+	update := bson.M{
+		"$set": bson.M{"name": s.name, "updatedat": now},
+		"$min": bson.M{},
+		"$max": bson.M{},
+		"$inc": bson.M{},
+	}
+	observe(update, "usage", s.usage.PercentUsed)
+	observe(update, "used", float64(s.usage.Used))
+	observe(update, "total", float64(s.usage.Total))
+	for key, count := range s.objectCount {
+		observe(update, "objectcount."+fieldKey(key), float64(count))
+	}
+	for key, count := range s.sluCount {
+		observe(update, "slucount."+fieldKey(key), float64(count))
+	}
+	_, err := coll.Upsert(bson.M{"clusterid": s.clusterId, "period": period, "start": periodStart(period, now)}, update)
+	return err
+}
+
+// prune drops the roll-ups older than their retention.
+func (r *Roller) prune(ctxt string, coll *mgo.Collection, now time.Time) {
+	retentions := map[string]time.Duration{
+		models.ROLLUP_PERIOD_HOURLY: r.HourlyRetention,
+		models.ROLLUP_PERIOD_DAILY:  r.DailyRetention,
+	}
+	for period, retention := range retentions {
+		if retention <= 0 {
+			continue
+		}
+		if _, err := coll.RemoveAll(bson.M{"period": period, "start": bson.M{"$lt": now.Add(-retention)}}); err != nil {
+			logger.Get().Warning("%s - Failed to drop the %s utilization roll-ups older than %v. Error: %v", ctxt, period, retention, err)
+		}
+	}
+}
+
+func withAverage(stats models.RollupStats) models.RollupStats {
+	if stats.Count != 0 {
+		stats.Avg = stats.Sum / float64(stats.Count)
+	}
+	return stats
+}
+
+func withAverages(stats map[string]models.RollupStats) map[string]models.RollupStats {
+	for key, current := range stats {
+		stats[key] = withAverage(current)
+	}
+	return stats
+}
+
+// getRollups returns the roll-ups of the period of the cluster starting
+// between from and until, oldest first. The zero times leave the range open.
+func getRollups(clusterId uuid.UUID, period string, from time.Time, until time.Time) ([]models.UtilizationRollup, error) {
+	if period != models.ROLLUP_PERIOD_HOURLY && period != models.ROLLUP_PERIOD_DAILY {
+		return nil, fmt.Errorf("Unsupported roll-up period %s", period)
+	}
+	selector := bson.M{"clusterid": clusterId, "period": period}
+	start := bson.M{}
+	if !from.IsZero() {
+		start["$gte"] = periodStart(period, from)
+	}
+	if !until.IsZero() {
+		start["$lte"] = until
+	}
+	if len(start) != 0 {
+		selector["start"] = start
+	}
+
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_UTILIZATION_ROLLUPS)
+	var rollups []models.UtilizationRollup
+	if err := coll.Find(selector).Sort("start").All(&rollups); err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	for index := range rollups {
+		rollups[index].Usage = withAverage(rollups[index].Usage)
+		rollups[index].Used = withAverage(rollups[index].Used)
+		rollups[index].Total = withAverage(rollups[index].Total)
+		rollups[index].ObjectCount = withAverages(rollups[index].ObjectCount)
+		rollups[index].SLUCount = withAverages(rollups[index].SLUCount)
+	}
+	return rollups, nil
+}
+
+// GetClusterRollups returns the hourly or daily roll-ups of the cluster
+// between from and until, oldest first.
+func GetClusterRollups(clusterId uuid.UUID, period string, from time.Time, until time.Time) ([]models.UtilizationRollup, error) {
+	return getRollups(clusterId, period, from, until)
+}
+
+// GetSystemRollups returns the hourly or daily roll-ups of the system
+// between from and until, oldest first.
+func GetSystemRollups(period string, from time.Time, until time.Time) ([]models.UtilizationRollup, error) {
+	return getRollups(uuid.UUID{}, period, from, until)
+}
@@ -0,0 +1,208 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package summary
+
+import (
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/uuid"
+)
+
+// Fields of the summaries holding the status wise counts
+const (
+	SLU_COUNT      = "slucount"
+	STORAGE_COUNT  = "storagecount"
+	NODES_COUNT    = "nodescount"
+	CLUSTERS_COUNT = "clusterscount"
+)
+
+// Delta is a change of the status wise counts of the Field of the
+// summaries. The slu and storage counts change the summary of the cluster
+// and the system summary, the others just the system summary.
+type Delta struct {
+	ClusterId uuid.UUID
+	Field     string
+	Counts    map[string]int
+}
+
+// IsEmpty tells if the delta changes no count.
+func (d Delta) IsEmpty() bool {
+	return len(d.Counts) == 0
+}
+
+func SluCounts(slus []models.StorageLogicalUnit, nearFull int) map[string]int {
+	counts := map[string]int{
+		models.TOTAL: len(slus),
+		models.SluStatuses[models.SLU_STATUS_UNKNOWN]: 0,
+		models.SluStatuses[models.SLU_STATUS_WARN]:    0,
+		models.SluStatuses[models.SLU_STATUS_ERROR]:   0,
+		models.SluStatuses[models.SLU_STATUS_OK]:      0,
+		models.NEAR_FULL:                              nearFull,
+		models.CriticalAlerts:                         0,
+	}
+	for _, slu := range slus {
+		counts[models.CriticalAlerts] += slu.AlmCritCount
+		switch slu.Status {
+		case models.SLU_STATUS_ERROR, models.SLU_STATUS_WARN, models.SLU_STATUS_OK, models.SLU_STATUS_UNKNOWN:
+			counts[models.SluStatuses[slu.Status]]++
+		}
+	}
+	return counts
+}
+
+func StorageCounts(storages []models.Storage) map[string]int {
+	counts := map[string]int{models.TOTAL: len(storages), models.STATUS_DOWN: 0, models.CriticalAlerts: 0}
+	for _, storage := range storages {
+		counts[models.CriticalAlerts] += storage.AlmCritCount
+		if storage.Status == models.STORAGE_STATUS_ERROR {
+			counts[models.STATUS_DOWN]++
+		}
+	}
+	return counts
+}
+
+func NodeCounts(nodes []models.Node) map[string]int {
+	counts := map[string]int{
+		models.TOTAL: len(nodes),
+		models.NodeStatuses[models.NODE_STATUS_ERROR]:   0,
+		models.NodeStates[models.NODE_STATE_UNACCEPTED]: 0,
+		models.CriticalAlerts:                           0,
+	}
+	for _, node := range nodes {
+		counts[models.CriticalAlerts] += node.AlmCritCount
+		if node.Status == models.NODE_STATUS_ERROR {
+			counts[models.NodeStatuses[models.NODE_STATUS_ERROR]]++
+		}
+		if node.State == models.NODE_STATE_UNACCEPTED {
+			counts[models.NodeStates[models.NODE_STATE_UNACCEPTED]]++
+		}
+	}
+	return counts
+}
+
+func ClusterCounts(clusters []models.Cluster, nearFull int) map[string]int {
+	counts := map[string]int{
+		models.TOTAL: len(clusters),
+		models.ClusterStatuses[models.CLUSTER_STATUS_ERROR]: 0,
+		models.ClusterStatuses[models.CLUSTER_STATUS_WARN]:  0,
+		models.NEAR_FULL:      nearFull,
+		models.CriticalAlerts: 0,
+	}
+	for _, cluster := range clusters {
+		counts[models.CriticalAlerts] += cluster.AlmCritCount
+		if cluster.Status == models.CLUSTER_STATUS_ERROR {
+			counts[models.ClusterStatuses[models.CLUSTER_STATUS_ERROR]]++
+		} else if cluster.Status == models.CLUSTER_STATUS_WARN {
+			counts[models.ClusterStatuses[models.CLUSTER_STATUS_WARN]]++
+		}
+	}
+	return counts
+}
+
+// diff returns the counts of after less those of before, leaving out the
+// unchanged ones.
+func diff(before map[string]int, after map[string]int) map[string]int {
+	counts := make(map[string]int)
+	for key, value := range after {
+		if change := value - before[key]; change != 0 {
+			counts[key] = change
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok && value != 0 {
+			counts[key] = -value
+		}
+	}
+	return counts
+}
+
+// The deltas of the changes of the entities take the entity before and
+// after the change, nil for the entities created or deleted.
+
+func SluDelta(before *models.StorageLogicalUnit, after *models.StorageLogicalUnit) Delta {
+	var befores, afters []models.StorageLogicalUnit
+	delta := Delta{Field: SLU_COUNT}
+	if before != nil {
+		befores = append(befores, *before)
+		delta.ClusterId = before.ClusterId
+	}
+	if after != nil {
+		afters = append(afters, *after)
+		delta.ClusterId = after.ClusterId
+	}
+	delta.Counts = diff(SluCounts(befores, 0), SluCounts(afters, 0))
+	return delta
+}
+
+func StorageDelta(before *models.Storage, after *models.Storage) Delta {
+	var befores, afters []models.Storage
+	delta := Delta{Field: STORAGE_COUNT}
+	if before != nil {
+		befores = append(befores, *before)
+		delta.ClusterId = before.ClusterId
+	}
+	if after != nil {
+		afters = append(afters, *after)
+		delta.ClusterId = after.ClusterId
+	}
+	delta.Counts = diff(StorageCounts(befores), StorageCounts(afters))
+	return delta
+}
+
+func NodeDelta(before *models.Node, after *models.Node) Delta {
+	var befores, afters []models.Node
+	if before != nil {
+		befores = append(befores, *before)
+	}
+	if after != nil {
+		afters = append(afters, *after)
+	}
+	return Delta{Field: NODES_COUNT, Counts: diff(NodeCounts(befores), NodeCounts(afters))}
+}
+
+func ClusterDelta(before *models.Cluster, after *models.Cluster) Delta {
+	var befores, afters []models.Cluster
+	if before != nil {
+		befores = append(befores, *before)
+	}
+	if after != nil {
+		afters = append(afters, *after)
+	}
+	return Delta{Field: CLUSTERS_COUNT, Counts: diff(ClusterCounts(befores, 0), ClusterCounts(afters, 0))}
+}
+
+// AlarmDelta is the change of the critical alerts of an entity counted in
+// the field.
+func AlarmDelta(field string, clusterId uuid.UUID, before int, after int) Delta {
+	return Delta{ClusterId: clusterId, Field: field, Counts: diff(map[string]int{models.CriticalAlerts: before}, map[string]int{models.CriticalAlerts: after})}
+}
+
+// ThresholdDelta is the change of the near full counts when the critical
+// utilization threshold breach of a cluster or an slu is raised or cleared.
+func ThresholdDelta(tEvent models.ThresholdEvent, wasCritical bool, isCritical bool) Delta {
+	delta := Delta{ClusterId: tEvent.ClusterId, Counts: make(map[string]int)}
+	switch tEvent.UtilizationType {
+	case monitoring.SLU_UTILIZATION:
+		delta.Field = SLU_COUNT
+	case monitoring.CLUSTER_UTILIZATION:
+		delta.Field = CLUSTERS_COUNT
+	default:
+		return delta
+	}
+	if isCritical && !wasCritical {
+		delta.Counts[models.NEAR_FULL] = 1
+	} else if wasCritical && !isCritical {
+		delta.Counts[models.NEAR_FULL] = -1
+	}
+	return delta
+}
@@ -0,0 +1,144 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package summary
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The nodes, slus, storages and clusters are to be created, updated and
+// deleted through the functions below, which publish the changes of the
+// status wise counts along with the writes. The entities written otherwise
+// are only counted by the next reconcile.
+
+// replace reads the entity selected into before and replaces it with
+// after, or removes it if after is nil, in a single operation so that the
+// concurrent writes of an entity publish consecutive deltas. It returns
+// false if the entity was not there before.
+func replace(collection string, selector bson.M, before interface{}, after interface{}) (bool, error) {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(collection)
+	if after == nil {
+		if _, err := coll.Find(selector).Apply(mgo.Change{Remove: true}, before); err != nil {
+			if err == mgo.ErrNotFound {
+				return false, nil
+			}
+			return false, fmt.Errorf("Failed to remove the entity %v of %s. Error: %v", selector, collection, err)
+		}
+		return true, nil
+	}
+	info, err := coll.Find(selector).Apply(mgo.Change{Update: after, Upsert: true, ReturnNew: false}, before)
+	if err != nil {
+		return false, fmt.Errorf("Failed to save the entity %v of %s. Error: %v", selector, collection, err)
+	}
+	return info.Updated > 0, nil
+}
+
+func SaveNode(node models.Node) error {
+	var before models.Node
+	found, err := replace(models.COLL_NAME_STORAGE_NODES, bson.M{"nodeid": node.NodeId}, &before, node)
+	if err != nil {
+		return err
+	}
+	if found {
+		Publish(NodeDelta(&before, &node))
+	} else {
+		Publish(NodeDelta(nil, &node))
+	}
+	return nil
+}
+
+func RemoveNode(nodeId uuid.UUID) error {
+	var before models.Node
+	found, err := replace(models.COLL_NAME_STORAGE_NODES, bson.M{"nodeid": nodeId}, &before, nil)
+	if found && err == nil {
+		Publish(NodeDelta(&before, nil))
+	}
+	return err
+}
+
+func SaveSlu(slu models.StorageLogicalUnit) error {
+	var before models.StorageLogicalUnit
+	found, err := replace(models.COLL_NAME_STORAGE_LOGICAL_UNITS, bson.M{"sluid": slu.SluId}, &before, slu)
+	if err != nil {
+		return err
+	}
+	if found {
+		Publish(SluDelta(&before, &slu))
+	} else {
+		Publish(SluDelta(nil, &slu))
+	}
+	return nil
+}
+
+func RemoveSlu(sluId uuid.UUID) error {
+	var before models.StorageLogicalUnit
+	found, err := replace(models.COLL_NAME_STORAGE_LOGICAL_UNITS, bson.M{"sluid": sluId}, &before, nil)
+	if found && err == nil {
+		Publish(SluDelta(&before, nil))
+	}
+	return err
+}
+
+func SaveStorage(storage models.Storage) error {
+	var before models.Storage
+	found, err := replace(models.COLL_NAME_STORAGE, bson.M{"storageid": storage.StorageId}, &before, storage)
+	if err != nil {
+		return err
+	}
+	if found {
+		Publish(StorageDelta(&before, &storage))
+	} else {
+		Publish(StorageDelta(nil, &storage))
+	}
+	return nil
+}
+
+func RemoveStorage(storageId uuid.UUID) error {
+	var before models.Storage
+	found, err := replace(models.COLL_NAME_STORAGE, bson.M{"storageid": storageId}, &before, nil)
+	if found && err == nil {
+		Publish(StorageDelta(&before, nil))
+	}
+	return err
+}
+
+func SaveCluster(cluster models.Cluster) error {
+	var before models.Cluster
+	found, err := replace(models.COLL_NAME_STORAGE_CLUSTERS, bson.M{"clusterid": cluster.ClusterId}, &before, cluster)
+	if err != nil {
+		return err
+	}
+	if found {
+		Publish(ClusterDelta(&before, &cluster))
+	} else {
+		Publish(ClusterDelta(nil, &cluster))
+	}
+	return nil
+}
+
+func RemoveCluster(clusterId uuid.UUID) error {
+	var before models.Cluster
+	found, err := replace(models.COLL_NAME_STORAGE_CLUSTERS, bson.M{"clusterid": clusterId}, &before, nil)
+	if found && err == nil {
+		Publish(ClusterDelta(&before, nil))
+	}
+	return err
+}
@@ -0,0 +1,349 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package summary
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultFlushInterval     = 5 * time.Second
+	DefaultReconcileInterval = time.Hour
+	DefaultQueueSize         = 10000
+)
+
+// Summarizer maintains the status wise counts of the cluster summaries and
+// of the system summary from the deltas published on the changes of the
+// nodes, slus, storages, clusters, alarms and threshold breaches. The deltas
+// are coalesced and applied every FlushInterval, with a single update per
+// summary changed, and all the counts are recomputed every
+// ReconcileInterval, or soon after a delta could not be applied, as a safety
+// net against the changes not published.
+//
+// The counts recomputed include the changes published before the reconcile,
+// whose deltas are then dropped rather than counted twice. A change written
+// just before the reconcile and published just after may still be counted
+// twice, until the next reconcile.
+type Summarizer struct {
+	// The sequence of the deltas published, accessed atomically and first
+	// for its alignment
+	published uint64
+
+	FlushInterval     time.Duration
+	ReconcileInterval time.Duration
+	QueueSize         int
+
+	deltas    chan queuedDelta
+	reconcile chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	// The sequence of the last delta published before the last reconcile
+	fence uint64
+}
+
+// queuedDelta is a delta with the sequence it was published with.
+type queuedDelta struct {
+	Delta
+	seq uint64
+}
+
+var (
+	summarizerMutex sync.RWMutex
+	summarizer      *Summarizer
+)
+
+func NewSummarizer() *Summarizer {
+	return &Summarizer{
+		FlushInterval:     DefaultFlushInterval,
+		ReconcileInterval: DefaultReconcileInterval,
+		QueueSize:         DefaultQueueSize,
+	}
+}
+
+// Start reconciles the summaries and starts applying the deltas published.
+// Only one summarizer may run at a time.
+func (s *Summarizer) Start() error {
+	summarizerMutex.Lock()
+	defer summarizerMutex.Unlock()
+	if summarizer != nil {
+		return fmt.Errorf("A summarizer is already running")
+	}
+	if s.FlushInterval <= 0 || s.ReconcileInterval <= 0 {
+		return fmt.Errorf("Invalid summarizer intervals flush %v and reconcile %v", s.FlushInterval, s.ReconcileInterval)
+	}
+	queueSize := s.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	s.deltas = make(chan queuedDelta, queueSize)
+	s.reconcile = make(chan struct{}, 1)
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	// Reconcile first, the summaries may have missed changes while no
+	// summarizer was running
+	s.requestReconcile()
+	go s.run(fmt.Sprintf("%v:summarizer", models.ENGINE_NAME))
+	summarizer = s
+	return nil
+}
+
+// Stop applies the deltas pending and stops the summarizer.
+func (s *Summarizer) Stop() {
+	summarizerMutex.Lock()
+	if summarizer != s {
+		summarizerMutex.Unlock()
+		return
+	}
+	summarizer = nil
+	summarizerMutex.Unlock()
+	close(s.stop)
+	<-s.done
+}
+
+// Publish queues the deltas to the running summarizer, if any. The deltas
+// are dropped when the queue is full, and the summaries are then reconciled
+// instead.
+func Publish(deltas ...Delta) {
+	summarizerMutex.RLock()
+	defer summarizerMutex.RUnlock()
+	if summarizer == nil {
+		return
+	}
+	for _, delta := range deltas {
+		if delta.IsEmpty() {
+			continue
+		}
+		select {
+		case summarizer.deltas <- queuedDelta{Delta: delta, seq: atomic.AddUint64(&summarizer.published, 1)}:
+		default:
+			summarizer.requestReconcile()
+		}
+	}
+}
+
+func (s *Summarizer) requestReconcile() {
+	select {
+	case s.reconcile <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Summarizer) run(ctxt string) {
+	defer close(s.done)
+	flushTicker := time.NewTicker(s.FlushInterval)
+	defer flushTicker.Stop()
+	reconcileTicker := time.NewTicker(s.ReconcileInterval)
+	defer reconcileTicker.Stop()
+	current := newPending()
+	for {
+		select {
+		case queued := <-s.deltas:
+			s.add(current, queued)
+		case <-flushTicker.C:
+			current = s.flush(ctxt, current)
+		case <-reconcileTicker.C:
+			s.requestReconcile()
+		case <-s.reconcile:
+			// The reconcile counts the changes published so far, the
+			// deltas pending and queued are dropped
+			s.fence = atomic.LoadUint64(&s.published)
+			current = newPending()
+			if err := Reconcile(ctxt); err != nil {
+				logger.Get().Error("%s - Failed to reconcile the summaries. Error: %v", ctxt, err)
+			}
+		case <-s.stop:
+			s.flush(ctxt, s.drain(current))
+			return
+		}
+	}
+}
+
+// add adds the delta queued to the pending ones, unless it was published
+// before the last reconcile.
+func (s *Summarizer) add(current *pending, queued queuedDelta) {
+	if queued.seq > s.fence {
+		current.add(queued.Delta)
+	}
+}
+
+// drain adds the deltas queued to the pending ones.
+func (s *Summarizer) drain(current *pending) *pending {
+	for {
+		select {
+		case queued := <-s.deltas:
+			s.add(current, queued)
+		default:
+			return current
+		}
+	}
+}
+
+// pending is the deltas coalesced per summary, as the increments of the
+// counts keyed by their field paths.
+type pending struct {
+	clusters map[uuid.UUID]map[string]int
+	system   map[string]int
+}
+
+func newPending() *pending {
+	return &pending{clusters: make(map[uuid.UUID]map[string]int), system: make(map[string]int)}
+}
+
+func (p *pending) add(delta Delta) {
+	for key, change := range delta.Counts {
+		path := delta.Field + "." + key
+		p.system[path] += change
+		if (delta.Field != SLU_COUNT && delta.Field != STORAGE_COUNT) || delta.ClusterId.IsZero() {
+			continue
+		}
+		if _, ok := p.clusters[delta.ClusterId]; !ok {
+			p.clusters[delta.ClusterId] = make(map[string]int)
+		}
+		p.clusters[delta.ClusterId][path] += change
+	}
+}
+
+func increments(changes map[string]int) bson.M {
+	inc := bson.M{}
+	for path, change := range changes {
+		if change != 0 {
+			inc[path] = change
+		}
+	}
+	return inc
+}
+
+// flush applies the pending deltas and returns the deltas to be applied
+// next. A summary not found is left alone, it gets all its counts when
+// initialized. The summaries that failed to update are reconciled.
+func (s *Summarizer) flush(ctxt string, current *pending) *pending {
+	if len(current.clusters) == 0 && len(current.system) == 0 {
+		return current
+	}
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	database := sessionCopy.DB(conf.SystemConfig.DBConfig.Database)
+	failed := false
+	for clusterId, changes := range current.clusters {
+		inc := increments(changes)
+		if len(inc) == 0 {
+			continue
+		}
+		if err := database.C(models.COLL_NAME_CLUSTER_SUMMARY).Update(bson.M{"clusterid": clusterId}, bson.M{"$inc": inc}); err != nil && err != mgo.ErrNotFound {
+			logger.Get().Error("%s - Failed to update the summary of cluster %v. Error: %v", ctxt, clusterId, err)
+			failed = true
+		}
+	}
+	if inc := increments(current.system); len(inc) != 0 {
+		if err := database.C(models.COLL_NAME_SKYRING_UTILIZATION).Update(bson.M{"name": monitoring.SYSTEM}, bson.M{"$inc": inc}); err != nil && err != mgo.ErrNotFound {
+			logger.Get().Error("%s - Failed to update the system summary. Error: %v", ctxt, err)
+			failed = true
+		}
+	}
+	if failed {
+		s.requestReconcile()
+	}
+	return newPending()
+}
+
+// Reconcile recomputes the status wise counts of all the summaries, fetching
+// each of the collections involved once.
+func Reconcile(ctxt string) error {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	database := sessionCopy.DB(conf.SystemConfig.DBConfig.Database)
+
+	var clusters []models.Cluster
+	if err := database.C(models.COLL_NAME_STORAGE_CLUSTERS).Find(nil).Select(bson.M{"clusterid": 1, "status": 1, "almcritcount": 1}).All(&clusters); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to fetch the clusters. Error: %v", err)
+	}
+	var slus []models.StorageLogicalUnit
+	if err := database.C(models.COLL_NAME_STORAGE_LOGICAL_UNITS).Find(nil).Select(bson.M{"clusterid": 1, "status": 1, "almcritcount": 1}).All(&slus); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to fetch the slus. Error: %v", err)
+	}
+	var storages []models.Storage
+	if err := database.C(models.COLL_NAME_STORAGE).Find(nil).Select(bson.M{"clusterid": 1, "status": 1, "almcritcount": 1}).All(&storages); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to fetch the storages. Error: %v", err)
+	}
+	var nodes []models.Node
+	if err := database.C(models.COLL_NAME_STORAGE_NODES).Find(nil).Select(bson.M{"status": 1, "state": 1, "almcritcount": 1}).All(&nodes); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to fetch the nodes. Error: %v", err)
+	}
+	var tEvents []models.ThresholdEvent
+	if err := database.C(models.COLL_NAME_THRESHOLD_BREACHES).Find(bson.M{
+		"utilizationtype":   bson.M{"$in": []string{monitoring.SLU_UTILIZATION, monitoring.CLUSTER_UTILIZATION}},
+		"thresholdseverity": models.CRITICAL,
+	}).All(&tEvents); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to fetch the threshold breaches. Error: %v", err)
+	}
+
+	clusterSlus := make(map[uuid.UUID][]models.StorageLogicalUnit)
+	for _, slu := range slus {
+		clusterSlus[slu.ClusterId] = append(clusterSlus[slu.ClusterId], slu)
+	}
+	clusterStorages := make(map[uuid.UUID][]models.Storage)
+	for _, storage := range storages {
+		clusterStorages[storage.ClusterId] = append(clusterStorages[storage.ClusterId], storage)
+	}
+	nearFullSlus := make(map[uuid.UUID]int)
+	nearFullClusterIds := make(map[uuid.UUID]bool)
+	var systemNearFullSlus int
+	for _, tEvent := range tEvents {
+		if tEvent.UtilizationType == monitoring.SLU_UTILIZATION {
+			nearFullSlus[tEvent.ClusterId]++
+			systemNearFullSlus++
+		} else {
+			nearFullClusterIds[tEvent.EntityId] = true
+		}
+	}
+
+	var failed int
+	nearFullClusters := 0
+	for _, cluster := range clusters {
+		if nearFullClusterIds[cluster.ClusterId] {
+			nearFullClusters++
+		}
+		update := bson.M{
+			SLU_COUNT:     SluCounts(clusterSlus[cluster.ClusterId], nearFullSlus[cluster.ClusterId]),
+			STORAGE_COUNT: StorageCounts(clusterStorages[cluster.ClusterId]),
+		}
+		if err := database.C(models.COLL_NAME_CLUSTER_SUMMARY).Update(bson.M{"clusterid": cluster.ClusterId}, bson.M{"$set": update}); err != nil && err != mgo.ErrNotFound {
+			logger.Get().Error("%s - Failed to reconcile the summary of cluster %v. Error: %v", ctxt, cluster.ClusterId, err)
+			failed++
+		}
+	}
+	update := bson.M{
+		SLU_COUNT:      SluCounts(slus, systemNearFullSlus),
+		STORAGE_COUNT:  StorageCounts(storages),
+		NODES_COUNT:    NodeCounts(nodes),
+		CLUSTERS_COUNT: ClusterCounts(clusters, nearFullClusters),
+	}
+	if err := database.C(models.COLL_NAME_SKYRING_UTILIZATION).Update(bson.M{"name": monitoring.SYSTEM}, bson.M{"$set": update}); err != nil && err != mgo.ErrNotFound {
+		return fmt.Errorf("Failed to reconcile the system summary. Error: %v", err)
+	}
+	if failed != 0 {
+		return fmt.Errorf("Failed to reconcile the summaries of %d clusters", failed)
+	}
+	return nil
+}
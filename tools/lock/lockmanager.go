@@ -17,8 +17,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/metrics"
 	"github.com/skyrings/skyring-common/tools/uuid"
 	"sync"
+	"time"
 )
 
 type Manager struct {
@@ -40,12 +42,26 @@ type LockManager interface {
 
 var lockMutex sync.Mutex
 
+var (
+	locksHeld     = metrics.NewGauge("skyring_locks_held", "Locks held.")
+	mutexWaits    = metrics.NewHistogram("skyring_lock_manager_mutex_wait_seconds", "Time waited for the lock manager mutex, not for the locks, which are refused when held.", nil)
+	lockConflicts = metrics.NewCounter("skyring_lock_conflicts_total", "Locks refused as held by others.")
+)
+
+// waitLockMutex locks the lock manager, recording the time waited for the
+// other callers of the lock manager.
+func waitLockMutex() {
+	start := time.Now()
+	lockMutex.Lock()
+	mutexWaits.With().ObserveSince(start)
+}
+
 func NewLockManager() *Manager {
 	return &Manager{make(map[uuid.UUID]*LockInternal)}
 }
 
 func (manager *Manager) AcquireLock(ctxt string, appLock AppLock) error {
-	lockMutex.Lock()
+	waitLockMutex()
 	defer lockMutex.Unlock()
 	//Check lock can be acquired for all the nodes. if not
 	//return error
@@ -53,6 +69,7 @@ func (manager *Manager) AcquireLock(ctxt string, appLock AppLock) error {
 		//check if the lock exists
 		if val, ok := manager.locks[k]; ok {
 			//Lock already aquired return from here
+			lockConflicts.With().Inc()
 			err := fmt.Sprintf("Unable to Acquire the lock for %v Message %s ", k, val.GetMessages())
 			logger.Get().Error("%s-Unable to Acquire the lock for: %v", ctxt, k)
 			return errors.New(err)
@@ -65,12 +82,13 @@ func (manager *Manager) AcquireLock(ctxt string, appLock AppLock) error {
 		logger.Get().Debug("%s-Lock Acquired for: %v", ctxt, k)
 		manager.locks[k] = NewLockInternal(v)
 	}
+	locksHeld.With().Set(float64(len(manager.locks)))
 	logger.Get().Debug("%s-Currently Locked: %v", ctxt, manager.locks)
 	return nil
 }
 
 func (manager *Manager) ReleaseLock(ctxt string, appLock AppLock) {
-	lockMutex.Lock()
+	waitLockMutex()
 	defer lockMutex.Unlock()
 	logger.Get().Debug("%s-Currently Locked: %v", ctxt, manager.locks)
 	logger.Get().Debug("%s-Releasing the locks for: %v", ctxt, appLock.GetAppLocks())
@@ -83,13 +101,15 @@ func (manager *Manager) ReleaseLock(ctxt string, appLock AppLock) {
 		logger.Get().Debug("%s-Lock Released: %v", ctxt, k)
 		delete(manager.locks, k)
 	}
+	locksHeld.With().Set(float64(len(manager.locks)))
 	logger.Get().Debug("%s-Currently Locked: %v", ctxt, manager.locks)
 }
 
 func (manager *Manager) Clear() {
-	lockMutex.Lock()
+	waitLockMutex()
 	defer lockMutex.Unlock()
 	for k := range manager.locks {
 		delete(manager.locks, k)
 	}
+	locksHeld.With().Set(0)
 }
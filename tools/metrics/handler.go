@@ -0,0 +1,32 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"io"
+	"net/http"
+)
+
+// ServeHTTP exposes the metrics of the registry in the prometheus text
+// format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, r.Expose())
+}
+
+// Handler returns the handler exposing the metrics of the DefaultRegistry,
+// to be mounted by the application, as on /metrics.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
@@ -0,0 +1,335 @@
+/*Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics keeps the numbers the library reports about itself:
+// counters, gauges and histograms, optionally split by labels, exposed in
+// the prometheus text format by Handler.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KIND_COUNTER   = "counter"
+	KIND_GAUGE     = "gauge"
+	KIND_HISTOGRAM = "histogram"
+)
+
+// The buckets of the durations in seconds
+var DefaultDurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60, 300, 1800}
+
+type Counter struct {
+	mutex sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds the delta, which is ignored if negative as counters only grow.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value += delta
+}
+
+func (c *Counter) Value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+type Gauge struct {
+	mutex sync.Mutex
+	value float64
+}
+
+func (g *Gauge) Set(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value = value
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value += delta
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+// Histogram counts the observed values in cumulative buckets.
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for index, bound := range h.buckets {
+		if value <= bound {
+			h.counts[index]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.sum
+}
+
+// family is a metric with its instances, one per set of label values.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	mutex     sync.Mutex
+	instances map[string]interface{}
+	labels    map[string][]string
+}
+
+func (f *family) with(labelValues []string) interface{} {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("Metric %s takes %d label values, %d given", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if instance, ok := f.instances[key]; ok {
+		return instance
+	}
+	var instance interface{}
+	switch f.kind {
+	case KIND_COUNTER:
+		instance = &Counter{}
+	case KIND_GAUGE:
+		instance = &Gauge{}
+	case KIND_HISTOGRAM:
+		instance = newHistogram(f.buckets)
+	}
+	f.instances[key] = instance
+	f.labels[key] = append([]string(nil), labelValues...)
+	return instance
+}
+
+type CounterVec struct {
+	family *family
+}
+
+// With returns the counter of the label values, in the order of the label
+// names the counter was registered with.
+func (v CounterVec) With(labelValues ...string) *Counter {
+	return v.family.with(labelValues).(*Counter)
+}
+
+type GaugeVec struct {
+	family *family
+}
+
+func (v GaugeVec) With(labelValues ...string) *Gauge {
+	return v.family.with(labelValues).(*Gauge)
+}
+
+type HistogramVec struct {
+	family *family
+}
+
+func (v HistogramVec) With(labelValues ...string) *Histogram {
+	return v.family.with(labelValues).(*Histogram)
+}
+
+// Registry holds the metrics by name. Registering a name again returns the
+// metric registered first, so that the packages can register their metrics
+// independently.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+var DefaultRegistry = NewRegistry()
+
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labelNames []string) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if existing, ok := r.families[name]; ok {
+		if existing.kind != kind || len(existing.labelNames) != len(labelNames) {
+			panic(fmt.Sprintf("Metric %s already registered as a %s with labels %v", name, existing.kind, existing.labelNames))
+		}
+		return existing
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		instances:  make(map[string]interface{}),
+		labels:     make(map[string][]string),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) CounterVec {
+	return CounterVec{r.register(name, help, KIND_COUNTER, nil, labelNames)}
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) GaugeVec {
+	return GaugeVec{r.register(name, help, KIND_GAUGE, nil, labelNames)}
+}
+
+// NewHistogram registers the histogram with the upper bounds of its
+// buckets, DefaultDurationBuckets if none are given.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return HistogramVec{r.register(name, help, KIND_HISTOGRAM, sorted, labelNames)}
+}
+
+func NewCounter(name string, help string, labelNames ...string) CounterVec {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+func NewGauge(name string, help string, labelNames ...string) GaugeVec {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) HistogramVec {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	var pairs []string
+	for index, name := range names {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[index])+`"`)
+	}
+	for index := 0; index+1 < len(extra); index += 2 {
+		pairs = append(pairs, extra[index]+`="`+extra[index+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Expose returns the metrics in the prometheus text format, sorted by name
+// and labels.
+func (r *Registry) Expose() string {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.mutex.Lock()
+		keys := make([]string, 0, len(f.instances))
+		for key := range f.instances {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, strings.Replace(f.help, "\n", " ", -1), f.name, f.kind)
+		for _, key := range keys {
+			labels := f.labels[key]
+			switch instance := f.instances[key].(type) {
+			case *Counter:
+				fmt.Fprintf(&buf, "%s%s %s\n", f.name, formatLabels(f.labelNames, labels), formatValue(instance.Value()))
+			case *Gauge:
+				fmt.Fprintf(&buf, "%s%s %s\n", f.name, formatLabels(f.labelNames, labels), formatValue(instance.Value()))
+			case *Histogram:
+				instance.mutex.Lock()
+				for index, bound := range instance.buckets {
+					fmt.Fprintf(&buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, labels, "le", formatValue(bound)), instance.counts[index])
+				}
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, labels, "le", "+Inf"), instance.count)
+				fmt.Fprintf(&buf, "%s_sum%s %s\n", f.name, formatLabels(f.labelNames, labels), formatValue(instance.sum))
+				fmt.Fprintf(&buf, "%s_count%s %d\n", f.name, formatLabels(f.labelNames, labels), instance.count)
+				instance.mutex.Unlock()
+			}
+		}
+		f.mutex.Unlock()
+	}
+	return buf.String()
+}
@@ -0,0 +1,38 @@
+package schedule
+
+import (
+	"fmt"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"sync"
+)
+
+type ScheduleCallback func(map[string]interface{})
+
+var (
+	callbacksMutex sync.Mutex
+	callbacks      = make(map[string]ScheduleCallback)
+)
+
+// RegisterCallback registers a function that persistent schedules can refer
+// to by name. Callbacks have to be registered before InitShechuleManager is
+// invoked so that the schedules persisted earlier can be resumed.
+func RegisterCallback(name string, f ScheduleCallback) {
+	callbacksMutex.Lock()
+	defer callbacksMutex.Unlock()
+
+	if _, found := callbacks[name]; found {
+		logger.Get().Warning("Schedule callback %s registered twice", name)
+	}
+	callbacks[name] = f
+}
+
+func GetCallback(name string) (ScheduleCallback, error) {
+	callbacksMutex.Lock()
+	defer callbacksMutex.Unlock()
+
+	f, found := callbacks[name]
+	if !found {
+		return nil, fmt.Errorf("Schedule callback %s not registered", name)
+	}
+	return f, nil
+}
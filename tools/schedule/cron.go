@@ -0,0 +1,273 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSpec is a parsed standard five field cron expression
+// (minute hour day-of-month month day-of-week) evaluated in Location.
+type CronSpec struct {
+	Minute   uint64
+	Hour     uint64
+	Dom      uint64
+	Month    uint64
+	Dow      uint64
+	Location *time.Location
+}
+
+type cronField struct {
+	min   uint
+	max   uint
+	names map[string]uint
+}
+
+const starBit = 1 << 63
+
+var (
+	minuteField = cronField{0, 59, nil}
+	hourField   = cronField{0, 23, nil}
+	domField    = cronField{1, 31, nil}
+	monthField  = cronField{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression in the local time zone. The
+// expression may be prefixed with "TZ=<zone> " or "CRON_TZ=<zone> " to
+// evaluate it in a different time zone, e.g. "TZ=Asia/Kolkata 30 2 * * 1-5".
+func ParseCron(expr string) (*CronSpec, error) {
+	return ParseCronInLocation(expr, time.Local)
+}
+
+// ParseCronInLocation parses a cron expression evaluated in loc unless the
+// expression carries its own time zone prefix.
+func ParseCronInLocation(expr string, loc *time.Location) (*CronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		index := strings.Index(expr, " ")
+		if index == -1 {
+			return nil, fmt.Errorf("Missing fields in cron expression %s", expr)
+		}
+		zone := expr[strings.Index(expr, "=")+1 : index]
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("Invalid time zone %s in cron expression. Error: %v", zone, err)
+		}
+		expr = strings.TrimSpace(expr[index:])
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	if strings.HasPrefix(expr, "@") {
+		descriptor, ok := cronDescriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported cron descriptor %s", expr)
+		}
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields in cron expression %s, found %d", expr, len(fields))
+	}
+	spec := CronSpec{Location: loc}
+	var err error
+	if spec.Minute, err = parseCronField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if spec.Hour, err = parseCronField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if spec.Dom, err = parseCronField(fields[2], domField); err != nil {
+		return nil, err
+	}
+	if spec.Month, err = parseCronField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if spec.Dow, err = parseCronField(fields[4], dowField); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 are accepted for sunday
+	if spec.Dow&(1<<7) > 0 {
+		spec.Dow = (spec.Dow &^ (1 << 7)) | 1
+	}
+	return &spec, nil
+}
+
+// MustParseCron is like ParseCron but panics if the expression is invalid.
+func MustParseCron(expr string) *CronSpec {
+	spec, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		partBits, err := parseCronRange(part, bounds)
+		if err != nil {
+			return 0, err
+		}
+		bits = bits | partBits
+	}
+	return bits, nil
+}
+
+func parseCronRange(expr string, bounds cronField) (uint64, error) {
+	var start, end, step uint
+	var err error
+	var extra uint64
+
+	rangeAndStep := strings.Split(expr, "/")
+	lowAndHigh := strings.Split(rangeAndStep[0], "-")
+	singleValue := len(lowAndHigh) == 1
+
+	if lowAndHigh[0] == "*" || lowAndHigh[0] == "?" {
+		start = bounds.min
+		end = bounds.max
+		extra = starBit
+	} else {
+		if start, err = parseCronValue(lowAndHigh[0], bounds); err != nil {
+			return 0, err
+		}
+		switch len(lowAndHigh) {
+		case 1:
+			end = start
+		case 2:
+			if end, err = parseCronValue(lowAndHigh[1], bounds); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("Too many hyphens in %s", expr)
+		}
+	}
+
+	switch len(rangeAndStep) {
+	case 1:
+		step = 1
+	case 2:
+		value, err := strconv.ParseUint(rangeAndStep[1], 10, 0)
+		if err != nil || value == 0 {
+			return 0, fmt.Errorf("Invalid step in %s", expr)
+		}
+		step = uint(value)
+		// "N/step" means "N-max/step"
+		if singleValue && extra == 0 {
+			end = bounds.max
+		}
+		if step > 1 {
+			extra = 0
+		}
+	default:
+		return 0, fmt.Errorf("Too many slashes in %s", expr)
+	}
+
+	if start < bounds.min || end > bounds.max || start > end {
+		return 0, fmt.Errorf("Value out of range (%d - %d) in %s", bounds.min, bounds.max, expr)
+	}
+
+	var bits uint64
+	for value := start; value <= end; value += step {
+		bits = bits | 1<<value
+	}
+	return bits | extra, nil
+}
+
+func parseCronValue(expr string, bounds cronField) (uint, error) {
+	if bounds.names != nil {
+		if value, ok := bounds.names[strings.ToLower(expr)]; ok {
+			return value, nil
+		}
+	}
+	value, err := strconv.ParseUint(expr, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse %s in cron expression", expr)
+	}
+	return uint(value), nil
+}
+
+// Next returns the first time after t matching the cron expression, or the
+// zero time if no such time exists in the next five years.
+func (c *CronSpec) Next(t time.Time) time.Time {
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc).Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for 1<<uint(t.Month())&c.Month == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !c.dayMatches(t) {
+		next := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		// Midnight may not exist on days with a daylight saving transition
+		for next.Day() == t.Day() {
+			next = next.Add(time.Hour)
+		}
+		t = next
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Hour())&c.Hour == 0 {
+		next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		// Skip the hour missing on a daylight saving transition
+		if !next.After(t) {
+			next = t.Add(time.Hour)
+		}
+		t = next
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for 1<<uint(t.Minute())&c.Minute == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t
+}
+
+// As in standard cron, if both day-of-month and day-of-week are restricted
+// the day matches when either of them matches.
+func (c *CronSpec) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&c.Dom > 0
+	dowMatch := 1<<uint(t.Weekday())&c.Dow > 0
+	if c.Dom&starBit > 0 || c.Dow&starBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,192 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Policies for the runs of a persistent schedule missed while the service
+// was down
+const (
+	// Drop the missed runs and continue from the next activation
+	MISSED_RUN_SKIP = "skip"
+	// Run once immediately to make up for all the missed runs
+	MISSED_RUN_ONCE = "run_once"
+)
+
+// GetSpec builds the Spec of a persisted schedule definition. The cron
+// expression takes precedence over the interval, which is in seconds.
+func GetSpec(definition models.Schedule) (Spec, error) {
+	var spec Spec
+	if definition.CronExpr != "" {
+		loc := time.Local
+		if definition.Location != "" {
+			var err error
+			if loc, err = time.LoadLocation(definition.Location); err != nil {
+				return nil, fmt.Errorf("Invalid location %s for schedule %v. Error: %v", definition.Location, definition.Id, err)
+			}
+		}
+		cronSpec, err := ParseCronInLocation(definition.CronExpr, loc)
+		if err != nil {
+			return nil, err
+		}
+		spec = cronSpec
+	} else if definition.Interval > 0 {
+		spec = Every(time.Duration(definition.Interval) * time.Second)
+	} else {
+		return nil, fmt.Errorf("Neither interval nor cron expression specified for schedule %v", definition.Id)
+	}
+	return Within(spec, definition.StartFrom, definition.EndBy), nil
+}
+
+// AddSchedule persists the schedule definition and starts running it. The
+// job or callback referred by the definition should already be registered.
+func AddSchedule(definition models.Schedule) (Scheduler, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return Scheduler{}, err
+	}
+	definition.Id = *id
+	f, err := scheduleCallback(definition)
+	if err != nil {
+		return Scheduler{}, err
+	}
+	spec, err := GetSpec(definition)
+	if err != nil {
+		return Scheduler{}, err
+	}
+	switch definition.MissedRunPolicy {
+	case "":
+		definition.MissedRunPolicy = MISSED_RUN_SKIP
+	case MISSED_RUN_SKIP, MISSED_RUN_ONCE:
+	default:
+		return Scheduler{}, fmt.Errorf("Unsupported missed run policy %s", definition.MissedRunPolicy)
+	}
+	definition.NextRun = spec.Next(time.Now())
+	if definition.NextRun.IsZero() {
+		return Scheduler{}, fmt.Errorf("Schedule for %s has no activations left", definition.CallbackName)
+	}
+	options := scheduleOptions(definition)
+	if err := options.Valid(); err != nil {
+		return Scheduler{}, err
+	}
+	scheduler := Scheduler{Channel: make(chan string), Id: *id, Persistent: true, Options: options}
+
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULES)
+	if err := coll.Insert(definition); err != nil {
+		logger.Get().Error("Error persisting schedule: %v. error: %v", definition.Id, err)
+		return Scheduler{}, err
+	}
+
+	addScheduler(scheduler)
+	go scheduler.run(definition, spec, f)
+	return scheduler, nil
+}
+
+// scheduleCallback resolves the name referred by the definition. Jobs take
+// precedence over plain callbacks and are run as tasks owned by the owner of
+// the schedule.
+func scheduleCallback(definition models.Schedule) (ScheduleCallback, error) {
+	if job, err := GetJob(definition.CallbackName); err == nil {
+		return TaskCallback(definition.Id, definition.Owner, definition.CallbackName, job), nil
+	}
+	return GetCallback(definition.CallbackName)
+}
+
+func scheduleOptions(definition models.Schedule) ScheduleOptions {
+	return ScheduleOptions{
+		OverlapPolicy: definition.OverlapPolicy,
+		Jitter:        time.Duration(definition.Jitter) * time.Second,
+	}
+}
+
+// GetSchedules returns the persisted schedule definitions.
+func GetSchedules(selectCriteria bson.M) ([]models.Schedule, error) {
+	var definitions []models.Schedule
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULES)
+	err := coll.Find(selectCriteria).All(&definitions)
+	return definitions, err
+}
+
+func resumeSchedules() {
+	if db.GetDatastore() == nil {
+		logger.Get().Warning("Datastore not initialized. Persisted schedules are not resumed")
+		return
+	}
+	definitions, err := GetSchedules(nil)
+	if err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("Failed to fetch the persisted schedules. error: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, definition := range definitions {
+		f, err := scheduleCallback(definition)
+		if err != nil {
+			logger.Get().Error("Cannot resume schedule %v. error: %v", definition.Id, err)
+			continue
+		}
+		spec, err := GetSpec(definition)
+		if err != nil {
+			logger.Get().Error("Cannot resume schedule %v. error: %v", definition.Id, err)
+			continue
+		}
+		if definition.NextRun.Before(now) {
+			if definition.MissedRunPolicy == MISSED_RUN_ONCE {
+				logger.Get().Info("Schedule %v missed its run at %v. Running it now", definition.Id, definition.NextRun)
+				definition.NextRun = now
+			} else {
+				logger.Get().Info("Schedule %v missed its run at %v. Skipping it", definition.Id, definition.NextRun)
+				definition.NextRun = spec.Next(now)
+			}
+		}
+		if definition.NextRun.IsZero() {
+			removeSchedule(definition.Id)
+			continue
+		}
+		scheduler := Scheduler{Channel: make(chan string), Id: definition.Id, Persistent: true, Options: scheduleOptions(definition)}
+		addScheduler(scheduler)
+		go scheduler.run(definition, spec, f)
+	}
+}
+
+func (s Scheduler) run(definition models.Schedule, spec Spec, f ScheduleCallback) {
+	s.loop(spec, definition.NextRun, f, definition.Parameters, func(last time.Time, next time.Time) {
+		definition.LastRun = last
+		definition.NextRun = next
+		updateRunTimes(definition)
+	})
+	// The schedule has no more activations unless it was deleted
+	if _, ok := removeScheduler(s.Id); ok {
+		removeSchedule(s.Id)
+	}
+}
+
+func updateRunTimes(definition models.Schedule) {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULES)
+	if err := coll.Update(bson.M{"id": definition.Id}, bson.M{"$set": bson.M{"lastrun": definition.LastRun, "nextrun": definition.NextRun}}); err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("Error updating the run times of schedule: %v. error: %v", definition.Id, err)
+	}
+}
+
+func removeSchedule(id uuid.UUID) {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULES)
+	if err := coll.Remove(bson.M{"id": id}); err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("Error removing schedule: %v. error: %v", id, err)
+	}
+}
@@ -3,42 +3,87 @@ package schedule
 import (
 	"fmt"
 	"github.com/skyrings/skyring-common/tools/uuid"
+	"sync"
 )
 
 //In memory Schedule id to Schedule Map
 var schedules map[uuid.UUID]Scheduler
+var schedulesMutex sync.Mutex
+var managerInitialized bool
 
+// InitShechuleManager initializes the schedules and resumes the ones
+// persisted earlier using AddSchedule.
 func InitShechuleManager() {
+	schedulesMutex.Lock()
+	if managerInitialized {
+		schedulesMutex.Unlock()
+		return
+	}
+	managerInitialized = true
 	if schedules == nil {
 		schedules = make(map[uuid.UUID]Scheduler)
 	}
+	schedulesMutex.Unlock()
+	resumeSchedules()
 }
 func NewScheduler() (Scheduler, error) {
+	return NewSchedulerWithOptions(ScheduleOptions{})
+}
+
+// NewSchedulerWithOptions creates a scheduler whose runs follow the given
+// overlap policy and jitter.
+func NewSchedulerWithOptions(options ScheduleOptions) (Scheduler, error) {
+	if err := options.Valid(); err != nil {
+		return Scheduler{}, err
+	}
 	id, err := uuid.New()
 	if err != nil {
 		return Scheduler{}, err
 	}
-	scheduler := Scheduler{Channel: make(chan string), Id: *id}
-	schedules[*id] = scheduler
+	scheduler := Scheduler{Channel: make(chan string), Id: *id, Options: options}
+	addScheduler(scheduler)
 	return scheduler, nil
 }
 
 func DeleteScheduler(scheduleId uuid.UUID) error {
-	scheduler, err := GetScheduler(scheduleId)
-	if err != nil {
-		return err
+	scheduler, ok := removeScheduler(scheduleId)
+	if !ok {
+		return fmt.Errorf("Schedule with id %v not found", scheduleId)
 	}
-	go func() {
-		scheduler.Channel <- "Done"
-		delete(schedules, scheduleId)
-	}()
+	if scheduler.Persistent {
+		removeSchedule(scheduleId)
+	}
+	// Closing the channel stops the schedule whether or not it is
+	// currently waiting for its next run
+	close(scheduler.Channel)
 	return nil
 }
 
 func GetScheduler(scheduleId uuid.UUID) (Scheduler, error) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
 	val, ok := schedules[scheduleId]
 	if ok {
 		return val, nil
 	}
 	return val, fmt.Errorf("Schedule with id %v not found", scheduleId)
 }
+
+func addScheduler(scheduler Scheduler) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	if schedules == nil {
+		schedules = make(map[uuid.UUID]Scheduler)
+	}
+	schedules[scheduler.Id] = scheduler
+}
+
+func removeScheduler(scheduleId uuid.UUID) (Scheduler, bool) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	scheduler, ok := schedules[scheduleId]
+	if ok {
+		delete(schedules, scheduleId)
+	}
+	return scheduler, ok
+}
@@ -1,30 +1,156 @@
 package schedule
 
 import (
+	"fmt"
+	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/tools/uuid"
+	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
+// Policies deciding what happens when a run is due while the previous run
+// of the same schedule is still in progress
+const (
+	// Drop the run which is due
+	OVERLAP_SKIP = "skip"
+	// Start the run once the previous runs complete
+	OVERLAP_QUEUE = "queue"
+	// Start the run right away, concurrently with the previous runs
+	OVERLAP_ALLOW = "allow"
+)
+
+// Number of runs waiting for their turn beyond which further runs are
+// dropped under the queue overlap policy
+var MaxQueuedRuns = 10
+
+type ScheduleOptions struct {
+	// One of the overlap policies, OVERLAP_SKIP if empty
+	OverlapPolicy string
+	// Upper bound of the random delay added to every run. This spreads
+	// the runs of the same schedule across a cluster and should be kept
+	// smaller than the interval between runs.
+	Jitter time.Duration
+}
+
 type Scheduler struct {
-	Id      uuid.UUID
-	Channel chan string
+	Id         uuid.UUID
+	Channel    chan string
+	Persistent bool
+	Options    ScheduleOptions
 }
 
 var (
 	scheduler *Scheduler
 )
 
+func (o ScheduleOptions) Valid() error {
+	switch o.OverlapPolicy {
+	case "", OVERLAP_SKIP, OVERLAP_QUEUE, OVERLAP_ALLOW:
+	default:
+		return fmt.Errorf("Unsupported overlap policy %s", o.OverlapPolicy)
+	}
+	if o.Jitter < 0 {
+		return fmt.Errorf("Jitter %v cannot be negative", o.Jitter)
+	}
+	return nil
+}
+
+// Schedule runs f every t until the schedule is deleted. The runs are due
+// at fixed intervals from the time of scheduling, irrespective of how long
+// the individual runs take.
 func (s Scheduler) Schedule(t time.Duration, f func(map[string]interface{}), m map[string]interface{}) {
-	for {
+	s.loop(Every(t), time.Now().Add(t), f, m, nil)
+}
+
+// ScheduleSpec runs f at every activation time of spec until the schedule
+// is deleted or spec has no more activations.
+func (s Scheduler) ScheduleSpec(spec Spec, f func(map[string]interface{}), m map[string]interface{}) {
+	s.loop(spec, spec.Next(time.Now()), f, m, nil)
+	removeScheduler(s.Id)
+}
+
+// loop waits for the activations of spec starting at next and hands them
+// over to the executor. Activations missed because of a clock jump or a
+// stalled process are skipped. fired is invoked after every activation
+// with the time of the activation and the next one.
+func (s Scheduler) loop(spec Spec, next time.Time, f func(map[string]interface{}), m map[string]interface{}, fired func(last time.Time, next time.Time)) {
+	e := newExecutor(s.Id, s.Options.OverlapPolicy, f)
+	defer e.stop()
+	for !next.IsZero() {
+		timer := time.NewTimer(next.Add(s.jitter()).Sub(time.Now()))
 		select {
-		case _, ok := <-time.After(t):
-			if ok {
-				f(m)
+		case <-timer.C:
+			e.fire(m)
+			last := next
+			next = spec.Next(next)
+			if now := time.Now(); !next.IsZero() && next.Before(now) {
+				logger.Get().Warning("Schedule %v missed its runs since %v", s.Id, next)
+				next = spec.Next(now)
 			}
-		case _, ok := <-s.Channel:
-			if ok {
-				return
+			if fired != nil {
+				fired(last, next)
 			}
+		case <-s.Channel:
+			timer.Stop()
+			return
 		}
 	}
 }
+
+func (s Scheduler) jitter() time.Duration {
+	if s.Options.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.Options.Jitter)))
+}
+
+type executor struct {
+	scheduleId uuid.UUID
+	policy     string
+	f          func(map[string]interface{})
+	running    int32
+	queue      chan map[string]interface{}
+}
+
+func newExecutor(scheduleId uuid.UUID, policy string, f func(map[string]interface{})) *executor {
+	e := &executor{scheduleId: scheduleId, policy: policy, f: f}
+	if policy == OVERLAP_QUEUE {
+		e.queue = make(chan map[string]interface{}, MaxQueuedRuns)
+		go func() {
+			for m := range e.queue {
+				e.f(m)
+			}
+		}()
+	}
+	return e
+}
+
+func (e *executor) fire(m map[string]interface{}) {
+	switch e.policy {
+	case OVERLAP_ALLOW:
+		go e.f(m)
+	case OVERLAP_QUEUE:
+		select {
+		case e.queue <- m:
+		default:
+			logger.Get().Warning("Schedule %v has %d runs queued. Dropping the run", e.scheduleId, MaxQueuedRuns)
+		}
+	default:
+		if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+			logger.Get().Warning("Previous run of schedule %v still in progress. Skipping the run", e.scheduleId)
+			return
+		}
+		go func() {
+			defer atomic.StoreInt32(&e.running, 0)
+			e.f(m)
+		}()
+	}
+}
+
+// stop lets the queued runs complete but accepts no more runs
+func (e *executor) stop() {
+	if e.queue != nil {
+		close(e.queue)
+	}
+}
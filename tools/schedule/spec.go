@@ -0,0 +1,204 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skyrings/skyring-common/models"
+)
+
+// Spec describes when a schedule has to be run next.
+type Spec interface {
+	// Next returns the next activation time later than t, or the zero
+	// time if the schedule is not to be run anymore.
+	Next(t time.Time) time.Time
+}
+
+const (
+	RECURRENCE_HOURLY  = "hourly"
+	RECURRENCE_DAILY   = "daily"
+	RECURRENCE_WEEKLY  = "weekly"
+	RECURRENCE_MONTHLY = "monthly"
+)
+
+var SupportedScheduleTimeFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.000Z",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+type intervalSpec struct {
+	Interval time.Duration
+}
+
+// Every returns a Spec activated every d.
+func Every(d time.Duration) Spec {
+	return intervalSpec{Interval: d}
+}
+
+func (s intervalSpec) Next(t time.Time) time.Time {
+	return t.Add(s.Interval)
+}
+
+type windowSpec struct {
+	Spec      Spec
+	StartFrom time.Time
+	EndBy     time.Time
+}
+
+// Within restricts spec to the window between startFrom and endBy. A zero
+// startFrom or endBy leaves the window open on that side.
+func Within(spec Spec, startFrom time.Time, endBy time.Time) Spec {
+	return windowSpec{Spec: spec, StartFrom: startFrom, EndBy: endBy}
+}
+
+func (s windowSpec) Next(t time.Time) time.Time {
+	// Allow an activation exactly at the start of the window
+	if !s.StartFrom.IsZero() && t.Before(s.StartFrom) {
+		t = s.StartFrom.Add(-time.Nanosecond)
+	}
+	next := s.Spec.Next(t)
+	if next.IsZero() || (!s.EndBy.IsZero() && next.After(s.EndBy)) {
+		return time.Time{}
+	}
+	return next
+}
+
+// nthSpec keeps the activations of Spec falling in every Nth period of
+// Days days, the periods being counted from the one containing Anchor.
+type nthSpec struct {
+	Spec   Spec
+	Anchor time.Time
+	Days   int
+	N      int
+}
+
+// calendarDays returns the number of calendar days from a to b in the
+// location of a, regardless of the daylight saving changes.
+func calendarDays(a time.Time, b time.Time) int {
+	b = b.In(a.Location())
+	from := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+func (s nthSpec) Next(t time.Time) time.Time {
+	// The periods of weeks start on sunday, as the weeks of the cron specs
+	anchor := s.Anchor
+	if s.Days == 7 {
+		anchor = anchor.AddDate(0, 0, -int(anchor.Weekday()))
+	}
+	// A year of activations is plenty to find the next one in any period
+	for i := 0; i < 366; i++ {
+		next := s.Spec.Next(t)
+		if next.IsZero() {
+			return next
+		}
+		days := calendarDays(anchor, next)
+		if days >= 0 && (days/s.Days)%s.N == 0 {
+			return next
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// ParseScheduleTime parses a schedule boundary or execution date in loc.
+func ParseScheduleTime(value string, loc *time.Location) (time.Time, error) {
+	for _, format := range SupportedScheduleTimeFormats {
+		if parsedTime, err := time.ParseInLocation(format, value, loc); err == nil {
+			return parsedTime, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("The time %v is of unsupported format", value)
+}
+
+// SnapshotScheduleSpec builds the Spec described by a snapshot schedule.
+// ExecutionTime is of the form HH:MM and, like StartFrom and EndBy, is
+// interpreted in loc. The daily and weekly intervals are counted from
+// StartFrom, which weekly schedules of an interval above one require. The
+// daily intervals of the schedules with no StartFrom are counted from the
+// first day of each month, like the */N of cron.
+func SnapshotScheduleSpec(schedule models.SnapshotSchedule, loc *time.Location) (Spec, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	var hour, minute int
+	if schedule.ExecutionTime != "" {
+		executionTime, err := time.Parse("15:04", schedule.ExecutionTime)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid execution time %s. Error: %v", schedule.ExecutionTime, err)
+		}
+		hour = executionTime.Hour()
+		minute = executionTime.Minute()
+	}
+	var startFrom, endBy time.Time
+	var err error
+	if schedule.StartFrom != "" {
+		if startFrom, err = ParseScheduleTime(schedule.StartFrom, loc); err != nil {
+			return nil, err
+		}
+	}
+	if schedule.EndBy != "" {
+		if endBy, err = ParseScheduleTime(schedule.EndBy, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	step := "*"
+	if schedule.Interval > 1 {
+		step = "*/" + strconv.Itoa(schedule.Interval)
+	}
+	// The number of days of the periods counted from StartFrom, if any
+	var periodDays int
+	var expr string
+	switch strings.ToLower(schedule.Recurrence) {
+	case RECURRENCE_HOURLY:
+		expr = fmt.Sprintf("%d %s * * *", minute, step)
+	case RECURRENCE_DAILY:
+		if schedule.Interval > 1 && !startFrom.IsZero() {
+			step = "*"
+			periodDays = 1
+		}
+		expr = fmt.Sprintf("%d %d %s * *", minute, hour, step)
+	case RECURRENCE_WEEKLY:
+		if len(schedule.Days) == 0 {
+			return nil, fmt.Errorf("No days specified for weekly schedule %v", schedule.Id)
+		}
+		if schedule.Interval > 1 {
+			if startFrom.IsZero() {
+				return nil, fmt.Errorf("No start specified for the weekly schedule %v of interval %d", schedule.Id, schedule.Interval)
+			}
+			periodDays = 7
+		}
+		days := make([]string, len(schedule.Days))
+		for index, day := range schedule.Days {
+			if len(day) < 3 {
+				return nil, fmt.Errorf("Invalid day %s in schedule %v", day, schedule.Id)
+			}
+			days[index] = strings.ToLower(day[:3])
+		}
+		expr = fmt.Sprintf("%d %d * * %s", minute, hour, strings.Join(days, ","))
+	case RECURRENCE_MONTHLY:
+		day := 1
+		if !startFrom.IsZero() {
+			day = startFrom.Day()
+		}
+		expr = fmt.Sprintf("%d %d %d %s *", minute, hour, day, step)
+	default:
+		return nil, fmt.Errorf("Unsupported recurrence %s in schedule %v", schedule.Recurrence, schedule.Id)
+	}
+
+	cronSpec, err := ParseCronInLocation(expr, loc)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec = cronSpec
+	if periodDays != 0 {
+		spec = nthSpec{Spec: spec, Anchor: startFrom, Days: periodDays, N: schedule.Interval}
+	}
+	return Within(spec, startFrom, endBy), nil
+}
@@ -0,0 +1,197 @@
+package schedule
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skyrings/skyring-common/conf"
+	"github.com/skyrings/skyring-common/db"
+	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/task"
+	"github.com/skyrings/skyring-common/tools/uuid"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ScheduleJob is a scheduled function run as a task of the task manager.
+// Returning an error marks the task and the run of the schedule failed.
+type ScheduleJob func(t *task.Task, params map[string]interface{}) error
+
+// Number of runs kept in the run history of every schedule
+var MaxScheduleRunHistory = 100
+
+var (
+	jobsMutex sync.Mutex
+	jobs      = make(map[string]ScheduleJob)
+)
+
+// RegisterJob registers a job that persistent schedules can refer to by
+// name, same as the callbacks registered using RegisterCallback.
+func RegisterJob(name string, job ScheduleJob) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	if _, found := jobs[name]; found {
+		logger.Get().Warning("Schedule job %s registered twice", name)
+	}
+	jobs[name] = job
+}
+
+func GetJob(name string) (ScheduleJob, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job, found := jobs[name]
+	if !found {
+		return nil, fmt.Errorf("Schedule job %s not registered", name)
+	}
+	return job, nil
+}
+
+// ScheduleJob runs job as a task named name at every activation of spec
+// until the schedule is deleted. The outcome of every run is recorded in the
+// run history of the schedule.
+func (s Scheduler) ScheduleJob(spec Spec, owner string, name string, job ScheduleJob, m map[string]interface{}) {
+	s.ScheduleSpec(spec, TaskCallback(s.Id, owner, name, job), m)
+}
+
+// TaskCallback wraps job into a callback which runs it as a task and waits
+// for the task to complete, so that the overlap policy of the schedule
+// applies to the task.
+func TaskCallback(scheduleId uuid.UUID, owner string, name string, job ScheduleJob) ScheduleCallback {
+	return func(params map[string]interface{}) {
+		runJob(scheduleId, owner, name, job, params)
+	}
+}
+
+func runJob(scheduleId uuid.UUID, owner string, name string, job ScheduleJob, params map[string]interface{}) {
+	run := models.ScheduleRun{ScheduleId: scheduleId, Name: name, StartedAt: time.Now()}
+	// The error the job returned. The job may complete its task itself,
+	// before returning.
+	var outcome struct {
+		sync.Mutex
+		err error
+	}
+	completed := make(chan *task.Task, 1)
+	// The stop requests are left to the task manager, which times the task
+	// out, the run completing then
+	asyncTask := func(t *task.Task) {
+		jobErr := job(t, params)
+		outcome.Lock()
+		outcome.err = jobErr
+		outcome.Unlock()
+		if jobErr != nil {
+			t.UpdateStatus("Failed. error: %v", jobErr)
+			t.Done(models.TASK_STATUS_FAILURE)
+		} else {
+			t.UpdateStatus("Success")
+			t.Done(models.TASK_STATUS_SUCCESS)
+		}
+	}
+	taskId, err := task.GetTaskManager().Run(
+		owner,
+		name,
+		asyncTask,
+		nil,
+		func(t *task.Task) {
+			completed <- t
+		},
+		nil,
+		nil)
+	if err != nil {
+		logger.Get().Error("Failed to start the task for schedule %v. error: %v", scheduleId, err)
+		run.CompletedAt = time.Now()
+		run.Status = models.TASK_STATUS_FAILURE
+		run.Error = err.Error()
+		recordRun(run)
+		return
+	}
+	run.TaskId = taskId
+
+	t := <-completed
+	run.CompletedAt = time.Now()
+	run.Duration = run.CompletedAt.Sub(run.StartedAt).Seconds()
+	run.Status = t.Status
+	switch t.Status {
+	case models.TASK_STATUS_FAILURE:
+		outcome.Lock()
+		run.Error = failureMessage(t, outcome.err)
+		outcome.Unlock()
+	case models.TASK_STATUS_TIMED_OUT:
+		run.Error = fmt.Sprintf("Task %v timed out", taskId)
+	}
+	recordRun(run)
+}
+
+// failureMessage returns the error of the job or, if the job failed its task
+// itself, the last status of the task.
+func failureMessage(t *task.Task, jobErr error) string {
+	if jobErr != nil {
+		return jobErr.Error()
+	}
+	t.Mutex.Lock()
+	defer t.Mutex.Unlock()
+	if len(t.StatusList) != 0 {
+		return t.StatusList[len(t.StatusList)-1].Message
+	}
+	return fmt.Sprintf("Task %v failed", t.ID)
+}
+
+func recordRun(run models.ScheduleRun) {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULE_RUNS)
+	if err := coll.Insert(run); err != nil {
+		logger.Get().Error("Error persisting run of schedule: %v. error: %v", run.ScheduleId, err)
+	}
+
+	// Trim the history to the latest runs
+	var oldRuns []models.ScheduleRun
+	if err := coll.Find(bson.M{"scheduleid": run.ScheduleId}).Sort("-startedat").Skip(MaxScheduleRunHistory).All(&oldRuns); err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("Error fetching old runs of schedule: %v. error: %v", run.ScheduleId, err)
+	} else if len(oldRuns) > 0 {
+		if _, err := coll.RemoveAll(bson.M{"scheduleid": run.ScheduleId, "startedat": bson.M{"$lte": oldRuns[0].StartedAt}}); err != nil {
+			logger.Get().Error("Error removing old runs of schedule: %v. error: %v", run.ScheduleId, err)
+		}
+	}
+
+	// Persistent schedules carry the outcome of their last runs
+	var update bson.M
+	if run.Status == models.TASK_STATUS_SUCCESS {
+		update = bson.M{"lastsuccess": run.CompletedAt}
+	} else {
+		update = bson.M{"lastfailure": run.CompletedAt, "lasterror": run.Error}
+	}
+	coll = sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULES)
+	if err := coll.Update(bson.M{"id": run.ScheduleId}, bson.M{"$set": update}); err != nil && err != mgo.ErrNotFound {
+		logger.Get().Error("Error updating the last run of schedule: %v. error: %v", run.ScheduleId, err)
+	}
+}
+
+// GetScheduleRuns returns the latest runs of a schedule, most recent first.
+// A limit of 0 returns the whole history.
+func GetScheduleRuns(scheduleId uuid.UUID, limit int) ([]models.ScheduleRun, error) {
+	var runs []models.ScheduleRun
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULE_RUNS)
+	err := coll.Find(bson.M{"scheduleid": scheduleId}).Sort("-startedat").Limit(limit).All(&runs)
+	return runs, err
+}
+
+// GetLastScheduleRuns returns the latest successful and failed runs of a
+// schedule. A run is left zero valued if the schedule has no such run.
+func GetLastScheduleRuns(scheduleId uuid.UUID) (lastSuccess models.ScheduleRun, lastFailure models.ScheduleRun, err error) {
+	sessionCopy := db.GetDatastore().Copy()
+	defer sessionCopy.Close()
+	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_SCHEDULE_RUNS)
+	if err = coll.Find(bson.M{"scheduleid": scheduleId, "status": models.TASK_STATUS_SUCCESS}).Sort("-startedat").One(&lastSuccess); err != nil && err != mgo.ErrNotFound {
+		return
+	}
+	if err = coll.Find(bson.M{"scheduleid": scheduleId, "status": bson.M{"$ne": models.TASK_STATUS_SUCCESS}}).Sort("-startedat").One(&lastFailure); err != nil && err != mgo.ErrNotFound {
+		return
+	}
+	return lastSuccess, lastFailure, nil
+}
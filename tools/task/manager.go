@@ -20,7 +20,9 @@ import (
 	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/db"
 	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/tools/lock"
 	"github.com/skyrings/skyring-common/tools/logger"
+	"github.com/skyrings/skyring-common/tools/metrics"
 	"github.com/skyrings/skyring-common/tools/uuid"
 	"gopkg.in/mgo.v2/bson"
 	"sync"
@@ -28,13 +30,23 @@ import (
 
 var (
 	TaskManager Manager
+
+	tasksQueued    = metrics.NewGauge("skyring_tasks_queued", "Tasks started and acquiring their locks.")
+	tasksRunning   = metrics.NewGauge("skyring_tasks_running", "Tasks running.")
+	tasksCompleted = metrics.NewCounter("skyring_tasks_completed_total", "Tasks completed by status.", "status")
+	taskDurations  = metrics.NewHistogram("skyring_task_duration_seconds", "Durations of the tasks by status.", nil, "status")
 )
 
 type Manager struct {
-	tasks map[uuid.UUID]*Task
+	tasks       map[uuid.UUID]*Task
+	lockManager lock.LockManager
 }
 
-func (manager *Manager) Run(owner string, name string, f func(t *Task), startedFunc func(t *Task), completedFunc func(t *Task), statusFunc func(t *Task, s *models.Status)) (uuid.UUID, error) {
+// Run starts f as a new task. If appLock is not nil, the locks are acquired
+// before f is started and released automatically once the task is done,
+// timed out or stopped. If the locks cannot be acquired the task is marked
+// failed and f is never started.
+func (manager *Manager) Run(owner string, name string, f func(t *Task), startedFunc func(t *Task), completedFunc func(t *Task), statusFunc func(t *Task, s *models.Status), appLock *lock.AppLock) (uuid.UUID, error) {
 	if id, err := uuid.New(); err == nil {
 		task := Task{
 			Mutex:            &sync.Mutex{},
@@ -48,6 +60,17 @@ func (manager *Manager) Run(owner string, name string, f func(t *Task), startedF
 			StartedCbkFunc:   startedFunc,
 			CompletedCbkFunc: completedFunc,
 			StatusCbkFunc:    statusFunc,
+			AppLock:          appLock,
+			LockManager:      manager.lockManager,
+		}
+		tasksQueued.With().Inc()
+		err := task.acquireLock()
+		tasksQueued.With().Dec()
+		if err != nil {
+			task.Persist()
+			task.UpdateStatus("Failed. error: %v", err)
+			task.Done(models.TASK_STATUS_FAILURE)
+			return *id, err
 		}
 		task.Run()
 		manager.tasks[*id] = &task
@@ -142,8 +165,8 @@ func (manager *Manager) List() []uuid.UUID {
 	return ids
 }
 
-func NewManager() Manager {
-	TaskManager = Manager{make(map[uuid.UUID]*Task)}
+func NewManager(lockManager lock.LockManager) Manager {
+	TaskManager = Manager{tasks: make(map[uuid.UUID]*Task), lockManager: lockManager}
 	return TaskManager
 }
 
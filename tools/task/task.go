@@ -19,6 +19,7 @@ import (
 	"github.com/skyrings/skyring-common/conf"
 	"github.com/skyrings/skyring-common/db"
 	"github.com/skyrings/skyring-common/models"
+	"github.com/skyrings/skyring-common/tools/lock"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/tools/uuid"
 	"gopkg.in/mgo.v2/bson"
@@ -34,6 +35,7 @@ type Task struct {
 	Tag              map[string]string
 	Started          bool
 	Completed        bool
+	Status           models.TaskStatus
 	DoneCh           chan bool
 	StatusList       []models.Status
 	StopCh           chan bool
@@ -42,6 +44,9 @@ type Task struct {
 	CompletedCbkFunc func(t *Task)
 	StatusCbkFunc    func(t *Task, s *models.Status)
 	LastUpdated      time.Time
+	AppLock          *lock.AppLock
+	LockManager      lock.LockManager
+	startedAt        time.Time
 }
 
 func (t Task) String() string {
@@ -66,6 +71,8 @@ func (t *Task) UpdateStatus(format string, args ...interface{}) {
 }
 
 func (t *Task) Run() {
+	t.startedAt = time.Now()
+	tasksRunning.With().Inc()
 	go t.Func(t)
 	t.Started = true
 	t.Persist()
@@ -87,8 +94,15 @@ func (t *Task) Done(status models.TaskStatus) {
 	t.DoneCh <- true
 	close(t.DoneCh)
 	t.Completed = true
+	t.Status = status
 	t.LastUpdated = time.Now()
 	t.UpdateTaskCompleted(t.Completed, status, t.LastUpdated)
+	t.releaseLock()
+	if !t.startedAt.IsZero() {
+		tasksRunning.With().Dec()
+		taskDurations.With(status.String()).ObserveSince(t.startedAt)
+	}
+	tasksCompleted.With(status.String()).Inc()
 	if t.CompletedCbkFunc != nil {
 		go t.CompletedCbkFunc(t)
 	}
@@ -96,6 +110,31 @@ func (t *Task) Done(status models.TaskStatus) {
 	GetTaskManager().RemoveTask(t.ID)
 }
 
+func (t *Task) acquireLock() error {
+	if t.AppLock == nil {
+		return nil
+	}
+	if t.LockManager == nil {
+		t.AppLock = nil
+		return fmt.Errorf("No lock manager available to acquire the locks for task: %v", t.ID)
+	}
+	if err := t.LockManager.AcquireLock(t.ID.String(), *t.AppLock); err != nil {
+		// The locks are held by someone else, nothing to release later
+		t.AppLock = nil
+		return err
+	}
+	return nil
+}
+
+func (t *Task) releaseLock() {
+	if t.AppLock == nil || t.LockManager == nil {
+		return
+	}
+	t.LockManager.ReleaseLock(t.ID.String(), *t.AppLock)
+	// Make sure the locks are released only once
+	t.AppLock = nil
+}
+
 func (t *Task) IsDone() bool {
 	select {
 	case _, read := <-t.DoneCh:
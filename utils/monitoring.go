@@ -9,6 +9,7 @@ import (
 	"github.com/skyrings/skyring-common/db"
 	"github.com/skyrings/skyring-common/models"
 	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/summary"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/tools/uuid"
 	"gopkg.in/mgo.v2"
@@ -72,17 +73,9 @@ func GetStorageCount(selectCriteria bson.M) (map[string]int, error) {
 	defer sessionCopy.Close()
 
 	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_STORAGE)
-	var storages models.Storages
+	var storages []models.Storage
 	err := coll.Find(selectCriteria).All(&storages)
-	storage_down_cnt := 0
-	storageCriticalAlertsCount := 0
-	for _, storage := range storages {
-		storageCriticalAlertsCount = storageCriticalAlertsCount + storage.AlmCritCount
-		if storage.Status == models.STORAGE_STATUS_ERROR {
-			storage_down_cnt = storage_down_cnt + 1
-		}
-	}
-	return map[string]int{models.TOTAL: len(storages), models.STATUS_DOWN: storage_down_cnt, models.CriticalAlerts: storageCriticalAlertsCount}, err
+	return summary.StorageCounts(storages), err
 }
 
 func ComputeUsage(selectCriteria bson.M) (models.Utilization, error) {
@@ -115,43 +108,21 @@ func ComputeSluStatusWiseCount(sluSelectCriteria bson.M, sluThresholdSelectCrite
 	if err != nil && err != mgo.ErrNotFound {
 		err_str = fmt.Sprintf("%s", err.Error())
 	}
-	slu_error_cnt := 0
-	slu_warning_count := 0
-	slu_unknown_count := 0
-	slu_ok_count := 0
 	sluThresholdEventsInDb, err := fetchThresholdEvents(sluThresholdSelectCriteria, monitoring.SLU_UTILIZATION)
 	if err != nil && err != mgo.ErrNotFound {
 		err_str = fmt.Sprintf("%s.%s", err_str, err.Error())
 	}
 
-	sluCriticalAlertCount := 0
-	for _, slu := range slus {
-		sluCriticalAlertCount = sluCriticalAlertCount + slu.AlmCritCount
-		if slu.Status == models.SLU_STATUS_ERROR {
-			slu_error_cnt = slu_error_cnt + 1
-		}
-		if slu.Status == models.SLU_STATUS_WARN {
-			slu_warning_count = slu_warning_count + 1
-		}
-		if slu.Status == models.SLU_STATUS_OK {
-			slu_ok_count = slu_ok_count + 1
-		}
-		if slu.Status == models.SLU_STATUS_UNKNOWN {
-			slu_unknown_count = slu_unknown_count + 1
-		}
-	}
 	if err_str == "" {
 		err = nil
 	} else {
 		err = fmt.Errorf("%s", err_str)
 	}
-	return map[string]int{models.TOTAL: len(slus), models.SluStatuses[models.SLU_STATUS_UNKNOWN]: slu_unknown_count, models.SluStatuses[models.SLU_STATUS_WARN]: slu_warning_count, models.SluStatuses[models.SLU_STATUS_ERROR]: slu_error_cnt, models.SluStatuses[models.SLU_STATUS_OK]: slu_ok_count, models.NEAR_FULL: len(sluThresholdEventsInDb), models.CriticalAlerts: sluCriticalAlertCount}, err
+	return summary.SluCounts(slus, len(sluThresholdEventsInDb)), err
 }
 
 func ComputeClustersStatusWiseCounts() (map[string]int, error) {
 	var err_str string
-	var clusters_in_error, clusters_in_warn int
-	clusterCriticalAlertCount := 0
 	nearFullClusters := 0
 	clusters, err := GetClusters(nil)
 	if err != nil && err != mgo.ErrNotFound {
@@ -167,12 +138,6 @@ func ComputeClustersStatusWiseCounts() (map[string]int, error) {
 	}
 
 	for _, cluster := range clusters {
-		if cluster.Status == models.CLUSTER_STATUS_ERROR {
-			clusters_in_error = clusters_in_error + 1
-		} else if cluster.Status == models.CLUSTER_STATUS_WARN {
-			clusters_in_warn = clusters_in_warn + 1
-		}
-		clusterCriticalAlertCount = clusterCriticalAlertCount + cluster.AlmCritCount
 		for _, tEvent := range clusterThresholdEvenstInDb {
 			if uuid.Equal(tEvent.EntityId, cluster.ClusterId) {
 				nearFullClusters = nearFullClusters + 1
@@ -184,7 +149,7 @@ func ComputeClustersStatusWiseCounts() (map[string]int, error) {
 	} else {
 		err = fmt.Errorf("%s", err_str)
 	}
-	return map[string]int{models.TOTAL: len(clusters), models.ClusterStatuses[models.CLUSTER_STATUS_ERROR]: clusters_in_error, models.ClusterStatuses[models.CLUSTER_STATUS_WARN]: clusters_in_warn, models.NEAR_FULL: nearFullClusters, models.CriticalAlerts: clusterCriticalAlertCount}, err
+	return summary.ClusterCounts(clusters, nearFullClusters), err
 }
 
 func fetchThresholdEvents(selectCriteria bson.M, utilizationType string) ([]models.ThresholdEvent, error) {
@@ -301,6 +266,9 @@ func UpdateDb(selectCriteria bson.M, update bson.M, collectionName string, ctxt
 	}
 }
 
+// UpdateStorageCountToSummaries recomputes the storage counts of the cluster
+// and of the system from scratch. The summary.Summarizer keeps them up to
+// date from the changes published, at a fraction of the cost.
 func UpdateStorageCountToSummaries(ctxt string, cluster models.Cluster) {
 	storageCnt, err := GetStorageCount(bson.M{"clusterid": cluster.ClusterId})
 	if err != nil {
@@ -324,6 +292,9 @@ func UpdateClusterStateWiseCount(ctxt string) {
 		UpdateDb(bson.M{"name": monitoring.SYSTEM}, bson.M{"clusterscount": clusterCount}, models.COLL_NAME_SKYRING_UTILIZATION, ctxt)
 	}
 }
+
+// UpdateSluCountToSummaries recomputes the slu counts of the cluster and of
+// the system from scratch, see UpdateStorageCountToSummaries.
 func UpdateSluCountToSummaries(ctxt string, cluster models.Cluster) {
 	sluCnt, err := ComputeSluStatusWiseCount(bson.M{"clusterid": cluster.ClusterId}, bson.M{"utilizationtype": monitoring.SLU_UTILIZATION, "clusterid": cluster.ClusterId, "thresholdseverity": models.CRITICAL})
 	if err != nil {
@@ -364,25 +335,13 @@ func FetchNodeStatusWiseCounts(selectCriteria bson.M) (map[string]int, error) {
 	sessionCopy := db.GetDatastore().Copy()
 	defer sessionCopy.Close()
 	var nodes []models.Node
-	error_nodes := 0
-	nodeCriticalAlertCount := 0
-	unmanagedNodesCount := 0
 	coll := sessionCopy.DB(conf.SystemConfig.DBConfig.Database).C(models.COLL_NAME_STORAGE_NODES)
 	if nodesError := coll.Find(nil).All(&nodes); nodesError != nil {
 		if nodesError != mgo.ErrNotFound {
-			return map[string]int{models.TOTAL: len(nodes), models.NodeStatuses[models.NODE_STATUS_ERROR]: error_nodes, models.NodeStates[models.NODE_STATE_UNACCEPTED]: unmanagedNodesCount, models.CriticalAlerts: nodeCriticalAlertCount}, fmt.Errorf("Failed to fetch nodes. error: %v", nodesError)
-		}
-	}
-	for _, node := range nodes {
-		nodeCriticalAlertCount = nodeCriticalAlertCount + node.AlmCritCount
-		if node.Status == models.NODE_STATUS_ERROR {
-			error_nodes = error_nodes + 1
-		}
-		if node.State == models.NODE_STATE_UNACCEPTED {
-			unmanagedNodesCount = unmanagedNodesCount + 1
+			return summary.NodeCounts(nil), fmt.Errorf("Failed to fetch nodes. error: %v", nodesError)
 		}
 	}
-	return map[string]int{models.TOTAL: len(nodes), models.NodeStatuses[models.NODE_STATUS_ERROR]: error_nodes, models.NodeStates[models.NODE_STATE_UNACCEPTED]: unmanagedNodesCount, models.CriticalAlerts: nodeCriticalAlertCount}, nil
+	return summary.NodeCounts(nodes), nil
 }
 
 func InitializeSystemSummary() {
@@ -9,6 +9,7 @@ import (
 	"github.com/skyrings/skyring-common/db"
 	"github.com/skyrings/skyring-common/models"
 	"github.com/skyrings/skyring-common/monitoring"
+	"github.com/skyrings/skyring-common/summary"
 	"github.com/skyrings/skyring-common/tools/logger"
 	"github.com/skyrings/skyring-common/tools/task"
 	"github.com/skyrings/skyring-common/tools/uuid"
@@ -218,6 +219,7 @@ func UpdateThresholdInfoToTable(tEvent models.ThresholdEvent) (err error, isRais
 				return fmt.Errorf("Failed to persist event %v to db.Error %v",
 					tEvent, err), true
 			}
+			summary.Publish(summary.ThresholdDelta(tEvent, false, tEvent.ThresholdSeverity == models.CRITICAL))
 			return nil, true
 		}
 	} else {
@@ -228,12 +230,14 @@ func UpdateThresholdInfoToTable(tEvent models.ThresholdEvent) (err error, isRais
 				return fmt.Errorf("Failed to update the db, that %v of %v is back to normal.Err %v",
 					tEvent.UtilizationType, tEvent.EntityName, err), true
 			}
+			summary.Publish(summary.ThresholdDelta(tEvent, tEventInDb.ThresholdSeverity == models.CRITICAL, false))
 			return nil, true
 		} else {
 			if err := collection.Update(selectCriteria, tEvent); err != nil {
 				return fmt.Errorf("Failed to persist event %v to db.Error %v",
 					tEvent, err), true
 			}
+			summary.Publish(summary.ThresholdDelta(tEvent, tEventInDb.ThresholdSeverity == models.CRITICAL, tEvent.ThresholdSeverity == models.CRITICAL))
 			return nil, true
 		}
 	}
@@ -243,7 +247,17 @@ func UpdateThresholdInfoToTable(tEvent models.ThresholdEvent) (err error, isRais
 func AnalyseThresholdBreach(ctxt string, utilizationType string, resourceName string, resourceUtilization float64, cluster models.Cluster) (models.Event, bool, error) {
 	var event models.Event
 	timeStamp := time.Now()
-	pluginIndex := monitoring.GetPluginIndex(utilizationType, cluster.Monitoring.Plugins)
+	entityId, entityThresholds, entityIdFetchError := getEntityIdFromNameAndUtilizationType(utilizationType, resourceName, cluster)
+	if entityIdFetchError != nil {
+		logger.Get().Error("%s - Error fetching the id for %v in cluster %v",
+			ctxt, resourceName, cluster.Name)
+		return models.Event{}, false, fmt.Errorf("%s - Error fetching the id for %v in cluster %v",
+			ctxt, resourceName, cluster.Name)
+	}
+	entityIdentifier := (*entityId).String()
+
+	plugins := monitoring.ApplyPluginsOverrides(monitoring.ResolvePlugins(monitoring.SystemThresholdPlugins(), cluster.Monitoring.Plugins), entityThresholds)
+	pluginIndex := monitoring.GetPluginIndex(utilizationType, plugins)
 	if pluginIndex == -1 {
 		logger.Get().Error(
 			"%s - The cluster %s is not configured to monitor threshold breaches for %v and hence cannot monitor utilization of %v",
@@ -253,21 +267,11 @@ func AnalyseThresholdBreach(ctxt string, utilizationType string, resourceName st
 				"%s - The cluster %s is not configured to monitor threshold breaches for %v and hence cannot monitor utilization of %v",
 				ctxt, cluster.Name, utilizationType, resourceName)
 	}
-	plugin := cluster.Monitoring.Plugins[pluginIndex]
+	plugin := plugins[pluginIndex]
 	var applicableConfig monitoring.PluginConfig
 	var applicableThresholdValue float64
 	var message string
 
-	var entityIdentifier string
-	entityId, entityIdFetchError := getEntityIdFromNameAndUtilizationType(utilizationType, resourceName, cluster)
-	if entityIdFetchError != nil {
-		logger.Get().Error("%s - Error fetching the id for %v in cluster %v",
-			ctxt, resourceName, cluster.Name)
-		return models.Event{}, false, fmt.Errorf("%s - Error fetching the id for %v in cluster %v",
-			ctxt, resourceName, cluster.Name)
-	}
-	entityIdentifier = (*entityId).String()
-
 	if len(plugin.Configs) == 0 {
 		return models.Event{}, false, fmt.Errorf("%s - No threshold configurations found for %v of %v in cluster %v",
 			ctxt, utilizationType, resourceName, cluster.Name)
@@ -335,38 +339,57 @@ func AnalyseThresholdBreach(ctxt string, utilizationType string, resourceName st
 	return models.Event{}, false, nil
 }
 
-func getEntityIdFromNameAndUtilizationType(utilizationType string, resourceName string, cluster models.Cluster) (*uuid.UUID, error) {
+// ResolveThresholds resolves the threshold plugins of an entity of the
+// utilization type: the system plugins overridden by those of the cluster,
+// and those by the thresholds of the entity.
+func ResolveThresholds(utilizationType string, resourceName string, cluster models.Cluster) ([]monitoring.Plugin, error) {
+	_, entityThresholds, err := getEntityIdFromNameAndUtilizationType(utilizationType, resourceName, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return monitoring.ApplyPluginsOverrides(monitoring.ResolvePlugins(monitoring.SystemThresholdPlugins(), cluster.Monitoring.Plugins), entityThresholds), nil
+}
+
+// ResolveNodeThresholds resolves the threshold plugins of the node, as
+// applied by collectd on it.
+func ResolveNodeThresholds(node models.Node, cluster models.Cluster) []monitoring.Plugin {
+	return monitoring.ApplyPluginsOverrides(monitoring.ResolvePlugins(monitoring.SystemThresholdPlugins(), cluster.Monitoring.Plugins), node.Thresholds)
+}
+
+// getEntityIdFromNameAndUtilizationType returns the id of the entity and its
+// threshold overrides.
+func getEntityIdFromNameAndUtilizationType(utilizationType string, resourceName string, cluster models.Cluster) (*uuid.UUID, []monitoring.PluginOverride, error) {
 	switch utilizationType {
 	case monitoring.CLUSTER_UTILIZATION:
-		return &(cluster.ClusterId), nil
+		return &(cluster.ClusterId), nil, nil
 	case monitoring.SLU_UTILIZATION:
 		var slu models.StorageLogicalUnit
 		err := getEntity(cluster.ClusterId, resourceName, models.COLL_NAME_STORAGE_LOGICAL_UNITS, &slu)
 		if err != nil {
-			return nil, fmt.Errorf("Could not fetch osd with name %v in cluster %v.Error %v",
+			return nil, nil, fmt.Errorf("Could not fetch osd with name %v in cluster %v.Error %v",
 				resourceName, cluster.Name, err)
 		}
-		return &(slu.SluId), nil
+		return &(slu.SluId), slu.Thresholds, nil
 	case monitoring.STORAGE_UTILIZATION:
 		var storage models.Storage
 		err := getEntity(cluster.ClusterId, resourceName, models.COLL_NAME_STORAGE, &storage)
 		if err != nil {
-			return nil, fmt.Errorf("Could not fetch pool with name %v in cluster %v",
+			return nil, nil, fmt.Errorf("Could not fetch pool with name %v in cluster %v",
 				storage.Name, cluster.Name)
 		}
-		return &(storage.StorageId), nil
+		return &(storage.StorageId), storage.Thresholds, nil
 	case monitoring.STORAGE_PROFILE_UTILIZATION:
-		return &(cluster.ClusterId), nil
+		return &(cluster.ClusterId), nil, nil
 	case monitoring.BLOCK_DEVICE_UTILIZATION:
 		var bDevice models.BlockDevice
 		err := getEntity(cluster.ClusterId, resourceName, models.COLL_NAME_BLOCK_DEVICES, &bDevice)
 		if err != nil {
-			return nil, fmt.Errorf("Could not fetch block device with name %v in cluster %v.Error %v",
+			return nil, nil, fmt.Errorf("Could not fetch block device with name %v in cluster %v.Error %v",
 				resourceName, cluster.Name, err)
 		}
-		return &(bDevice.Id), nil
+		return &(bDevice.Id), nil, nil
 	}
-	return nil, fmt.Errorf("Unsupported utilization type %v", utilizationType)
+	return nil, nil, fmt.Errorf("Unsupported utilization type %v", utilizationType)
 }
 
 func getEntity(clusterId uuid.UUID, resourceName string, collectionName string, entity interface{}) error {